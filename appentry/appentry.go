@@ -4,10 +4,20 @@ import (
 	"time"
 
 	"github.com/imroc/req/v3"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/alipay"
 	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
 	"github.com/topjohncian/cloudreve-pro-epay/internal/cache"
 	"github.com/topjohncian/cloudreve-pro-epay/internal/controller"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/currency"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/merchant"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/netguard"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/outbox"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/payment"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/provider"
 	"github.com/topjohncian/cloudreve-pro-epay/internal/server"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/usdt"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/wechatpay"
 	"go.uber.org/fx"
 )
 
@@ -18,6 +28,16 @@ func AppEntry() []fx.Option {
 		fx.WithLogger(FxLogger),
 
 		cache.Cache(),
+		order.Module(),
+		outbox.Module(),
+		currency.Module(),
+		provider.Module(),
+		merchant.Module(),
+		alipay.Module(),
+		wechatpay.Module(),
+		usdt.Module(),
+		payment.Module(),
+		netguard.Module(),
 		fx.Provide(server.CreateHttp),
 		fx.Provide(func(c *appconf.Config) *req.Client {
 			if c.Debug {
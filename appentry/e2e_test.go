@@ -0,0 +1,645 @@
+package appentry
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/shopspring/decimal"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/cache"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/epay"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/sandbox"
+)
+
+// paidParams 生成订单支付成功的异步通知参数
+func paidParams(h *harness, orderNo, money string) map[string]string {
+	trade, ok := h.epay.Trade(orderNo)
+	if !ok {
+		h.t.Fatalf("易支付沙箱中没有订单 %s", orderNo)
+	}
+
+	return epay.GenerateParams(map[string]string{
+		"pid":          testPartnerID,
+		"trade_no":     trade.TradeNo,
+		"out_trade_no": orderNo,
+		"type":         trade.Type,
+		"name":         trade.Name,
+		"money":        money,
+		"trade_status": epay.TRADE_SUCCESS,
+	}, testEpayKey)
+}
+
+func TestPurchaseFlow(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, h *harness) {
+		orderNo := orderNo(t, "paid")
+		purchaseURL := h.createOrder(orderNo, 1234)
+		if h.redis != nil && !h.redis.Exists(order.OrderPrefix+orderNo) {
+			t.Fatal("订单未保存至 Redis")
+		}
+
+		if status := h.queryStatus(orderNo); status != "UNPAID" {
+			t.Fatalf("新订单状态为 %s，应为 UNPAID", status)
+		}
+
+		h.pay(purchaseURL, sandbox.ActionPay)
+
+		h.eventually(func() bool {
+			return h.cloudreve.notified(orderNo) == 1
+		}, "Cloudreve 未收到支付成功通知")
+		h.eventually(func() bool {
+			return h.order(orderNo).Status == order.StatusNotified
+		}, "订单未转为已通知状态")
+
+		if status := h.queryStatus(orderNo); status != "PAID" {
+			t.Fatalf("已支付订单状态为 %s，应为 PAID", status)
+		}
+		if orderInfo := h.order(orderNo); orderInfo.TradeNo == "" || orderInfo.PaymentType != "alipay" {
+			t.Fatalf("订单未记录支付信息: %+v", orderInfo)
+		}
+	})
+}
+
+func TestPurchasePageReload(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, h *harness) {
+		orderNo := orderNo(t, "reload")
+		purchaseURL := h.createOrder(orderNo, 100)
+
+		// 刷新支付页面时复用已发起的支付，不再以相同订单号请求 mapi.php
+		var pages []string
+		for i := 0; i < 3; i++ {
+			resp, err := h.client.Get(purchaseURL + "?type=alipay")
+			if err != nil {
+				t.Fatal(err)
+			}
+			page, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "/sandbox/pay/") {
+				t.Fatalf("支付页面返回 HTTP %d: %s", resp.StatusCode, page)
+			}
+			pages = append(pages, string(page))
+		}
+		if count := h.epayMapi.Load(); count != 1 {
+			t.Fatalf("易支付收到 %d 次 mapi 下单请求，应为 1 次", count)
+		}
+		if pages[1] != pages[0] || pages[2] != pages[0] {
+			t.Fatal("刷新后的支付页面与首次不同")
+		}
+		if orderInfo := h.order(orderNo); orderInfo.Status != order.StatusPending || orderInfo.TradeNo == "" {
+			t.Fatalf("订单未记录易支付订单号: %+v", orderInfo)
+		}
+	}, func(conf *appconf.Config) {
+		conf.EpayMode = "mapi"
+	})
+}
+
+func TestNotificationBadSign(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, h *harness) {
+		orderNo := orderNo(t, "badsign")
+		purchaseURL := h.createOrder(orderNo, 100)
+		h.pay(purchaseURL, sandbox.ActionFail)
+
+		params := paidParams(h, orderNo, "1.00")
+		params["sign"] = "00000000000000000000000000000000"
+		if code := h.notify(orderNo, params); code != 400 {
+			t.Fatalf("签名错误的通知返回 %d，应为 400", code)
+		}
+
+		if orderInfo := h.order(orderNo); orderInfo.IsPaid() {
+			t.Fatalf("签名错误的通知使订单变为 %s", orderInfo.Status)
+		}
+		if status := h.queryStatus(orderNo); status != "UNPAID" {
+			t.Fatalf("订单状态为 %s，应为 UNPAID", status)
+		}
+	})
+}
+
+func TestNotificationWrongAmount(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, h *harness) {
+		orderNo := orderNo(t, "underpay")
+		purchaseURL := h.createOrder(orderNo, 100)
+		h.pay(purchaseURL, sandbox.ActionUnderpay)
+
+		if code := h.notify(orderNo, paidParams(h, orderNo, "0.99")); code != 400 {
+			t.Fatalf("金额不符的通知返回 %d，应为 400", code)
+		}
+
+		// 主动查询同样会发现金额不符
+		if status := h.queryStatus(orderNo); status != "UNPAID" {
+			t.Fatalf("少付订单状态为 %s，应为 UNPAID", status)
+		}
+		if count := h.epayQueries.Load(); count != 1 {
+			t.Fatalf("易支付收到 %d 次订单查询，应为 1 次", count)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if count := h.cloudreve.notified(orderNo); count != 0 {
+			t.Fatalf("少付订单通知了 Cloudreve %d 次", count)
+		}
+	}, func(conf *appconf.Config) {
+		conf.ReconcileMinAge = 0
+	})
+}
+
+func TestQueryStatusMinAge(t *testing.T) {
+	// 等待支付未超过 ReconcileMinAge 的订单不主动查询
+	forEachDriver(t, func(t *testing.T, h *harness) {
+		orderNo := orderNo(t, "young")
+		h.pay(h.createOrder(orderNo, 100), sandbox.ActionFail)
+
+		for i := 0; i < 3; i++ {
+			if status := h.queryStatus(orderNo); status != "UNPAID" {
+				t.Fatalf("订单状态为 %s，应为 UNPAID", status)
+			}
+		}
+		if count := h.epayQueries.Load(); count != 0 {
+			t.Fatalf("易支付收到 %d 次订单查询，应为 0 次", count)
+		}
+	})
+}
+
+func TestQueryStatusThrottle(t *testing.T) {
+	// 同一订单在 ReconcileQueryInterval 内只查询一次
+	forEachDriver(t, func(t *testing.T, h *harness) {
+		orderNo := orderNo(t, "throttle")
+		h.pay(h.createOrder(orderNo, 100), sandbox.ActionFail)
+
+		for i := 0; i < 3; i++ {
+			if status := h.queryStatus(orderNo); status != "UNPAID" {
+				t.Fatalf("订单状态为 %s，应为 UNPAID", status)
+			}
+		}
+		if count := h.epayQueries.Load(); count != 1 {
+			t.Fatalf("易支付收到 %d 次订单查询，应为 1 次", count)
+		}
+	}, func(conf *appconf.Config) {
+		conf.ReconcileMinAge = 0
+		conf.ReconcileQueryInterval = time.Hour
+	})
+}
+
+func TestNotificationDuplicate(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, h *harness) {
+		orderNo := orderNo(t, "duplicate")
+		purchaseURL := h.createOrder(orderNo, 100)
+		h.pay(purchaseURL, sandbox.ActionPay)
+
+		h.eventually(func() bool {
+			return h.order(orderNo).Status == order.StatusNotified
+		}, "订单未转为已通知状态")
+
+		params := paidParams(h, orderNo, "1.00")
+		for i := 0; i < 3; i++ {
+			if code := h.notify(orderNo, params); code != 0 {
+				t.Fatalf("重复通知返回 %d，应为 0", code)
+			}
+		}
+
+		time.Sleep(50 * time.Millisecond)
+		if count := h.cloudreve.notified(orderNo); count != 1 {
+			t.Fatalf("重复通知使 Cloudreve 收到 %d 次通知，应为 1 次", count)
+		}
+	})
+}
+
+func TestNotificationConcurrent(t *testing.T) {
+	redis := miniredis.RunT(t)
+	h := newHarness(t, redis)
+	// 两个实例共用同一 Redis
+	peer := newHarness(t, redis)
+
+	orderNo := orderNo(t, "concurrent")
+	purchaseURL := h.createOrder(orderNo, 100)
+	h.pay(purchaseURL, sandbox.ActionFail)
+
+	query := url.Values{}
+	for key, value := range paidParams(h, orderNo, "1.00") {
+		query.Set(key, value)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		base := h.conf.Base
+		if i%2 == 1 {
+			base = peer.conf.Base
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get(base + "/api/v4/callback/custom/" + orderNo + "?" + query.Encode())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	wg.Wait()
+
+	h.eventually(func() bool {
+		return h.order(orderNo).Status == order.StatusNotified
+	}, "订单未转为已通知状态")
+	time.Sleep(100 * time.Millisecond)
+	if count := h.cloudreve.notified(orderNo); count != 1 {
+		t.Fatalf("并发的支付成功通知使 Cloudreve 收到 %d 次通知，应为 1 次", count)
+	}
+
+	paid := 0
+	for _, transition := range h.order(orderNo).History {
+		if transition.To == order.StatusPaid {
+			paid++
+		}
+	}
+	if paid != 1 {
+		t.Fatalf("订单被标记为已支付 %d 次", paid)
+	}
+}
+
+func TestCloudreveDowntime(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, h *harness) {
+		orderNo := orderNo(t, "downtime")
+		purchaseURL := h.createOrder(orderNo, 100)
+
+		h.cloudreve.down.Store(true)
+		h.pay(purchaseURL, sandbox.ActionPay)
+
+		// Cloudreve 不可用期间订单保持已支付状态，通知留在发件箱中重试
+		time.Sleep(100 * time.Millisecond)
+		if orderInfo := h.order(orderNo); orderInfo.Status != order.StatusPaid {
+			t.Fatalf("Cloudreve 不可用时订单状态为 %s，应为 PAID", orderInfo.Status)
+		}
+		if status := h.queryStatus(orderNo); status != "PAID" {
+			t.Fatalf("订单状态为 %s，应为 PAID", status)
+		}
+
+		h.cloudreve.down.Store(false)
+		h.eventually(func() bool {
+			return h.order(orderNo).Status == order.StatusNotified
+		}, "Cloudreve 恢复后订单未转为已通知状态")
+		if count := h.cloudreve.notified(orderNo); count != 1 {
+			t.Fatalf("Cloudreve 收到 %d 次通知，应为 1 次", count)
+		}
+	})
+}
+
+func TestCurrencyConversion(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, h *harness) {
+		orderNo := orderNo(t, "usd")
+		purchaseURL := h.createOrderIn(orderNo, 999, "usd")
+
+		// 9.99 USD × 7.1234 = 71.162766 CNY，四舍五入为 71.16
+		orderInfo := h.order(orderNo)
+		if orderInfo.Amount != 7116 || orderInfo.Currency != "CNY" ||
+			orderInfo.OriginalAmount != 999 || orderInfo.OriginalCurrency != "USD" || orderInfo.ExchangeRate != "7.1234" {
+			t.Fatalf("订单金额换算错误: %+v", orderInfo)
+		}
+
+		// 按原始金额发送的通知应被拒绝
+		h.pay(purchaseURL, sandbox.ActionFail)
+		if code := h.notify(orderNo, paidParams(h, orderNo, "9.99")); code != 400 {
+			t.Fatalf("按原始金额发送的通知返回 %d，应为 400", code)
+		}
+
+		if code := h.notify(orderNo, paidParams(h, orderNo, "71.16")); code != 0 {
+			t.Fatalf("按换算后金额发送的通知返回 %d，应为 0", code)
+		}
+		h.eventually(func() bool {
+			return h.cloudreve.notified(orderNo) == 1
+		}, "Cloudreve 未收到支付成功通知")
+	}, func(conf *appconf.Config) {
+		conf.CurrencyRates = map[string]string{"USD": "7.1234"}
+	})
+}
+
+func TestCurrencyUnknown(t *testing.T) {
+	h := newHarness(t, nil)
+
+	body, _ := json.Marshal(map[string]any{
+		"name":       "测试商品",
+		"order_no":   orderNo(t, "eur"),
+		"notify_url": h.cloudreve.notifyURL("eur"),
+		"amount":     100,
+		"currency":   "EUR",
+	})
+	if res := h.signedRequest(http.MethodPost, "/cloudreve/purchase", body); res["code"] != float64(400) {
+		t.Fatalf("没有汇率的货币返回 %v，应为 400", res["code"])
+	}
+}
+
+func TestPurchaseReplay(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, h *harness) {
+		orderNo := orderNo(t, "replay")
+		purchaseURL := h.createOrder(orderNo, 100)
+		created := h.order(orderNo)
+
+		request := func(amount int, notifyURL string) map[string]any {
+			body, _ := json.Marshal(map[string]any{
+				"name":       "测试商品",
+				"order_no":   orderNo,
+				"notify_url": notifyURL,
+				"amount":     amount,
+			})
+			return h.signedRequest(http.MethodPost, "/cloudreve/purchase", body)
+		}
+
+		// 相同的重复请求返回相同的支付地址
+		if res := request(100, h.cloudreve.notifyURL(orderNo)); res["code"] != float64(0) || res["data"] != purchaseURL {
+			t.Fatalf("重复的创建订单请求返回 %v，应返回 %s", res, purchaseURL)
+		}
+
+		// 内容不同的请求不会覆盖已有订单
+		if res := request(1, h.cloudreve.notifyURL(orderNo)); res["code"] != float64(409) {
+			t.Fatalf("金额不同的创建订单请求返回 %v，应为 409", res["code"])
+		}
+		if res := request(100, h.cloudreve.notifyURL("other")); res["code"] != float64(409) {
+			t.Fatalf("通知地址不同的创建订单请求返回 %v，应为 409", res["code"])
+		}
+		if orderInfo := h.order(orderNo); orderInfo.Amount != 100 || !orderInfo.CreatedAt.Equal(created.CreatedAt) {
+			t.Fatalf("已有订单被覆盖: %+v", orderInfo)
+		}
+
+		// 已支付的订单不会被修改
+		h.pay(purchaseURL, sandbox.ActionPay)
+		h.eventually(func() bool {
+			return h.order(orderNo).Status == order.StatusNotified
+		}, "订单未转为已通知状态")
+
+		if res := request(100, h.cloudreve.notifyURL(orderNo)); res["code"] != float64(0) || res["data"] != purchaseURL {
+			t.Fatalf("已支付订单的重复请求返回 %v", res)
+		}
+		if res := request(1, h.cloudreve.notifyURL(orderNo)); res["code"] != float64(409) {
+			t.Fatalf("已支付订单的冲突请求返回 %v，应为 409", res["code"])
+		}
+		if orderInfo := h.order(orderNo); orderInfo.Status != order.StatusNotified || orderInfo.Amount != 100 {
+			t.Fatalf("已支付订单被修改: %+v", orderInfo)
+		}
+	})
+}
+
+func TestNotifyURLNotAllowed(t *testing.T) {
+	h := newHarness(t, nil)
+
+	for _, notifyURL := range []string{
+		"http://evil.example.com/api/v4/callback/notify/x",
+		"ftp://127.0.0.1/api/v4/callback/notify/x",
+		"http://169.254.169.254/latest/meta-data",
+	} {
+		body, _ := json.Marshal(map[string]any{
+			"name":       "测试商品",
+			"order_no":   orderNo(t, "ssrf"),
+			"notify_url": notifyURL,
+			"amount":     100,
+		})
+		if res := h.signedRequest(http.MethodPost, "/cloudreve/purchase", body); res["code"] != float64(400) {
+			t.Fatalf("通知地址 %s 返回 %v，应为 400", notifyURL, res["code"])
+		}
+	}
+}
+
+func TestNotifyURLResolvesToLoopback(t *testing.T) {
+	h := newHarness(t, nil, func(conf *appconf.Config) {
+		conf.NotifyAllowedHosts = []string{"localhost"}
+		conf.NotifyAllowedNetworks = nil
+	})
+
+	// 主机名在允许列表中，但解析到回环地址，连接时被拒绝
+	orderNo := orderNo(t, "rebind")
+	notifyURL := strings.Replace(h.cloudreve.notifyURL(orderNo), "127.0.0.1", "localhost", 1)
+	body, _ := json.Marshal(map[string]any{
+		"name":       "测试商品",
+		"order_no":   orderNo,
+		"notify_url": notifyURL,
+		"amount":     100,
+	})
+	res := h.signedRequest(http.MethodPost, "/cloudreve/purchase", body)
+	if res["code"] != float64(0) {
+		t.Fatalf("创建订单失败: %v", res)
+	}
+	h.pay(res["data"].(string), sandbox.ActionPay)
+
+	time.Sleep(100 * time.Millisecond)
+	if count := h.cloudreve.notified(orderNo); count != 0 {
+		t.Fatalf("解析到回环地址的通知地址收到 %d 次通知", count)
+	}
+	if orderInfo := h.order(orderNo); orderInfo.Status != order.StatusPaid {
+		t.Fatalf("订单状态为 %s，应为 PAID", orderInfo.Status)
+	}
+}
+
+func TestProviderNotifyAck(t *testing.T) {
+	h := newHarness(t, nil)
+
+	orderNo := orderNo(t, "ack")
+	purchaseURL := h.createOrder(orderNo, 100)
+	h.pay(purchaseURL, sandbox.ActionFail)
+
+	ack := func(params map[string]string) string {
+		query := url.Values{}
+		for key, value := range params {
+			query.Set(key, value)
+		}
+		resp, err := h.client.PostForm(h.conf.Base+"/payment/epay/notify/"+orderNo, query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	params := paidParams(h, orderNo, "1.00")
+	params["sign"] = "00000000000000000000000000000000"
+	if body := ack(params); body != "fail" {
+		t.Fatalf("签名错误的通知响应 %q，应为 fail", body)
+	}
+	if body := ack(paidParams(h, orderNo, "1.00")); body != "success" {
+		t.Fatalf("有效通知响应 %q，应为 success", body)
+	}
+	h.eventually(func() bool {
+		return h.cloudreve.notified(orderNo) == 1
+	}, "Cloudreve 未收到支付成功通知")
+}
+
+// fakeTronGrid 模拟 TronGrid，记录转入收款地址的 TRC20 USDT 转账
+type fakeTronGrid struct {
+	server *httptest.Server
+
+	mu        sync.Mutex
+	transfers []map[string]any
+}
+
+func newFakeTronGrid(t *testing.T) *fakeTronGrid {
+	tg := &fakeTronGrid{}
+	tg.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tg.mu.Lock()
+		defer tg.mu.Unlock()
+
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/transactions/trc20"):
+			_ = json.NewEncoder(w).Encode(map[string]any{"success": true, "data": tg.transfers})
+		case r.URL.Path == "/wallet/getnowblock":
+			_, _ = w.Write([]byte(`{"block_header":{"raw_data":{"number":120}}}`))
+		case r.URL.Path == "/wallet/gettransactioninfobyid":
+			_, _ = w.Write([]byte(`{"blockNumber":100}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(tg.server.Close)
+	return tg
+}
+
+// send 模拟一笔转账，amount 为 USDT 金额
+func (tg *fakeTronGrid) send(txID, to, amount string) {
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+
+	value := decimal.RequireFromString(amount).Shift(6)
+	tg.transfers = append(tg.transfers, map[string]any{
+		"transaction_id":  txID,
+		"token_info":      map[string]any{"address": "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t", "decimals": 6},
+		"block_timestamp": time.Now().UnixMilli(),
+		"to":              to,
+		"type":            "Transfer",
+		"value":           value.String(),
+	})
+}
+
+var transferAmountPattern = regexp.MustCompile(`<strong>([0-9.]+) USDT</strong>`)
+
+func TestUSDTPurchase(t *testing.T) {
+	const address = "TTestReceivingAddress000000000000"
+	tronGrid := newFakeTronGrid(t)
+
+	forEachDriver(t, func(t *testing.T, h *harness) {
+		orderNo := orderNo(t, "usdt")
+		purchaseURL := h.createOrder(orderNo, 7200)
+
+		resp, err := h.client.Get(purchaseURL + "?type=usdt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		matches := transferAmountPattern.FindStringSubmatch(string(page))
+		if matches == nil || !strings.Contains(string(page), address) {
+			t.Fatalf("支付页面中没有收款地址与金额: %s", page)
+		}
+		// 72 元按 7.2 的汇率换算为 10 USDT
+		if matches[1] != "10.00" {
+			t.Fatalf("应转账 10.00 USDT，实际为 %s", matches[1])
+		}
+
+		tronGrid.send(orderNo, address, matches[1])
+		h.eventually(func() bool {
+			return h.cloudreve.notified(orderNo) == 1
+		}, "Cloudreve 未收到支付成功通知")
+
+		orderInfo := h.order(orderNo)
+		if orderInfo.Provider != "usdt" || orderInfo.TradeNo != orderNo || orderInfo.PaymentType != "usdt" {
+			t.Fatalf("订单信息错误: %+v", orderInfo)
+		}
+	}, func(conf *appconf.Config) {
+		conf.EpayPurchaseMethods = []string{"alipay", "usdt|USDT||usdt"}
+		conf.CurrencyRates = map[string]string{"USDT": "7.2"}
+		conf.UsdtAddress = address
+		conf.UsdtAPIEndpoint = tronGrid.server.URL
+		conf.UsdtPollInterval = 20 * time.Millisecond
+	})
+}
+
+func TestUpgradeFromGob(t *testing.T) {
+	redis := miniredis.RunT(t)
+	old := newHarness(t, redis)
+
+	orderNo := orderNo(t, "gob")
+	purchaseURL := old.createOrder(orderNo, 100)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := old.app.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// 以旧版本的 gob 编码改写订单与索引，模拟升级前写入的数据
+	store := cache.NewRedisStore(&cache.RedisOptions{Mode: cache.RedisStandalone, Addrs: []string{redis.Addr()}, GobFallback: true})
+	gobEncode := func(value interface{}) string {
+		var buffer bytes.Buffer
+		if err := gob.NewEncoder(&buffer).Encode(struct{ Value interface{} }{value}); err != nil {
+			t.Fatal(err)
+		}
+		return buffer.String()
+	}
+	for _, key := range redis.Keys() {
+		if value, ok := store.Get(key); ok {
+			redis.Set(key, gobEncode(value))
+		}
+	}
+	// 同一数据库中 Cloudreve 以相同格式写入的值
+	foreign := gobEncode("Cloudreve")
+	redis.Set("setting_siteName", foreign)
+
+	migrated, err := store.MigrateLegacy(ctx, cacheKeyPrefixes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated == 0 {
+		t.Fatal("没有改写任何旧版本的值")
+	}
+	if raw, _ := redis.Get(order.OrderPrefix + orderNo); !strings.HasPrefix(raw, "{") {
+		t.Fatal("旧版本的订单未被改写为 JSON")
+	}
+	if raw, _ := redis.Get("setting_siteName"); raw != foreign {
+		t.Fatal("Cloudreve 的缓存值被改写")
+	}
+
+	h := newHarness(t, redis)
+	h.pay(strings.Replace(purchaseURL, old.conf.Base, h.conf.Base, 1), sandbox.ActionPay)
+	h.eventually(func() bool {
+		return h.order(orderNo).Status == order.StatusNotified
+	}, "升级前创建的订单未能完成支付")
+	// 通知地址为升级前的 Cloudreve
+	if count := old.cloudreve.notified(orderNo); count != 1 {
+		t.Fatalf("Cloudreve 收到 %d 次通知，应为 1 次", count)
+	}
+}
+
+func TestWithoutEpay(t *testing.T) {
+	const address = "TTestReceivingAddress000000000000"
+	tronGrid := newFakeTronGrid(t)
+
+	// 未配置易支付商户，仅启用 USDT 渠道
+	h := newHarness(t, nil, func(conf *appconf.Config) {
+		conf.EpayPartnerID = ""
+		conf.EpayKey = ""
+		conf.EpayEndpoint = ""
+		conf.PaymentProvider = "usdt"
+		conf.EpayPurchaseMethods = []string{"usdt|USDT||usdt"}
+		conf.CurrencyRates = map[string]string{"USDT": "7.2"}
+		conf.UsdtAddress = address
+		conf.UsdtAPIEndpoint = tronGrid.server.URL
+	})
+
+	orderNo := orderNo(t, "noepay")
+	purchaseURL := h.createOrder(orderNo, 7200)
+	resp, err := h.client.Get(purchaseURL + "?type=usdt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), address) {
+		t.Fatalf("支付页面中没有收款地址: %s", page)
+	}
+}
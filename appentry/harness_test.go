@@ -0,0 +1,366 @@
+package appentry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/imroc/req/v3"
+	"github.com/sirupsen/logrus"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/controller"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/sandbox"
+	"go.uber.org/fx"
+)
+
+const (
+	testCloudreveKey = "test-cloudreve-key"
+	testPartnerID    = "1000"
+	testEpayKey      = "test-epay-key"
+)
+
+var (
+	formActionPattern = regexp.MustCompile(`action='([^']+)'`)
+	formInputPattern  = regexp.MustCompile(`name='([^']+)' value='([^']*)'`)
+)
+
+// fakeCloudreve 模拟 Cloudreve，记录收到的支付成功通知
+type fakeCloudreve struct {
+	t      *testing.T
+	server *httptest.Server
+	auth   controller.HMACAuth
+	down   atomic.Bool
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func newFakeCloudreve(t *testing.T) *fakeCloudreve {
+	cr := &fakeCloudreve{
+		t:    t,
+		auth: controller.HMACAuth{CloudreveKey: []byte(testCloudreveKey)},
+	}
+	cr.server = httptest.NewServer(http.HandlerFunc(cr.handle))
+	t.Cleanup(cr.server.Close)
+	return cr
+}
+
+func (cr *fakeCloudreve) handle(w http.ResponseWriter, r *http.Request) {
+	if cr.down.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	sign := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if err := cr.auth.Check(controller.NewRequestSignString(r.URL.Path, "", ""), sign); err != nil {
+		cr.t.Errorf("Cloudreve 收到的通知签名无效: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	cr.mu.Lock()
+	cr.calls = append(cr.calls, r.URL.Path)
+	cr.mu.Unlock()
+
+	_, _ = w.Write([]byte(`{"code":0}`))
+}
+
+// notifyURL 返回订单的通知地址
+func (cr *fakeCloudreve) notifyURL(orderNo string) string {
+	return cr.server.URL + "/api/v4/callback/notify/" + orderNo
+}
+
+// notified 返回订单收到通知的次数
+func (cr *fakeCloudreve) notified(orderNo string) int {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	count := 0
+	for _, path := range cr.calls {
+		if path == "/api/v4/callback/notify/"+orderNo {
+			count++
+		}
+	}
+	return count
+}
+
+// harness 在进程内启动完整的 fx 应用，并连接模拟的 Cloudreve 与易支付沙箱
+type harness struct {
+	t *testing.T
+
+	conf      *appconf.Config
+	orders    order.Repository
+	app       *fx.App
+	server    *httptest.Server
+	epay      *sandbox.Server
+	cloudreve *fakeCloudreve
+	redis     *miniredis.Miniredis
+
+	// 易支付沙箱收到的订单查询与 mapi 下单请求数
+	epayQueries atomic.Int32
+	epayMapi    atomic.Int32
+
+	// 不跟随跳转的 HTTP 客户端
+	client *http.Client
+}
+
+// forEachDriver 分别使用内存与 Redis 存储运行测试，configure 用于修改默认配置
+func forEachDriver(t *testing.T, fn func(t *testing.T, h *harness), configure ...func(*appconf.Config)) {
+	t.Run("memory", func(t *testing.T) {
+		fn(t, newHarness(t, nil, configure...))
+	})
+	t.Run("redis", func(t *testing.T) {
+		fn(t, newHarness(t, miniredis.RunT(t), configure...))
+	})
+}
+
+func newHarness(t *testing.T, redis *miniredis.Miniredis, configure ...func(*appconf.Config)) *harness {
+	logrus.SetOutput(io.Discard)
+
+	h := &harness{
+		t:         t,
+		cloudreve: newFakeCloudreve(t),
+		redis:     redis,
+		epay:      sandbox.New(testPartnerID, testEpayKey, req.C()),
+		client: &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+
+	epayHandler := h.epay.Handler()
+	epayServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api.php" && r.URL.Query().Get("act") == "order":
+			h.epayQueries.Add(1)
+		case r.URL.Path == "/mapi.php":
+			h.epayMapi.Add(1)
+		}
+		epayHandler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(epayServer.Close)
+
+	// 先创建监听以获得本站点地址
+	h.server = httptest.NewUnstartedServer(nil)
+	t.Cleanup(h.server.Close)
+
+	// 通过环境变量加载配置，其余配置项使用默认值
+	t.Setenv("CR_EPAY_BASE", "http://"+h.server.Listener.Addr().String())
+	t.Setenv("CR_EPAY_CLOUDREVE_KEY", testCloudreveKey)
+	t.Setenv("CR_EPAY_EPAY_PARTNER_ID", testPartnerID)
+	t.Setenv("CR_EPAY_EPAY_KEY", testEpayKey)
+	t.Setenv("CR_EPAY_EPAY_ENDPOINT", epayServer.URL+"/submit.php")
+	// 模拟的 Cloudreve 监听在回环地址上
+	t.Setenv("CR_EPAY_NOTIFY_ALLOWED_NETWORKS", "127.0.0.1")
+
+	conf, err := appconf.Parse()
+	if err != nil {
+		t.Fatalf("无法加载配置: %v", err)
+	}
+	conf.NotifyPollInterval = 10 * time.Millisecond
+	conf.NotifyRetryBase = 10 * time.Millisecond
+	conf.NotifyRetryMax = 50 * time.Millisecond
+	conf.ReconcileInterval = 0
+	if redis != nil {
+		conf.RedisEnabled = true
+		conf.RedisServer = redis.Addr()
+	}
+	for _, fn := range configure {
+		fn(conf)
+	}
+	h.conf = conf
+
+	var engine *gin.Engine
+	opts := []fx.Option{}
+	opts = append(opts, fx.Supply(fx.Annotate(os.DirFS(".."), fx.As(new(fs.FS)))))
+	opts = append(opts, AppEntry()...)
+	opts = append(opts,
+		fx.Decorate(func(*appconf.Config) *appconf.Config { return h.conf }),
+		fx.Populate(&engine, &h.orders),
+		fx.NopLogger,
+	)
+
+	h.app = fx.New(opts...)
+	if err := h.app.Err(); err != nil {
+		t.Fatalf("无法初始化应用: %v", err)
+	}
+
+	h.server.Config.Handler = engine
+	h.server.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := h.app.Start(ctx); err != nil {
+		t.Fatalf("无法启动应用: %v", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = h.app.Stop(ctx)
+	})
+
+	return h
+}
+
+// signedRequest 以 Cloudreve 的身份发送带签名的请求
+func (h *harness) signedRequest(method, path string, body []byte) map[string]any {
+	h.t.Helper()
+
+	r, err := http.NewRequest(method, h.conf.Base+path, bytes.NewReader(body))
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	controller.SignRequest(controller.HMACAuth{CloudreveKey: []byte(testCloudreveKey)}, r, 60)
+
+	return h.doJSON(r)
+}
+
+func (h *harness) doJSON(r *http.Request) map[string]any {
+	h.t.Helper()
+
+	resp, err := h.client.Do(r)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var res map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		h.t.Fatalf("无法解析 %s 的响应: %v", r.URL.Path, err)
+	}
+	return res
+}
+
+// createOrder 以 Cloudreve 的身份创建订单，返回支付页面地址
+func (h *harness) createOrder(orderNo string, amount int) string {
+	h.t.Helper()
+
+	return h.createOrderIn(orderNo, amount, "")
+}
+
+// createOrderIn 以 Cloudreve 的身份创建指定货币的订单，返回支付页面地址
+func (h *harness) createOrderIn(orderNo string, amount int, currency string) string {
+	h.t.Helper()
+
+	body, _ := json.Marshal(map[string]any{
+		"name":       "测试商品",
+		"order_no":   orderNo,
+		"notify_url": h.cloudreve.notifyURL(orderNo),
+		"amount":     amount,
+		"currency":   currency,
+	})
+	res := h.signedRequest(http.MethodPost, "/cloudreve/purchase", body)
+	if res["code"] != float64(0) {
+		h.t.Fatalf("创建订单失败: %v", res)
+	}
+	return res["data"].(string)
+}
+
+// queryStatus 以 Cloudreve 的身份查询订单状态
+func (h *harness) queryStatus(orderNo string) string {
+	h.t.Helper()
+
+	res := h.signedRequest(http.MethodGet, "/cloudreve/purchase?order_no="+url.QueryEscape(orderNo), nil)
+	status, _ := res["data"].(string)
+	return status
+}
+
+// pay 打开支付页面，将表单提交至易支付沙箱，并在沙箱支付页面上选择 action
+func (h *harness) pay(purchaseURL, action string) {
+	h.t.Helper()
+
+	resp, err := h.client.Get(purchaseURL)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	matches := formActionPattern.FindStringSubmatch(string(page))
+	if matches == nil {
+		h.t.Fatalf("支付页面中没有支付表单: %s", page)
+	}
+	form := url.Values{}
+	for _, input := range formInputPattern.FindAllStringSubmatch(string(page), -1) {
+		form.Set(input[1], input[2])
+	}
+
+	resp, err = h.client.PostForm(matches[1], form)
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		h.t.Fatalf("易支付沙箱拒绝了支付请求: HTTP %d", resp.StatusCode)
+	}
+
+	payURL, _ := url.Parse(matches[1])
+	payURL, _ = payURL.Parse(resp.Header.Get("Location"))
+	resp, err = h.client.PostForm(payURL.String(), url.Values{"action": {action}})
+	if err != nil {
+		h.t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		h.t.Fatalf("易支付沙箱支付失败: HTTP %d", resp.StatusCode)
+	}
+}
+
+// notify 以易支付的身份向 Cloudreve V4 回调地址发送异步通知
+func (h *harness) notify(orderNo string, params map[string]string) int {
+	h.t.Helper()
+
+	query := url.Values{}
+	for key, value := range params {
+		query.Set(key, value)
+	}
+	r, _ := http.NewRequest(http.MethodGet, h.conf.Base+"/api/v4/callback/custom/"+orderNo+"?"+query.Encode(), nil)
+	code, _ := h.doJSON(r)["code"].(float64)
+	return int(code)
+}
+
+// order 读取订单
+func (h *harness) order(orderNo string) *order.Order {
+	h.t.Helper()
+
+	orderInfo, err := h.orders.Get(orderNo)
+	if err != nil {
+		h.t.Fatalf("无法读取订单 %s: %v", orderNo, err)
+	}
+	return orderInfo
+}
+
+// eventually 在超时前反复检查条件
+func (h *harness) eventually(cond func() bool, msg string) {
+	h.t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			h.t.Fatal(msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// orderNo 生成测试订单号
+func orderNo(t *testing.T, suffix string) string {
+	return strings.NewReplacer("/", "-", " ", "-").Replace(t.Name()) + "-" + suffix + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+}
@@ -0,0 +1,38 @@
+package appentry
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/cache"
+)
+
+// cacheKeyPrefixes 本程序写入缓存的键前缀，同一 Redis 数据库中的其他键不会被改写
+var cacheKeyPrefixes = []string{"order_", "outbox_", "usdt_", "purchase_session_", "paid_order_"}
+
+// MigrateCache 将 Redis 中旧版本以 gob 编码写入的值改写为 JSON
+func MigrateCache() {
+	conf, err := appconf.Parse()
+	if err != nil {
+		logrus.WithError(err).Fatalln("无法加载配置")
+	}
+	Log(conf)
+
+	if !conf.RedisEnabled {
+		logrus.Fatalln("未启用 Redis，无需改写缓存")
+	}
+
+	opts, err := cache.NewRedisOptions(conf)
+	if err != nil {
+		logrus.WithError(err).Fatalln("无法加载 Redis 配置")
+	}
+	// 改写需要读取旧版本的值，不受 CR_EPAY_REDIS_GOB_FALLBACK 影响
+	opts.GobFallback = true
+
+	migrated, err := cache.NewRedisStore(opts).MigrateLegacy(context.Background(), cacheKeyPrefixes)
+	if err != nil {
+		logrus.WithError(err).WithField("count", migrated).Fatalln("改写旧版本缓存值失败")
+	}
+	logrus.WithField("count", migrated).Infoln("已将旧版本缓存值改写为 JSON")
+}
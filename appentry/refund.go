@@ -0,0 +1,54 @@
+package appentry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/payment"
+	"go.uber.org/fx"
+)
+
+// ErrRefundRequiresRedis 命令行退款需要与运行中的服务共用 Redis
+var ErrRefundRequiresRedis = errors.New("未启用 Redis，命令行无法读取运行中服务的订单，也无法与其共用退款锁")
+
+// Refund 在命令行中对订单退款，amount 为退款金额（单位为分），为 0 时全额退款
+func Refund(templateFS fs.FS, orderNo string, amount int, reason string) error {
+	var (
+		conf    *appconf.Config
+		service *payment.Service
+	)
+
+	opts := []fx.Option{}
+	opts = append(opts, fx.Supply(fx.Annotate(templateFS, fx.As(new(fs.FS)))))
+	opts = append(opts, AppEntry()...)
+	opts = append(opts, fx.Populate(&conf, &service), fx.NopLogger)
+
+	app := fx.New(opts...)
+	if err := app.Err(); err != nil {
+		return fmt.Errorf("无法初始化: %w", err)
+	}
+
+	if !conf.RedisEnabled {
+		return ErrRefundRequiresRedis
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	order, err := service.Refund(ctx, orderNo, amount, reason)
+	if err != nil {
+		return err
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"order_no":        order.OrderNo,
+		"refunded_amount": order.RefundedAmount,
+		"status":          order.Status,
+	}).Infoln("退款成功")
+	return nil
+}
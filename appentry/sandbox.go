@@ -0,0 +1,52 @@
+package appentry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/imroc/req/v3"
+	"github.com/sirupsen/logrus"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/sandbox"
+)
+
+// Sandbox 启动易支付沙箱，使用配置中的商户 ID 与密钥校验请求
+func Sandbox(listen string) {
+	conf, err := appconf.Parse()
+	if err != nil {
+		logrus.WithError(err).Fatalln("无法加载配置")
+	}
+	Log(conf)
+
+	if conf.EpayPartnerID == "" || conf.EpayKey == "" {
+		logrus.Fatalln("易支付沙箱需要配置 CR_EPAY_EPAY_PARTNER_ID 与 CR_EPAY_EPAY_KEY")
+	}
+
+	server := &http.Server{
+		Addr:    listen,
+		Handler: sandbox.New(conf.EpayPartnerID, conf.EpayKey, req.C().SetTimeout(10*time.Second)).Handler(),
+	}
+
+	go func() {
+		logrus.Infof("易支付沙箱已启动，监听地址：%s", listen)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logrus.WithError(err).Fatalln("易支付沙箱未预期地停止")
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logrus.WithError(err).Errorln("无法停止易支付沙箱")
+	}
+	logrus.Infoln("易支付沙箱已停止")
+}
@@ -5,10 +5,11 @@ go 1.23
 toolchain go1.24.2
 
 require (
-	github.com/avast/retry-go v3.0.0+incompatible
+	github.com/alicebob/miniredis/v2 v2.39.0
 	github.com/cloudreve/Cloudreve/v3 v3.0.0-20230213112800-f1722208253f
 	github.com/samber/lo v1.38.1
 	github.com/shopspring/decimal v1.3.1
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 )
 
 require (
@@ -34,6 +35,7 @@ require (
 	github.com/refraction-networking/utls v1.6.7 // indirect
 	github.com/rogpeppe/go-internal v1.8.0 // indirect
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
 	go.uber.org/mock v0.5.0 // indirect
 	golang.org/x/arch v0.3.0 // indirect
 	golang.org/x/mod v0.22.0 // indirect
@@ -0,0 +1,250 @@
+package alipay
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/topjohncian/cloudreve-pro-epay/internal/epay"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/provider"
+)
+
+const testAppID = "2021000000000000"
+
+// stubGateway 模拟支付宝开放平台网关，使用测试生成的密钥校验请求并签名响应
+type stubGateway struct {
+	t *testing.T
+
+	// 校验应用请求签名
+	app *epay.RSASigner
+	// 以支付宝身份签名响应与通知
+	platform *epay.RSASigner
+
+	mu       sync.Mutex
+	trades   map[string]map[string]string
+	requests []map[string]string
+	// 为 true 时响应签名错误
+	badSign bool
+}
+
+func (g *stubGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		g.t.Fatal(err)
+	}
+	params := map[string]string{}
+	for key := range r.PostForm {
+		params[key] = r.PostForm.Get(key)
+	}
+
+	if err := g.app.Verify(SignContent(params), params["sign"]); err != nil {
+		g.t.Errorf("请求签名无效: %v", err)
+	}
+
+	var biz map[string]string
+	_ = json.Unmarshal([]byte(params["biz_content"]), &biz)
+
+	g.mu.Lock()
+	g.requests = append(g.requests, biz)
+	trade, ok := g.trades[biz["out_trade_no"]]
+	g.mu.Unlock()
+
+	response := map[string]string{"code": codeSuccess, "msg": "Success"}
+	switch {
+	case !ok:
+		response = map[string]string{"code": "40004", "msg": "Business Failed", "sub_code": "ACQ.TRADE_NOT_EXIST", "sub_msg": "交易不存在"}
+	case params["method"] == "alipay.trade.query":
+		for key, value := range trade {
+			response[key] = value
+		}
+		response["out_trade_no"] = biz["out_trade_no"]
+	case params["method"] == "alipay.trade.refund":
+		response["trade_no"] = trade["trade_no"]
+		response["out_trade_no"] = biz["out_trade_no"]
+		response["refund_fee"] = biz["refund_amount"]
+		response["fund_change"] = "Y"
+	}
+
+	content, _ := json.Marshal(response)
+	sign, _ := g.platform.Sign(string(content))
+	if g.badSign {
+		sign, _ = g.app.Sign(string(content))
+	}
+
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	_, _ = w.Write([]byte(`{"` + strings.ReplaceAll(params["method"], ".", "_") + `_response":` + string(content) + `,"sign":"` + sign + `"}`))
+}
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newTestProvider(t *testing.T) (*Provider, *stubGateway) {
+	appKey := generateKey(t)
+	platformKey := generateKey(t)
+
+	gateway := &stubGateway{
+		t:        t,
+		app:      &epay.RSASigner{PrivateKey: appKey, PlatformPublicKey: &appKey.PublicKey},
+		platform: &epay.RSASigner{PrivateKey: platformKey, PlatformPublicKey: &platformKey.PublicKey},
+		trades:   map[string]map[string]string{},
+	}
+	server := httptest.NewServer(gateway)
+	t.Cleanup(server.Close)
+
+	return &Provider{
+		client: NewClient(&Config{
+			AppID:   testAppID,
+			Gateway: server.URL + "/gateway.do",
+			Signer:  &epay.RSASigner{PrivateKey: appKey, PlatformPublicKey: &platformKey.PublicKey},
+		}),
+	}, gateway
+}
+
+// notification 生成以支付宝身份签名的异步通知请求
+func (g *stubGateway) notification(params map[string]string) *http.Request {
+	params["sign_type"] = "RSA2"
+	params["sign"], _ = g.platform.Sign(SignContent(params, "sign_type"))
+
+	form := url.Values{}
+	for key, value := range params {
+		form.Set(key, value)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/payment/alipay/notify/o1", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestCreatePayment(t *testing.T) {
+	p, gateway := newTestProvider(t)
+	notifyURL, _ := url.Parse("https://pay.example.com/payment/alipay/notify/o1")
+
+	for device, method := range map[provider.Device]string{
+		provider.DevicePC:     "alipay.trade.page.pay",
+		provider.DeviceMobile: "alipay.trade.wap.pay",
+	} {
+		checkout, err := p.CreatePayment(context.Background(), &provider.PaymentRequest{
+			OrderNo:   "o1",
+			Name:      "测试商品",
+			Amount:    1234,
+			Method:    provider.MethodAlipay,
+			Device:    device,
+			NotifyURL: notifyURL,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !checkout.IsForm() || !strings.HasPrefix(checkout.Endpoint, p.client.Config.Gateway) {
+			t.Fatalf("应返回提交至网关的表单: %+v", checkout)
+		}
+		if checkout.Params["method"] != method || checkout.Params["notify_url"] != notifyURL.String() {
+			t.Fatalf("%s 设备的支付参数错误: %v", device, checkout.Params)
+		}
+		if err := gateway.app.Verify(SignContent(checkout.Params), checkout.Params["sign"]); err != nil {
+			t.Fatalf("支付参数签名无效: %v", err)
+		}
+
+		var biz map[string]string
+		_ = json.Unmarshal([]byte(checkout.Params["biz_content"]), &biz)
+		if biz["total_amount"] != "12.34" || biz["out_trade_no"] != "o1" {
+			t.Fatalf("业务参数错误: %v", biz)
+		}
+	}
+}
+
+func TestVerifyNotification(t *testing.T) {
+	p, gateway := newTestProvider(t)
+
+	params := func() map[string]string {
+		return map[string]string{
+			"app_id":       testAppID,
+			"notify_type":  "trade_status_sync",
+			"trade_no":     "2024000000001",
+			"out_trade_no": "o1",
+			"total_amount": "12.34",
+			"trade_status": TradeSuccess,
+		}
+	}
+
+	res, err := p.VerifyNotification(gateway.notification(params()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Paid || res.OrderNo != "o1" || res.TradeNo != "2024000000001" || res.Money != "12.34" {
+		t.Fatalf("通知解析错误: %+v", res)
+	}
+
+	r := gateway.notification(params())
+	_ = r.ParseForm()
+	r.PostForm.Set("total_amount", "0.01")
+	var verifyErr *provider.VerifyError
+	if _, err := p.VerifyNotification(r, nil); !errors.As(err, &verifyErr) || verifyErr.Reason != RejectSignMismatch {
+		t.Fatalf("篡改金额的通知应验签失败，实际为 %v", err)
+	}
+
+	otherApp := params()
+	otherApp["app_id"] = "2021999999999999"
+	if _, err := p.VerifyNotification(gateway.notification(otherApp), nil); !errors.As(err, &verifyErr) || verifyErr.Reason != RejectAppMismatch {
+		t.Fatalf("其他应用的通知应被拒绝，实际为 %v", err)
+	}
+
+	waiting := params()
+	waiting["trade_status"] = TradeWaitBuyerPay
+	if res, err := p.VerifyNotification(gateway.notification(waiting), nil); err != nil || res.Paid {
+		t.Fatalf("等待付款的通知不应视为已支付: %+v, %v", res, err)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	p, gateway := newTestProvider(t)
+	gateway.trades["o1"] = map[string]string{
+		"trade_no":     "2024000000001",
+		"trade_status": TradeSuccess,
+		"total_amount": "12.34",
+	}
+
+	res, err := p.Query(context.Background(), &order.Order{OrderNo: "o1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Paid || res.TradeNo != "2024000000001" || res.Money != "12.34" {
+		t.Fatalf("查询结果错误: %+v", res)
+	}
+
+	if _, err := p.Query(context.Background(), &order.Order{OrderNo: "missing"}); !errors.Is(err, provider.ErrPaymentNotFound) {
+		t.Fatalf("不存在的交易应返回 ErrPaymentNotFound，实际为 %v", err)
+	}
+
+	gateway.badSign = true
+	if _, err := p.Query(context.Background(), &order.Order{OrderNo: "o1"}); !errors.Is(err, ErrSignMismatch) {
+		t.Fatalf("响应签名错误时应返回 ErrSignMismatch，实际为 %v", err)
+	}
+}
+
+func TestRefund(t *testing.T) {
+	p, gateway := newTestProvider(t)
+	gateway.trades["o1"] = map[string]string{"trade_no": "2024000000001"}
+
+	orderInfo := &order.Order{OrderNo: "o1", Refunds: []order.Refund{{Amount: 100}}}
+	if err := p.Refund(context.Background(), orderInfo, 250); err != nil {
+		t.Fatal(err)
+	}
+
+	last := gateway.requests[len(gateway.requests)-1]
+	if last["refund_amount"] != "2.50" || last["out_request_no"] != "o1-2" {
+		t.Fatalf("退款参数错误: %v", last)
+	}
+}
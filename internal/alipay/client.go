@@ -0,0 +1,195 @@
+package alipay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/imroc/req/v3"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/epay"
+)
+
+// DefaultGateway 支付宝开放平台网关地址
+const DefaultGateway = "https://openapi.alipay.com/gateway.do"
+
+// 接口调用成功时返回的网关返回码
+const codeSuccess = "10000"
+
+// 交易状态
+const (
+	TradeWaitBuyerPay = "WAIT_BUYER_PAY"
+	TradeClosed       = "TRADE_CLOSED"
+	TradeSuccess      = "TRADE_SUCCESS"
+	TradeFinished     = "TRADE_FINISHED"
+)
+
+// 支付宝开放平台接口使用北京时间
+var beijing = time.FixedZone("CST", 8*60*60)
+
+var ErrSignMismatch = errors.New("支付宝签名验证失败")
+
+// APIError 支付宝开放平台接口返回的业务错误
+type APIError struct {
+	Code    string
+	Msg     string
+	SubCode string
+	SubMsg  string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("支付宝接口返回错误 %s %s: %s %s", e.Code, e.SubCode, e.Msg, e.SubMsg)
+}
+
+// Config 支付宝开放平台应用配置
+type Config struct {
+	AppID   string
+	Gateway string
+	// 使用应用私钥签名，支付宝公钥验签，算法为 RSA2（SHA256WithRSA）
+	Signer     *epay.RSASigner
+	HTTPClient *req.Client
+}
+
+// Client 支付宝开放平台客户端
+type Client struct {
+	Config *Config
+}
+
+func NewClient(config *Config) *Client {
+	if config.Gateway == "" {
+		config.Gateway = DefaultGateway
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = req.C()
+	}
+	return &Client{Config: config}
+}
+
+// SignContent 生成待签名字符串：去除 sign 与 exclude 中的参数及空值，按参数名排序后以 & 连接
+func SignContent(params map[string]string, exclude ...string) string {
+	keys := make([]string, 0, len(params))
+	for key, value := range params {
+		if key == "sign" || value == "" {
+			continue
+		}
+		skip := false
+		for _, e := range exclude {
+			if key == e {
+				skip = true
+				break
+			}
+		}
+		if !skip {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		parts = append(parts, key+"="+params[key])
+	}
+	return strings.Join(parts, "&")
+}
+
+// params 生成已签名的公共请求参数与业务参数
+func (c *Client) params(method string, bizContent interface{}, extra map[string]string) (map[string]string, error) {
+	biz, err := json.Marshal(bizContent)
+	if err != nil {
+		return nil, err
+	}
+
+	params := map[string]string{
+		"app_id":      c.Config.AppID,
+		"method":      method,
+		"format":      "JSON",
+		"charset":     "utf-8",
+		"sign_type":   "RSA2",
+		"timestamp":   time.Now().In(beijing).Format(time.DateTime),
+		"version":     "1.0",
+		"biz_content": string(biz),
+	}
+	for key, value := range extra {
+		params[key] = value
+	}
+
+	sign, err := c.Config.Signer.Sign(SignContent(params))
+	if err != nil {
+		return nil, err
+	}
+	params["sign"] = sign
+	return params, nil
+}
+
+// VerifyNotification 校验异步通知的签名与 app_id，通知验签时不包含 sign_type
+func (c *Client) VerifyNotification(params map[string]string) error {
+	if params["sign"] == "" {
+		return ErrSignMismatch
+	}
+	if err := c.Config.Signer.Verify(SignContent(params, "sign_type"), params["sign"]); err != nil {
+		return ErrSignMismatch
+	}
+	if params["app_id"] != c.Config.AppID {
+		return errors.New("通知中的 app_id 与配置不符")
+	}
+	return nil
+}
+
+// commonResponse 接口响应中的公共字段
+type commonResponse struct {
+	Code    string `json:"code"`
+	Msg     string `json:"msg"`
+	SubCode string `json:"sub_code"`
+	SubMsg  string `json:"sub_msg"`
+}
+
+// execute 调用接口，校验响应签名后将 method 对应的响应内容解析到 result
+func (c *Client) execute(ctx context.Context, method string, bizContent interface{}, result interface{}) error {
+	params, err := c.params(method, bizContent, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Config.HTTPClient.R().
+		SetContext(ctx).
+		SetFormData(params).
+		Post(c.Config.Gateway)
+	if err != nil {
+		return err
+	}
+	if !resp.IsSuccessState() {
+		return fmt.Errorf("支付宝网关返回 HTTP %d", resp.StatusCode)
+	}
+
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(resp.Bytes(), &body); err != nil {
+		return fmt.Errorf("无法解析支付宝网关响应: %w", err)
+	}
+
+	// 响应内容为 alipay_trade_query_response 形式的字段，签名基于其原始 JSON 文本
+	content, ok := body[strings.ReplaceAll(method, ".", "_")+"_response"]
+	if !ok {
+		return errors.New("支付宝网关响应中缺少响应内容")
+	}
+
+	var sign string
+	if raw, ok := body["sign"]; ok {
+		_ = json.Unmarshal(raw, &sign)
+	}
+	if sign == "" || c.Config.Signer.Verify(string(content), sign) != nil {
+		return ErrSignMismatch
+	}
+
+	var common commonResponse
+	if err := json.Unmarshal(content, &common); err != nil {
+		return fmt.Errorf("无法解析支付宝网关响应: %w", err)
+	}
+	if common.Code != codeSuccess {
+		return &APIError{Code: common.Code, Msg: common.Msg, SubCode: common.SubCode, SubMsg: common.SubMsg}
+	}
+
+	return json.Unmarshal(content, result)
+}
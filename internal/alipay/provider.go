@@ -0,0 +1,167 @@
+package alipay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/imroc/req/v3"
+	"github.com/shopspring/decimal"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/epay"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/provider"
+	"go.uber.org/fx"
+)
+
+// ProviderName 支付宝渠道的名称
+const ProviderName = "alipay"
+
+// 异步通知验签失败的原因代码
+const (
+	RejectSignMismatch = "alipay_sign_mismatch"
+	RejectAppMismatch  = "alipay_app_mismatch"
+)
+
+func Module() fx.Option {
+	return fx.Module("alipay",
+		provider.Register(NewProvider),
+	)
+}
+
+// Provider 支付宝开放平台支付渠道
+type Provider struct {
+	client *Client
+}
+
+// NewProvider 未配置 AlipayAppID 时返回 nil，不启用支付宝渠道
+func NewProvider(conf *appconf.Config, client *req.Client) (provider.Provider, error) {
+	if conf.AlipayAppID == "" {
+		return nil, nil
+	}
+
+	priv, err := epay.LoadPrivateKey(conf.AlipayPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("无法加载支付宝应用私钥: %w", err)
+	}
+	pub, err := epay.LoadPublicKey(conf.AlipayPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("无法加载支付宝公钥: %w", err)
+	}
+
+	return &Provider{
+		client: NewClient(&Config{
+			AppID:   conf.AlipayAppID,
+			Gateway: conf.AlipayGateway,
+			Signer: &epay.RSASigner{
+				PrivateKey:        priv,
+				PlatformPublicKey: pub,
+			},
+			HTTPClient: client,
+		}),
+	}, nil
+}
+
+func (p *Provider) Name() string {
+	return ProviderName
+}
+
+// SupportsCurrency 支付宝只能以人民币收款
+func (p *Provider) SupportsCurrency(currency string) bool {
+	return currency == "CNY"
+}
+
+// yuan 将以分为单位的金额转换为元
+func yuan(amount int) string {
+	return decimal.New(int64(amount), -2).StringFixed(2)
+}
+
+// CreatePayment 电脑上使用电脑网站支付，移动设备上使用手机网站支付
+func (p *Provider) CreatePayment(ctx context.Context, req *provider.PaymentRequest) (*provider.Checkout, error) {
+	endpoint, params, err := p.client.PagePay(&PayArgs{
+		OutTradeNo:  req.OrderNo,
+		Subject:     req.Name,
+		TotalAmount: yuan(req.Amount),
+		Wap:         req.Device != provider.DevicePC,
+		NotifyURL:   req.NotifyURL,
+		ReturnURL:   req.ReturnURL,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &provider.Checkout{
+		Endpoint: endpoint,
+		Params:   params,
+	}, nil
+}
+
+// VerifyNotification 支付宝以 POST 表单发送异步通知
+func (p *Provider) VerifyNotification(r *http.Request, orderInfo *order.Order) (*provider.Result, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, &provider.VerifyError{Err: err}
+	}
+	params := map[string]string{}
+	for key := range r.PostForm {
+		params[key] = r.PostForm.Get(key)
+	}
+
+	if err := p.client.VerifyNotification(params); err != nil {
+		reason := RejectAppMismatch
+		if errors.Is(err, ErrSignMismatch) {
+			reason = RejectSignMismatch
+		}
+		return nil, &provider.VerifyError{Reason: reason, Err: err}
+	}
+
+	status := params["trade_status"]
+	return &provider.Result{
+		OrderNo: params["out_trade_no"],
+		TradeNo: params["trade_no"],
+		Method:  provider.MethodAlipay,
+		Money:   params["total_amount"],
+		Paid:    status == TradeSuccess || status == TradeFinished,
+	}, nil
+}
+
+func (p *Provider) Query(ctx context.Context, orderInfo *order.Order) (*provider.Result, error) {
+	res, err := p.client.TradeQuery(ctx, orderInfo.OrderNo)
+	if err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.SubCode == "ACQ.TRADE_NOT_EXIST" {
+			return nil, fmt.Errorf("%w: %v", provider.ErrPaymentNotFound, err)
+		}
+		return nil, err
+	}
+
+	return &provider.Result{
+		OrderNo: res.OutTradeNo,
+		TradeNo: res.TradeNo,
+		Method:  provider.MethodAlipay,
+		Money:   res.TotalAmount,
+		Paid:    res.Paid(),
+	}, nil
+}
+
+// Refund 退款请求号按订单的退款次数生成，失败后重试时保持不变，避免重复退款
+func (p *Provider) Refund(ctx context.Context, orderInfo *order.Order, amount int) error {
+	_, err := p.client.TradeRefund(ctx, &TradeRefundArgs{
+		OutTradeNo:   orderInfo.OrderNo,
+		RefundAmount: yuan(amount),
+		OutRequestNo: orderInfo.OrderNo + "-" + strconv.Itoa(len(orderInfo.Refunds)+1),
+	})
+	return err
+}
+
+// Ack 支付宝要求处理成功时返回纯文本 success，否则会重发通知
+func (p *Provider) Ack(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("fail"))
+		return
+	}
+	_, _ = w.Write([]byte("success"))
+}
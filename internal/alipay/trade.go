@@ -0,0 +1,109 @@
+package alipay
+
+import (
+	"context"
+	"net/url"
+)
+
+// PayArgs 发起支付的参数
+type PayArgs struct {
+	OutTradeNo string
+	Subject    string
+	// 金额，单位为元
+	TotalAmount string
+	// 为 true 时使用手机网站支付，否则使用电脑网站支付
+	Wap       bool
+	NotifyURL *url.URL
+	ReturnURL *url.URL
+}
+
+// PagePay 生成 alipay.trade.page.pay 或 alipay.trade.wap.pay 的表单参数，
+// 由浏览器以 POST 方式提交至返回的网关地址
+func (c *Client) PagePay(args *PayArgs) (string, map[string]string, error) {
+	method := "alipay.trade.page.pay"
+	productCode := "FAST_INSTANT_TRADE_PAY"
+	if args.Wap {
+		method = "alipay.trade.wap.pay"
+		productCode = "QUICK_WAP_WAY"
+	}
+
+	extra := map[string]string{}
+	if args.NotifyURL != nil {
+		extra["notify_url"] = args.NotifyURL.String()
+	}
+	if args.ReturnURL != nil {
+		extra["return_url"] = args.ReturnURL.String()
+	}
+
+	params, err := c.params(method, map[string]string{
+		"out_trade_no": args.OutTradeNo,
+		"total_amount": args.TotalAmount,
+		"subject":      args.Subject,
+		"product_code": productCode,
+	}, extra)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return c.Config.Gateway + "?charset=utf-8", params, nil
+}
+
+// TradeQueryRes alipay.trade.query 的响应
+type TradeQueryRes struct {
+	TradeNo     string `json:"trade_no"`
+	OutTradeNo  string `json:"out_trade_no"`
+	TradeStatus string `json:"trade_status"`
+	TotalAmount string `json:"total_amount"`
+}
+
+// Paid 返回交易是否已支付成功
+func (r *TradeQueryRes) Paid() bool {
+	return r.TradeStatus == TradeSuccess || r.TradeStatus == TradeFinished
+}
+
+// TradeQuery 按商家订单号查询交易
+func (c *Client) TradeQuery(ctx context.Context, outTradeNo string) (*TradeQueryRes, error) {
+	var res TradeQueryRes
+	if err := c.execute(ctx, "alipay.trade.query", map[string]string{
+		"out_trade_no": outTradeNo,
+	}, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// TradeRefundArgs 退款参数
+type TradeRefundArgs struct {
+	OutTradeNo string
+	// 退款金额，单位为元
+	RefundAmount string
+	// 退款请求号，同一笔交易多次部分退款时需各不相同，重试时需保持不变
+	OutRequestNo string
+	Reason       string
+}
+
+// TradeRefundRes alipay.trade.refund 的响应
+type TradeRefundRes struct {
+	TradeNo    string `json:"trade_no"`
+	OutTradeNo string `json:"out_trade_no"`
+	RefundFee  string `json:"refund_fee"`
+	FundChange string `json:"fund_change"`
+}
+
+// TradeRefund 对交易退款
+func (c *Client) TradeRefund(ctx context.Context, args *TradeRefundArgs) (*TradeRefundRes, error) {
+	biz := map[string]string{
+		"out_trade_no":   args.OutTradeNo,
+		"refund_amount":  args.RefundAmount,
+		"out_request_no": args.OutRequestNo,
+	}
+	if args.Reason != "" {
+		biz["refund_reason"] = args.Reason
+	}
+
+	var res TradeRefundRes
+	if err := c.execute(ctx, "alipay.trade.refund", biz, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
@@ -1,20 +1,102 @@
 package appconf
 
+import "time"
+
 type Config struct {
 	Listen       string `default:":4560"`
 	Debug        bool   `default:"false"`
 	Base         string `required:"true"`
 	CloudreveKey string `required:"true" split_words:"true"`
 
-	EpayPartnerID    string `required:"true" split_words:"true"`
-	EpayKey          string `required:"true" split_words:"true"`
-	EpayEndpoint     string `required:"true" split_words:"true"`
-	EpayPurchaseType string `default:"alipay" split_words:"true"`
+	CloudreveAllowNonExpiringSign bool   `default:"false" split_words:"true"`
+	CloudreveURL                  string `default:"" split_words:"true"`
+
+	NotifyAllowedHosts    []string `default:"" split_words:"true"`
+	NotifyAllowedSchemes  []string `default:"http,https" split_words:"true"`
+	NotifyAllowPrivate    bool     `default:"false" split_words:"true"`
+	NotifyAllowedNetworks []string `default:"" split_words:"true"`
+
+	PaymentProvider string `default:"epay" split_words:"true"`
+
+	EpayPartnerID       string   `default:"" split_words:"true"`
+	EpayKey             string   `default:"" split_words:"true"`
+	EpayEndpoint        string   `default:"" split_words:"true"`
+	EpayPurchaseType    string   `default:"alipay" split_words:"true"`
+	EpayPurchaseMethods []string `default:"" split_words:"true"`
+	EpayMode            string   `default:"submit" split_words:"true"`
+	EpayMapiEndpoint    string   `default:"" split_words:"true"`
+
+	EpaySignType          string `default:"MD5" split_words:"true"`
+	EpayPrivateKey        string `default:"" split_words:"true"`
+	EpayPlatformPublicKey string `default:"" split_words:"true"`
+
+	EpayMerchantsFile       string        `default:"" split_words:"true"`
+	EpayFailoverCooldown    time.Duration `default:"5m" split_words:"true"`
+	EpayHealthCheckInterval time.Duration `default:"0" split_words:"true"`
+
+	AlipayAppID      string `default:"" split_words:"true"`
+	AlipayPrivateKey string `default:"" split_words:"true"`
+	AlipayPublicKey  string `default:"" split_words:"true"`
+	AlipayGateway    string `default:"https://openapi.alipay.com/gateway.do" split_words:"true"`
+
+	WechatpayMchID               string `default:"" split_words:"true"`
+	WechatpayAppID               string `default:"" split_words:"true"`
+	WechatpayAPIV3Key            string `default:"" split_words:"true"`
+	WechatpaySerialNo            string `default:"" split_words:"true"`
+	WechatpayPrivateKey          string `default:"" split_words:"true"`
+	WechatpayPlatformCertificate string `default:"" split_words:"true"`
+	WechatpayEndpoint            string `default:"https://api.mch.weixin.qq.com" split_words:"true"`
+
+	UsdtNetwork       string        `default:"trc20" split_words:"true"`
+	UsdtAddress       string        `default:"" split_words:"true"`
+	UsdtContract      string        `default:"" split_words:"true"`
+	UsdtConfirmations int           `default:"0" split_words:"true"`
+	UsdtAmountStep    string        `default:"0.01" split_words:"true"`
+	UsdtSlots         int           `default:"100" split_words:"true"`
+	UsdtTimeout       time.Duration `default:"30m" split_words:"true"`
+	UsdtPollInterval  time.Duration `default:"15s" split_words:"true"`
+	UsdtAPIEndpoint   string        `default:"" split_words:"true"`
+	UsdtAPIKey        string        `default:"" split_words:"true"`
+
+	Currency          string            `default:"CNY"`
+	CurrencyRates     map[string]string `default:"" split_words:"true"`
+	CurrencyRatesFile string            `default:"" split_words:"true"`
+	CurrencyRounding  string            `default:"half_up" split_words:"true"`
 
 	RedisEnabled  bool   `default:"false" split_words:"true"`
 	RedisServer   string `default:"localhost:6379" split_words:"true"`
 	RedisPassword string `default:"" split_words:"true"`
 	RedisDB       int    `default:"0" split_words:"true"`
+	RedisURL      string `default:"" split_words:"true"`
+	RedisUsername string `default:"" split_words:"true"`
+
+	RedisTLS                   bool   `default:"false" split_words:"true"`
+	RedisCACert                string `default:"" split_words:"true"`
+	RedisClientCert            string `default:"" split_words:"true"`
+	RedisClientKey             string `default:"" split_words:"true"`
+	RedisTLSServerName         string `default:"" split_words:"true"`
+	RedisTLSInsecureSkipVerify bool   `default:"false" split_words:"true"`
+
+	RedisMaxIdle        int           `default:"10" split_words:"true"`
+	RedisMaxActive      int           `default:"0" split_words:"true"`
+	RedisIdleTimeout    time.Duration `default:"240s" split_words:"true"`
+	RedisConnectTimeout time.Duration `default:"5s" split_words:"true"`
+	RedisReadTimeout    time.Duration `default:"5s" split_words:"true"`
+	RedisWriteTimeout   time.Duration `default:"5s" split_words:"true"`
+
+	RedisGobFallback bool `default:"true" split_words:"true"`
+
+	NotifyPollInterval    time.Duration `default:"1s" split_words:"true"`
+	NotifyRetryBase       time.Duration `default:"5s" split_words:"true"`
+	NotifyRetryMax        time.Duration `default:"30m" split_words:"true"`
+	NotifyDeadLetterAfter time.Duration `default:"72h" split_words:"true"`
+
+	ReconcileInterval time.Duration `default:"5m" split_words:"true"`
+	ReconcileMinAge   time.Duration `default:"1m" split_words:"true"`
+	// 查询订单状态时，同一订单两次主动查询支付网关的最短间隔，设为 0 时不主动查询
+	ReconcileQueryInterval time.Duration `default:"10s" split_words:"true"`
+
+	RefundWebhook string `default:"" split_words:"true"`
 
 	CustomName string `default:"" split_words:"true"`
 }
@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+const (
+	// lockRetryInterval 等待锁时的重试间隔
+	lockRetryInterval = 10 * time.Millisecond
+	// modifyAttempts Modify 在值被并发修改时的最大尝试次数
+	modifyAttempts = 100
+)
+
+var (
+	ErrLocked   = errors.New("锁已被占用")
+	ErrConflict = errors.New("值被并发修改，请稍后重试")
+)
+
+// newToken 生成锁的持有者令牌
+func newToken() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// WithLock 获取锁后执行 fn。锁被占用时每隔一段时间重试，超过 wait 仍未获取时返回 ErrLocked；
+// ttl 为锁的最长持有时间，应大于 fn 的最长执行时间
+func WithLock(driver Driver, key string, ttl, wait time.Duration, fn func() error) error {
+	deadline := time.Now().Add(wait)
+	for {
+		token, err := driver.Lock(key, ttl)
+		if err == nil {
+			defer driver.Unlock(key, token)
+			return fn()
+		}
+		if !errors.Is(err, ErrLocked) || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+// Modify 以比较并交换的方式修改值：读取当前值交由 fn 计算新值，期间值被其他进程修改时重新读取。
+// 值不存在时 fn 收到 nil
+func Modify(driver Driver, key string, ttl int, fn func(value interface{}) (interface{}, error)) error {
+	for i := 0; i < modifyAttempts; i++ {
+		old, _ := driver.Get(key)
+		value, err := fn(old)
+		if err != nil {
+			return err
+		}
+
+		ok, err := driver.CompareAndSwap(key, old, value, ttl)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+	return ErrConflict
+}
@@ -0,0 +1,219 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// forEachStore 分别使用内存驱动与 Redis 驱动运行测试
+func forEachStore(t *testing.T, fn func(t *testing.T, store Driver)) {
+	t.Run("memo", func(t *testing.T) {
+		fn(t, NewMemoStore())
+	})
+	t.Run("redis", func(t *testing.T) {
+		server := miniredis.RunT(t)
+		fn(t, NewRedisStore(testRedisOptions(server.Addr())))
+	})
+}
+
+func testRedisOptions(addrs ...string) *RedisOptions {
+	return &RedisOptions{
+		Mode:           RedisStandalone,
+		Addrs:          addrs,
+		MaxIdle:        10,
+		ConnectTimeout: time.Second,
+		ReadTimeout:    time.Second,
+		WriteTimeout:   time.Second,
+	}
+}
+
+func TestSetNX(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Driver) {
+		var wins atomic.Int32
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				ok, err := store.SetNX("key", i, 0)
+				if err != nil {
+					t.Error(err)
+				}
+				if ok {
+					wins.Add(1)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		if wins.Load() != 1 {
+			t.Fatalf("%d 次 SetNX 成功，应为 1 次", wins.Load())
+		}
+		if _, ok := store.Get("key"); !ok {
+			t.Fatal("SetNX 未保存值")
+		}
+	})
+}
+
+func TestFetch(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Driver) {
+		if value, ok, err := store.Fetch("missing"); ok || err != nil {
+			t.Fatalf("读取不存在的键返回 %v, %v, %v", value, ok, err)
+		}
+
+		if err := store.Set("key", "value", 0); err != nil {
+			t.Fatal(err)
+		}
+		if value, ok, err := store.Fetch("key"); !ok || err != nil || value != "value" {
+			t.Fatalf("读取已保存的键返回 %v, %v, %v", value, ok, err)
+		}
+	})
+}
+
+func TestRedisFetchError(t *testing.T) {
+	server := miniredis.RunT(t)
+	store := NewRedisStore(testRedisOptions(server.Addr()))
+	server.Set("invalid", "not encoded")
+
+	// 无法解码的值与连接失败都应返回错误，而不是视为键不存在
+	if _, ok, err := store.Fetch("invalid"); ok || err == nil {
+		t.Fatalf("读取无法解码的值返回 %v, %v", ok, err)
+	}
+
+	server.Close()
+	if _, ok, err := store.Fetch("missing"); ok || err == nil {
+		t.Fatalf("Redis 不可用时返回 %v, %v", ok, err)
+	}
+	if _, ok := store.Get("missing"); ok {
+		t.Fatal("Redis 不可用时 Get 应返回 false")
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Driver) {
+		if ok, err := store.CompareAndSwap("key", nil, []string{"a"}, 0); err != nil || !ok {
+			t.Fatalf("键不存在时 CompareAndSwap 返回 %v, %v", ok, err)
+		}
+		if ok, _ := store.CompareAndSwap("key", nil, []string{"b"}, 0); ok {
+			t.Fatal("键已存在时 old 为 nil 的 CompareAndSwap 成功")
+		}
+		if ok, _ := store.CompareAndSwap("key", []string{"x"}, []string{"b"}, 0); ok {
+			t.Fatal("old 不一致时 CompareAndSwap 成功")
+		}
+
+		current, _ := store.Get("key")
+		if ok, err := store.CompareAndSwap("key", current, []string{"a", "b"}, 0); err != nil || !ok {
+			t.Fatalf("old 一致时 CompareAndSwap 返回 %v, %v", ok, err)
+		}
+
+		// 并发追加时不丢失修改
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := Modify(store, "list", 0, func(value interface{}) (interface{}, error) {
+					list, _ := value.([]string)
+					return append(append([]string(nil), list...), "x"), nil
+				})
+				if err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		value, _ := store.Get("list")
+		if list, _ := value.([]string); len(list) != 20 {
+			t.Fatalf("并发追加后列表长度为 %d，应为 20", len(list))
+		}
+	})
+}
+
+func TestIncr(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Driver) {
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := store.Incr("counter", 2, 60); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		if value, err := store.Incr("counter", 0, 60); err != nil || value != 40 {
+			t.Fatalf("计数器的值为 %d, %v，应为 40", value, err)
+		}
+	})
+}
+
+func TestLock(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store Driver) {
+		token, err := store.Lock("lock", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Lock("lock", time.Minute); !errors.Is(err, ErrLocked) {
+			t.Fatalf("锁被占用时返回 %v", err)
+		}
+
+		// 令牌不一致时不会释放他人持有的锁
+		if err := store.Unlock("lock", "other"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Lock("lock", time.Minute); !errors.Is(err, ErrLocked) {
+			t.Fatalf("使用错误令牌释放后锁被释放: %v", err)
+		}
+
+		if err := store.Unlock("lock", token); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Lock("lock", time.Minute); err != nil {
+			t.Fatalf("释放后无法再次获取锁: %v", err)
+		}
+
+		// WithLock 串行执行临界区
+		var running, overlaps atomic.Int32
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := WithLock(store, "critical", time.Minute, 5*time.Second, func() error {
+					if running.Add(1) > 1 {
+						overlaps.Add(1)
+					}
+					time.Sleep(time.Millisecond)
+					running.Add(-1)
+					return nil
+				})
+				if err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+		if overlaps.Load() != 0 {
+			t.Fatalf("临界区并发执行了 %d 次", overlaps.Load())
+		}
+	})
+}
+
+func TestMemoLockExpires(t *testing.T) {
+	store := NewMemoStore()
+	if _, err := store.Lock("lock", 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := store.Lock("lock", time.Minute); err != nil {
+		t.Fatalf("锁过期后无法获取: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// ErrUnknownType 值的类型没有通过 RegisterType 注册
+var ErrUnknownType = errors.New("cache: unregistered type")
+
+// Migration 将某一版本的数据升级为下一版本
+type Migration func(data json.RawMessage) (json.RawMessage, error)
+
+// codecType 已注册的类型
+type codecType struct {
+	name       string
+	typ        reflect.Type
+	migrations []Migration
+}
+
+func (t *codecType) version() int {
+	return len(t.migrations) + 1
+}
+
+// envelope 写入 Redis 的值，记录类型名称与数据版本
+type envelope struct {
+	Type    string          `json:"type"`
+	Version int             `json:"version"`
+	Data    json.RawMessage `json:"data"`
+}
+
+var (
+	typesMu     sync.RWMutex
+	typesByName = make(map[string]*codecType)
+	typesByType = make(map[reflect.Type]*codecType)
+)
+
+func init() {
+	RegisterType("string", "")
+	RegisterType("bool", false)
+	RegisterType("int", 0)
+	RegisterType("int64", int64(0))
+	RegisterType("float64", float64(0))
+	RegisterType("[]string", []string{})
+	RegisterType("map[string]string", map[string]string{})
+}
+
+// RegisterType 注册可以写入 Redis 的类型。name 会随数据一同保存，注册后不应再修改；
+// value 为该类型的任意值。数据结构发生不兼容的变化时，在 migrations 末尾追加一个
+// 将上一版本数据转换为新版本的函数，当前版本号为 len(migrations)+1，
+// 读取旧版本数据时会依次执行尚未执行的转换
+func RegisterType(name string, value interface{}, migrations ...Migration) {
+	typ := reflect.TypeOf(value)
+	if name == "" || typ == nil {
+		panic("cache: RegisterType requires a name and a non-nil value")
+	}
+
+	typesMu.Lock()
+	defer typesMu.Unlock()
+	if _, ok := typesByName[name]; ok {
+		panic(fmt.Sprintf("cache: type name %q registered twice", name))
+	}
+	if _, ok := typesByType[typ]; ok {
+		panic(fmt.Sprintf("cache: type %s registered twice", typ))
+	}
+
+	t := &codecType{name: name, typ: typ, migrations: migrations}
+	typesByName[name] = t
+	typesByType[typ] = t
+}
+
+// encode 将值编码为带类型与版本的 JSON
+func encode(value interface{}) ([]byte, error) {
+	typesMu.RLock()
+	t, ok := typesByType[reflect.TypeOf(value)]
+	typesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrUnknownType, value)
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope{Type: t.name, Version: t.version(), Data: data})
+}
+
+// decode 解码 encode 编码的值，旧版本的数据会先升级为当前版本
+func decode(raw []byte) (interface{}, error) {
+	var env envelope
+	if err := json.Unmarshal(raw, &env); err != nil {
+		return nil, err
+	}
+	if env.Type == "" {
+		return nil, errors.New("cache: value is not a typed envelope")
+	}
+
+	typesMu.RLock()
+	t, ok := typesByName[env.Type]
+	typesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownType, env.Type)
+	}
+	if env.Version < 1 || env.Version > t.version() {
+		return nil, fmt.Errorf("cache: unsupported version %d of %s", env.Version, env.Type)
+	}
+
+	data := env.Data
+	for v := env.Version; v < t.version(); v++ {
+		var err error
+		if data, err = t.migrations[v-1](data); err != nil {
+			return nil, fmt.Errorf("cache: failed to migrate %s from version %d: %w", env.Type, v, err)
+		}
+	}
+
+	ptr := reflect.New(t.typ)
+	if err := json.Unmarshal(data, ptr.Interface()); err != nil {
+		return nil, err
+	}
+	return ptr.Elem().Interface(), nil
+}
+
+// item 旧版本以 gob 编码写入的值
+type item struct {
+	Value interface{}
+}
+
+// decodeGob 解码旧版本以 gob 编码写入的值，值的类型需已通过 gob.Register 注册
+func decodeGob(raw []byte) (interface{}, error) {
+	var res item
+	if err := gob.NewDecoder(bytes.NewReader(raw)).Decode(&res); err != nil {
+		return nil, err
+	}
+	return res.Value, nil
+}
@@ -0,0 +1,223 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+type codecRecord struct {
+	ID    string
+	Tags  []string
+	Count int
+	At    time.Time
+}
+
+// codecRenamed 第 1 版字段名为 Title，第 2 版改为 Name
+type codecRenamed struct {
+	Name string
+}
+
+func init() {
+	gob.Register(&codecRecord{})
+	RegisterType("cache.codecRecord", &codecRecord{})
+	RegisterType("cache.codecRenamed", &codecRenamed{}, func(data json.RawMessage) (json.RawMessage, error) {
+		var v1 struct{ Title string }
+		if err := json.Unmarshal(data, &v1); err != nil {
+			return nil, err
+		}
+		return json.Marshal(codecRenamed{Name: v1.Title})
+	})
+}
+
+// encodeGob 以旧版本的格式编码
+func encodeGob(t *testing.T, value interface{}) []byte {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(item{Value: value}); err != nil {
+		t.Fatal(err)
+	}
+	return buffer.Bytes()
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("CST", 8*3600))
+	for _, value := range []interface{}{
+		"value",
+		true,
+		42,
+		int64(-7),
+		[]string{"a", "b"},
+		&codecRecord{ID: "1", Tags: []string{"x"}, Count: 3, At: at},
+	} {
+		raw, err := encode(value)
+		if err != nil {
+			t.Fatalf("%#v: %v", value, err)
+		}
+		decoded, err := decode(raw)
+		if err != nil {
+			t.Fatalf("%s: %v", raw, err)
+		}
+		if record, ok := decoded.(*codecRecord); ok {
+			if !record.At.Equal(at) {
+				t.Fatalf("时间 %v 解码为 %v", at, record.At)
+			}
+			record.At = at
+		}
+		if !reflect.DeepEqual(decoded, value) {
+			t.Fatalf("%#v 解码为 %#v", value, decoded)
+		}
+	}
+
+	raw, _ := encode(&codecRecord{ID: "1"})
+	if !strings.HasPrefix(string(raw), `{"type":"cache.codecRecord","version":1,"data":{"ID":"1"`) {
+		t.Fatalf("编码结果 %s", raw)
+	}
+
+	if _, err := encode(struct{}{}); err == nil {
+		t.Fatal("未注册的类型应无法编码")
+	}
+	if _, err := decode([]byte(`{"type":"cache.missing","version":1,"data":{}}`)); err == nil {
+		t.Fatal("未注册的类型应无法解码")
+	}
+	if _, err := decode([]byte(`{"type":"cache.codecRecord","version":2,"data":{}}`)); err == nil {
+		t.Fatal("高于当前版本的数据应无法解码")
+	}
+}
+
+func TestCodecMigration(t *testing.T) {
+	decoded, err := decode([]byte(`{"type":"cache.codecRenamed","version":1,"data":{"Title":"old"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, &codecRenamed{Name: "old"}) {
+		t.Fatalf("第 1 版数据解码为 %#v", decoded)
+	}
+
+	raw, _ := encode(&codecRenamed{Name: "new"})
+	if !strings.Contains(string(raw), `"version":2`) {
+		t.Fatalf("应以第 2 版编码：%s", raw)
+	}
+}
+
+func TestRedisGobFallback(t *testing.T) {
+	server := miniredis.RunT(t)
+	opts := testRedisOptions(server.Addr())
+	opts.GobFallback = true
+	store := NewRedisStore(opts)
+
+	record := &codecRecord{ID: "legacy", Tags: []string{"a"}}
+	server.Set("record", string(encodeGob(t, record)))
+	server.SetTTL("record", time.Hour)
+
+	value, ok := store.Get("record")
+	if !ok || !reflect.DeepEqual(value, record) {
+		t.Fatalf("读取旧版本的值得到 %#v, %v", value, ok)
+	}
+
+	// 读取后改写为 JSON，并保留过期时间
+	raw, _ := server.Get("record")
+	if decoded, err := decode([]byte(raw)); err != nil || !reflect.DeepEqual(decoded, record) {
+		t.Fatalf("旧值未被改写：%q", raw)
+	}
+	if ttl := server.TTL("record"); ttl <= 0 || ttl > time.Hour {
+		t.Fatalf("改写后过期时间为 %v", ttl)
+	}
+
+	// 关闭兼容后不再读取 gob 编码的值
+	server.Set("other", string(encodeGob(t, "value")))
+	opts.GobFallback = false
+	if _, ok := NewRedisStore(opts).Get("other"); ok {
+		t.Fatal("关闭兼容后不应读取 gob 编码的值")
+	}
+}
+
+func TestRedisCompareAndSwapLegacy(t *testing.T) {
+	server := miniredis.RunT(t)
+	opts := testRedisOptions(server.Addr())
+	opts.GobFallback = true
+	store := NewRedisStore(opts)
+
+	server.Set("list", string(encodeGob(t, []string{"a"})))
+
+	if ok, err := store.CompareAndSwap("list", []string{"b"}, []string{"c"}, 0); err != nil || ok {
+		t.Fatalf("当前值不同时不应替换：%v, %v", ok, err)
+	}
+	if ok, err := store.CompareAndSwap("list", []string{"a"}, []string{"a", "b"}, 0); err != nil || !ok {
+		t.Fatalf("当前值为相同内容的旧编码时应替换：%v, %v", ok, err)
+	}
+
+	server.Set("list", string(encodeGob(t, []string{"a"})))
+	if err := Modify(store, "list", 0, func(value interface{}) (interface{}, error) {
+		list, _ := value.([]string)
+		return append(list, "b"), nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := store.Get("list"); !reflect.DeepEqual(value, []string{"a", "b"}) {
+		t.Fatalf("修改后的值为 %#v", value)
+	}
+}
+
+func TestRedisMigrateLegacy(t *testing.T) {
+	server := miniredis.RunT(t)
+	opts := testRedisOptions(server.Addr())
+	opts.GobFallback = true
+	store := NewRedisStore(opts)
+
+	for i, id := range []string{"1", "2", "3"} {
+		server.Set("record_"+id, string(encodeGob(t, &codecRecord{ID: id, Count: i})))
+	}
+	if err := store.Set("record_current", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Incr("counter", 1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Lock("record_lock", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	server.HSet("record_hash", "field", "value")
+	current, _ := server.Get("record_current")
+	// 其他程序以相同格式写入的值
+	foreign := map[string]string{
+		"setting_siteName": string(encodeGob(t, "Cloudreve")),
+		"records":          string(encodeGob(t, &codecRecord{ID: "foreign"})),
+	}
+	for key, raw := range foreign {
+		server.Set(key, raw)
+	}
+
+	migrated, err := store.MigrateLegacy(context.Background(), []string{"record_", "counter"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated != 3 {
+		t.Fatalf("改写了 %d 个值，应为 3", migrated)
+	}
+
+	for i, id := range []string{"1", "2", "3"} {
+		raw, _ := server.Get("record_" + id)
+		decoded, err := decode([]byte(raw))
+		if err != nil || !reflect.DeepEqual(decoded, &codecRecord{ID: id, Count: i}) {
+			t.Fatalf("record_%s 未被改写：%q", id, raw)
+		}
+	}
+	if raw, _ := server.Get("record_current"); raw != current {
+		t.Fatalf("已是 JSON 编码的值被修改为 %q", raw)
+	}
+	if raw, _ := server.Get("counter"); raw != "1" {
+		t.Fatalf("计数器被修改为 %q", raw)
+	}
+	for key, want := range foreign {
+		if raw, _ := server.Get(key); raw != want {
+			t.Fatalf("前缀不匹配的键 %s 被修改为 %q", key, raw)
+		}
+	}
+}
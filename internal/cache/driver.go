@@ -1,16 +1,22 @@
 package cache
 
 import (
+	"time"
+
 	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
 	"go.uber.org/fx"
 )
 
 func Cache() fx.Option {
-	return fx.Module("cache", fx.Provide(func(conf *appconf.Config) Driver {
+	return fx.Module("cache", fx.Provide(func(conf *appconf.Config) (Driver, error) {
 		if conf.RedisEnabled {
-			return NewRedisStore(10, "tcp", conf.RedisServer, conf.RedisPassword, conf.RedisDB)
+			opts, err := NewRedisOptions(conf)
+			if err != nil {
+				return nil, err
+			}
+			return NewRedisStore(opts), nil
 		} else {
-			return NewMemoStore()
+			return NewMemoStore(), nil
 		}
 	}))
 }
@@ -23,6 +29,9 @@ type Driver interface {
 	// 取值，并返回是否成功
 	Get(key string) (interface{}, bool)
 
+	// 取值，键不存在时返回 false；无法读取（如连接失败、无法解码）时返回错误，与键不存在区分
+	Fetch(key string) (interface{}, bool, error)
+
 	// 批量取值，返回成功取值的map即不存在的值
 	Gets(keys []string, prefix string) (map[string]interface{}, []string)
 
@@ -31,6 +40,22 @@ type Driver interface {
 
 	// 删除值
 	Delete(keys []string, prefix string) error
+
+	// 仅在键不存在时设置值，返回是否设置成功
+	SetNX(key string, value interface{}, ttl int) (bool, error)
+
+	// 当前值与 old 相同时设置为 value，返回是否设置成功。old 为 nil 时要求键不存在
+	CompareAndSwap(key string, old, value interface{}, ttl int) (bool, error)
+
+	// 将计数器加上 delta 并返回新值，计数器不存在时从 0 开始并设置 ttl 过期时间。
+	// 计数器只能通过 Incr 读取和修改
+	Incr(key string, delta int64, ttl int) (int64, error)
+
+	// 获取短期锁，ttl 后自动释放，返回持有者令牌；锁已被占用时返回 ErrLocked
+	Lock(key string, ttl time.Duration) (string, error)
+
+	// 释放锁，令牌与当前持有者不一致时不做任何操作
+	Unlock(key, token string) error
 }
 
 // // Set 设置缓存值
@@ -1,6 +1,8 @@
 package cache
 
 import (
+	"errors"
+	"reflect"
 	"sync"
 	"time"
 
@@ -10,25 +12,37 @@ import (
 // MemoStore 内存存储驱动
 type MemoStore struct {
 	Store *sync.Map
+
+	// 写操作持有 mu，保证 SetNX、CompareAndSwap 等操作的原子性
+	mu sync.Mutex
 }
 
 // item 存储的对象
 type itemWithTTL struct {
+	// 过期时间，单位为纳秒，为 0 时不过期
 	expires int64
 	value   interface{}
 }
 
 func newItem(value interface{}, expires int) itemWithTTL {
-	expires64 := int64(expires)
-	if expires > 0 {
-		expires64 = time.Now().Unix() + expires64
+	return newItemWithDuration(value, time.Duration(expires)*time.Second)
+}
+
+func newItemWithDuration(value interface{}, ttl time.Duration) itemWithTTL {
+	var expires int64
+	if ttl > 0 {
+		expires = time.Now().Add(ttl).UnixNano()
 	}
 	return itemWithTTL{
 		value:   value,
-		expires: expires64,
+		expires: expires,
 	}
 }
 
+func (item itemWithTTL) expired() bool {
+	return item.expires > 0 && item.expires < time.Now().UnixNano()
+}
+
 // getValue 从itemWithTTL中取值
 func getValue(item interface{}, ok bool) (interface{}, bool) {
 	if !ok {
@@ -40,7 +54,7 @@ func getValue(item interface{}, ok bool) (interface{}, bool) {
 		return item, true
 	}
 
-	if itemObj.expires > 0 && itemObj.expires < time.Now().Unix() {
+	if itemObj.expired() {
 		return nil, false
 	}
 
@@ -50,10 +64,13 @@ func getValue(item interface{}, ok bool) (interface{}, bool) {
 
 // GarbageCollect 回收已过期的缓存
 func (store *MemoStore) GarbageCollect() {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
 	store.Store.Range(func(key, value interface{}) bool {
 		if item, ok := value.(itemWithTTL); ok {
-			if item.expires > 0 && item.expires < time.Now().Unix() {
-				logrus.Debug("Cache %q is garbage collected.", key.(string))
+			if item.expired() {
+				logrus.Debugf("Cache %q is garbage collected.", key.(string))
 				store.Store.Delete(key)
 			}
 		}
@@ -70,6 +87,9 @@ func NewMemoStore() *MemoStore {
 
 // Set 存储值
 func (store *MemoStore) Set(key string, value interface{}, ttl int) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
 	store.Store.Store(key, newItem(value, ttl))
 	return nil
 }
@@ -79,6 +99,12 @@ func (store *MemoStore) Get(key string) (interface{}, bool) {
 	return getValue(store.Store.Load(key))
 }
 
+// Fetch 取值，内存驱动不会读取失败
+func (store *MemoStore) Fetch(key string) (interface{}, bool, error) {
+	value, ok := store.Get(key)
+	return value, ok, nil
+}
+
 // Gets 批量取值
 func (store *MemoStore) Gets(keys []string, prefix string) (map[string]interface{}, []string) {
 	var res = make(map[string]interface{})
@@ -97,6 +123,9 @@ func (store *MemoStore) Gets(keys []string, prefix string) (map[string]interface
 
 // Sets 批量设置值
 func (store *MemoStore) Sets(values map[string]interface{}, prefix string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
 	for key, value := range values {
 		store.Store.Store(prefix+key, value)
 	}
@@ -105,8 +134,85 @@ func (store *MemoStore) Sets(values map[string]interface{}, prefix string) error
 
 // Delete 批量删除值
 func (store *MemoStore) Delete(keys []string, prefix string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
 	for _, key := range keys {
 		store.Store.Delete(prefix + key)
 	}
 	return nil
 }
+
+// SetNX 仅在键不存在时存储值
+func (store *MemoStore) SetNX(key string, value interface{}, ttl int) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.Get(key); ok {
+		return false, nil
+	}
+	store.Store.Store(key, newItem(value, ttl))
+	return true, nil
+}
+
+// CompareAndSwap 当前值与 old 相同时存储值
+func (store *MemoStore) CompareAndSwap(key string, old, value interface{}, ttl int) (bool, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	current, ok := store.Get(key)
+	if old == nil && ok || old != nil && (!ok || !reflect.DeepEqual(current, old)) {
+		return false, nil
+	}
+	store.Store.Store(key, newItem(value, ttl))
+	return true, nil
+}
+
+// Incr 增加计数器的值
+func (store *MemoStore) Incr(key string, delta int64, ttl int) (int64, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	raw, ok := store.Store.Load(key)
+	current, exists := getValue(raw, ok)
+	if !exists {
+		store.Store.Store(key, newItem(delta, ttl))
+		return delta, nil
+	}
+
+	count, ok := current.(int64)
+	if !ok {
+		return 0, errors.New("缓存值不是计数器")
+	}
+	item, _ := raw.(itemWithTTL)
+	item.value = count + delta
+	store.Store.Store(key, item)
+	return count + delta, nil
+}
+
+// lockToken 锁的持有者令牌
+type lockToken string
+
+// Lock 获取短期锁
+func (store *MemoStore) Lock(key string, ttl time.Duration) (string, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if _, ok := store.Get(key); ok {
+		return "", ErrLocked
+	}
+	token := newToken()
+	store.Store.Store(key, newItemWithDuration(lockToken(token), ttl))
+	return token, nil
+}
+
+// Unlock 释放锁
+func (store *MemoStore) Unlock(key, token string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if current, ok := store.Get(key); ok && current == lockToken(token) {
+		store.Store.Delete(key)
+	}
+	return nil
+}
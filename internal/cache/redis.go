@@ -1,8 +1,10 @@
 package cache
 
 import (
-	"bytes"
-	"encoding/gob"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
@@ -11,70 +13,119 @@ import (
 
 // RedisStore redis存储驱动
 type RedisStore struct {
+	// 单机与哨兵模式使用的连接池
 	pool *redis.Pool
+	// 集群模式下按键路由命令
+	cluster *cluster
+	// 是否读取旧版本以 gob 编码的值
+	gobFallback bool
 }
 
-type item struct {
-	Value interface{}
-}
+var (
+	// casScript 当前值与 ARGV[1] 相同时设置为 ARGV[2]，ARGV[1] 为空时要求键不存在
+	casScript = redis.NewScript(1, `
+local current = redis.call('GET', KEYS[1])
+if (current == false and ARGV[1] == '') or current == ARGV[1] then
+	if tonumber(ARGV[3]) > 0 then
+		redis.call('SET', KEYS[1], ARGV[2], 'EX', ARGV[3])
+	else
+		redis.call('SET', KEYS[1], ARGV[2])
+	end
+	return 1
+end
+return 0`)
+
+	// incrScript 增加计数器的值，计数器不存在时设置过期时间
+	incrScript = redis.NewScript(1, `
+local created = redis.call('EXISTS', KEYS[1]) == 0
+local value = redis.call('INCRBY', KEYS[1], ARGV[1])
+if created and tonumber(ARGV[2]) > 0 then
+	redis.call('EXPIRE', KEYS[1], ARGV[2])
+end
+return value`)
+
+	// unlockScript 令牌与当前持有者一致时释放锁
+	unlockScript = redis.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0`)
+
+	// rewriteScript 当前值与 ARGV[1] 相同时替换为 ARGV[2]，保留剩余的过期时间
+	rewriteScript = redis.NewScript(1, `
+if redis.call('GET', KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl > 0 then
+	redis.call('SET', KEYS[1], ARGV[2], 'PX', ttl)
+else
+	redis.call('SET', KEYS[1], ARGV[2])
+end
+return 1`)
+)
 
-func serializer(value interface{}) ([]byte, error) {
-	var buffer bytes.Buffer
-	enc := gob.NewEncoder(&buffer)
-	storeValue := item{
-		Value: value,
+// decode 解码 Redis 中的值，legacy 表示该值为旧版本的 gob 编码
+func (store *RedisStore) decode(raw []byte) (value interface{}, legacy bool, err error) {
+	value, err = decode(raw)
+	if err == nil || !store.gobFallback {
+		return value, false, err
 	}
-	err := enc.Encode(storeValue)
-	if err != nil {
-		return nil, err
+	if value, gobErr := decodeGob(raw); gobErr == nil {
+		return value, true, nil
 	}
-	return buffer.Bytes(), nil
+	return nil, false, err
 }
 
-func deserializer(value []byte) (interface{}, error) {
-	var res item
-	buffer := bytes.NewReader(value)
-	dec := gob.NewDecoder(buffer)
-	err := dec.Decode(&res)
+// rewrite 将旧版本以 gob 编码的值改写为当前编码，值已被修改时不做任何操作
+func (store *RedisStore) rewrite(rc redis.Conn, key string, raw []byte, value interface{}) (bool, error) {
+	serialized, err := encode(value)
+	if err != nil {
+		return false, err
+	}
+	rewritten, err := redis.Int(rewriteScript.Do(rc, key, raw, serialized))
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	return res.Value, nil
+	return rewritten == 1, nil
 }
 
 // NewRedisStore 创建新的redis存储
-func NewRedisStore(size int, network, address, password string, db int) *RedisStore {
-	return &RedisStore{
-		pool: &redis.Pool{
-			MaxIdle:     size,
-			IdleTimeout: 240 * time.Second,
-			TestOnBorrow: func(c redis.Conn, t time.Time) error {
-				_, err := c.Do("PING")
-				return err
-			},
-			Dial: func() (redis.Conn, error) {
-				c, err := redis.Dial(
-					network,
-					address,
-					redis.DialDatabase(db),
-					redis.DialPassword(password),
-				)
-				if err != nil {
-					logrus.WithError(err).Panic("Failed to create Redis connection: %s", err)
-					return nil, err
-				}
-				return c, nil
-			},
-		},
+func NewRedisStore(opts *RedisOptions) *RedisStore {
+	store := &RedisStore{gobFallback: opts.GobFallback}
+	switch opts.Mode {
+	case RedisCluster:
+		store.cluster = newCluster(opts)
+	case RedisSentinel:
+		store.pool = opts.newPool(newSentinel(opts).dial, checkMaster)
+	default:
+		addr := opts.Addrs[0]
+		store.pool = opts.newPool(func() (redis.Conn, error) {
+			c, err := opts.dial(addr, opts.Username, opts.Password, opts.DB)
+			if err != nil {
+				logrus.WithError(err).Errorf("Failed to create Redis connection: %s", err)
+				return nil, err
+			}
+			return c, nil
+		}, ping)
 	}
+	return store
+}
+
+// conn 返回执行 key 相关命令的连接，使用后需关闭
+func (store *RedisStore) conn(key string) redis.Conn {
+	if store.cluster != nil {
+		return clusterConn{cluster: store.cluster, key: key}
+	}
+	return store.pool.Get()
 }
 
 // Set 存储值
 func (store *RedisStore) Set(key string, value interface{}, ttl int) error {
-	rc := store.pool.Get()
+	rc := store.conn(key)
 	defer rc.Close()
 
-	serialized, err := serializer(value)
+	serialized, err := encode(value)
 	if err != nil {
 		return err
 	}
@@ -96,30 +147,60 @@ func (store *RedisStore) Set(key string, value interface{}, ttl int) error {
 
 }
 
-// Get 取值
+// Get 取值，读取失败时视为不存在
 func (store *RedisStore) Get(key string) (interface{}, bool) {
-	rc := store.pool.Get()
+	value, ok, _ := store.Fetch(key)
+	return value, ok
+}
+
+// Fetch 取值，键不存在时返回 false，连接失败或无法解码时返回错误
+func (store *RedisStore) Fetch(key string) (interface{}, bool, error) {
+	rc := store.conn(key)
 	defer rc.Close()
 	if rc.Err() != nil {
-		return nil, false
+		return nil, false, rc.Err()
 	}
 
 	v, err := redis.Bytes(rc.Do("GET", key))
-	if err != nil || v == nil {
-		return nil, false
+	if errors.Is(err, redis.ErrNil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
 	}
 
-	finalValue, err := deserializer(v)
+	finalValue, legacy, err := store.decode(v)
 	if err != nil {
-		return nil, false
+		logrus.WithField("key", key).WithError(err).Warningln("无法解码缓存值")
+		return nil, false, err
 	}
 
-	return finalValue, true
+	if legacy {
+		// 读取时顺带改写为当前编码，失败时下次读取再试
+		if _, err := store.rewrite(rc, key, v, finalValue); err != nil {
+			logrus.WithField("key", key).WithError(err).Debugln("改写旧版本缓存值失败")
+		}
+	}
 
+	return finalValue, true, nil
 }
 
 // Gets 批量取值
 func (store *RedisStore) Gets(keys []string, prefix string) (map[string]interface{}, []string) {
+	// 集群模式下各个键可能位于不同节点，逐个读取
+	if store.cluster != nil {
+		var res = make(map[string]interface{})
+		var missed = make([]string, 0, len(keys))
+		for _, key := range keys {
+			if value, ok := store.Get(prefix + key); ok {
+				res[key] = value
+			} else {
+				missed = append(missed, key)
+			}
+		}
+		return res, missed
+	}
+
 	rc := store.pool.Get()
 	defer rc.Close()
 	if rc.Err() != nil {
@@ -140,7 +221,11 @@ func (store *RedisStore) Gets(keys []string, prefix string) (map[string]interfac
 	var missed = make([]string, 0, len(keys))
 
 	for key, value := range v {
-		decoded, err := deserializer(value)
+		if value == nil {
+			missed = append(missed, keys[key])
+			continue
+		}
+		decoded, _, err := store.decode(value)
 		if err != nil || decoded == nil {
 			missed = append(missed, keys[key])
 		} else {
@@ -153,6 +238,15 @@ func (store *RedisStore) Gets(keys []string, prefix string) (map[string]interfac
 
 // Sets 批量设置值
 func (store *RedisStore) Sets(values map[string]interface{}, prefix string) error {
+	if store.cluster != nil {
+		for key, value := range values {
+			if err := store.Set(prefix+key, value, 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
 	rc := store.pool.Get()
 	defer rc.Close()
 	if rc.Err() != nil {
@@ -162,7 +256,7 @@ func (store *RedisStore) Sets(values map[string]interface{}, prefix string) erro
 
 	// 编码待设置值
 	for key, value := range values {
-		serialized, err := serializer(value)
+		serialized, err := encode(value)
 		if err != nil {
 			return err
 		}
@@ -179,6 +273,15 @@ func (store *RedisStore) Sets(values map[string]interface{}, prefix string) erro
 
 // Delete 批量删除给定的键
 func (store *RedisStore) Delete(keys []string, prefix string) error {
+	if store.cluster != nil {
+		for _, key := range keys {
+			if _, err := store.cluster.do(prefix+key, "DEL", prefix+key); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
 	rc := store.pool.Get()
 	defer rc.Close()
 	if rc.Err() != nil {
@@ -197,8 +300,133 @@ func (store *RedisStore) Delete(keys []string, prefix string) error {
 	return nil
 }
 
+// SetNX 仅在键不存在时存储值
+func (store *RedisStore) SetNX(key string, value interface{}, ttl int) (bool, error) {
+	rc := store.conn(key)
+	defer rc.Close()
+	if rc.Err() != nil {
+		return false, rc.Err()
+	}
+
+	serialized, err := encode(value)
+	if err != nil {
+		return false, err
+	}
+
+	args := redis.Args{}.Add(key, serialized, "NX")
+	if ttl > 0 {
+		args = args.Add("EX", ttl)
+	}
+	reply, err := redis.String(rc.Do("SET", args...))
+	if errors.Is(err, redis.ErrNil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return reply == "OK", nil
+}
+
+// CompareAndSwap 当前值与 old 相同时存储值，比较的是序列化后的内容
+func (store *RedisStore) CompareAndSwap(key string, old, value interface{}, ttl int) (bool, error) {
+	rc := store.conn(key)
+	defer rc.Close()
+	if rc.Err() != nil {
+		return false, rc.Err()
+	}
+
+	var expected []byte
+	if old != nil {
+		var err error
+		if expected, err = encode(old); err != nil {
+			return false, err
+		}
+	}
+	serialized, err := encode(value)
+	if err != nil {
+		return false, err
+	}
+
+	swapped, err := redis.Int(casScript.Do(rc, key, expected, serialized, ttl))
+	if err != nil || swapped == 1 || old == nil || !store.gobFallback {
+		return swapped == 1, err
+	}
+
+	// 当前值可能仍是旧版本的 gob 编码，解码后与 old 相同时以原始内容再比较一次
+	raw, err := redis.Bytes(rc.Do("GET", key))
+	if err != nil {
+		if errors.Is(err, redis.ErrNil) {
+			return false, nil
+		}
+		return false, err
+	}
+	current, legacy, err := store.decode(raw)
+	if err != nil || !legacy || !reflect.DeepEqual(current, old) {
+		return false, nil
+	}
+	swapped, err = redis.Int(casScript.Do(rc, key, raw, serialized, ttl))
+	if err != nil {
+		return false, err
+	}
+	return swapped == 1, nil
+}
+
+// Incr 增加计数器的值
+func (store *RedisStore) Incr(key string, delta int64, ttl int) (int64, error) {
+	rc := store.conn(key)
+	defer rc.Close()
+	if rc.Err() != nil {
+		return 0, rc.Err()
+	}
+
+	return redis.Int64(incrScript.Do(rc, key, delta, ttl))
+}
+
+// Lock 获取短期锁
+func (store *RedisStore) Lock(key string, ttl time.Duration) (string, error) {
+	rc := store.conn(key)
+	defer rc.Close()
+	if rc.Err() != nil {
+		return "", rc.Err()
+	}
+
+	token := newToken()
+	_, err := redis.String(rc.Do("SET", key, token, "NX", "PX", ttl.Milliseconds()))
+	if errors.Is(err, redis.ErrNil) {
+		return "", ErrLocked
+	}
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Unlock 释放锁
+func (store *RedisStore) Unlock(key, token string) error {
+	rc := store.conn(key)
+	defer rc.Close()
+	if rc.Err() != nil {
+		return rc.Err()
+	}
+
+	_, err := unlockScript.Do(rc, key, token)
+	return err
+}
+
 // DeleteAll 批量所有键
 func (store *RedisStore) DeleteAll() error {
+	if store.cluster != nil {
+		for _, addr := range store.cluster.masters() {
+			rc := store.cluster.pool(addr).Get()
+			_, err := rc.Do("FLUSHDB")
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
 	rc := store.pool.Get()
 	defer rc.Close()
 	if rc.Err() != nil {
@@ -209,3 +437,90 @@ func (store *RedisStore) DeleteAll() error {
 
 	return err
 }
+
+// MigrateLegacy 将以 prefixes 中任一前缀开头、旧版本以 gob 编码的值改写为当前编码，返回改写的数量。
+// 同一数据库中可能有其他程序（如 Cloudreve）以相同格式写入的值，因此只处理给定前缀的键；
+// 无法解码或类型未注册的值保持不变
+func (store *RedisStore) MigrateLegacy(ctx context.Context, prefixes []string) (int, error) {
+	var pools []*redis.Pool
+	if store.cluster != nil {
+		for _, addr := range store.cluster.masters() {
+			pools = append(pools, store.cluster.pool(addr))
+		}
+	} else {
+		pools = []*redis.Pool{store.pool}
+	}
+
+	migrated := 0
+	for _, pool := range pools {
+		for _, prefix := range prefixes {
+			n, err := store.migratePrefix(ctx, pool, prefix)
+			migrated += n
+			if err != nil {
+				return migrated, err
+			}
+		}
+	}
+	return migrated, nil
+}
+
+// migratePrefix 在一个节点上改写以 prefix 开头的键
+func (store *RedisStore) migratePrefix(ctx context.Context, pool *redis.Pool, prefix string) (int, error) {
+	// 转义 SCAN 匹配模式中的特殊字符
+	pattern := globEscaper.Replace(prefix) + "*"
+
+	migrated := 0
+	cursor := "0"
+	for {
+		if err := ctx.Err(); err != nil {
+			return migrated, err
+		}
+
+		rc := pool.Get()
+		reply, err := redis.Values(rc.Do("SCAN", cursor, "MATCH", pattern, "COUNT", 100))
+		rc.Close()
+		if err != nil {
+			return migrated, err
+		}
+		var keys []string
+		if _, err := redis.Scan(reply, &cursor, &keys); err != nil {
+			return migrated, err
+		}
+
+		for _, key := range keys {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			ok, err := store.migrateKey(key)
+			if err != nil {
+				logrus.WithField("key", key).WithError(err).Warningln("改写旧版本缓存值失败")
+			}
+			if ok {
+				migrated++
+			}
+		}
+
+		if cursor == "0" {
+			return migrated, nil
+		}
+	}
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+
+// migrateKey 键的值为旧版本的 gob 编码时改写为当前编码
+func (store *RedisStore) migrateKey(key string) (bool, error) {
+	rc := store.conn(key)
+	defer rc.Close()
+
+	raw, err := redis.Bytes(rc.Do("GET", key))
+	if err != nil {
+		// 键已被删除或不是字符串类型
+		return false, nil
+	}
+	value, legacy, err := store.decode(raw)
+	if err != nil || !legacy {
+		return false, nil
+	}
+	return store.rewrite(rc, key, raw, value)
+}
@@ -0,0 +1,224 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/samber/lo"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	clusterSlots        = 16384
+	clusterMaxRedirects = 5
+)
+
+var errPipelineUnsupported = errors.New("Redis 集群模式不支持流水线命令")
+
+// cluster 按键的槽位将命令发送到对应节点，并处理 MOVED 与 ASK 重定向
+type cluster struct {
+	opts *RedisOptions
+
+	mu    sync.RWMutex
+	slots [clusterSlots]string
+	pools map[string]*redis.Pool
+}
+
+func newCluster(opts *RedisOptions) *cluster {
+	c := &cluster{
+		opts:  opts,
+		pools: make(map[string]*redis.Pool),
+	}
+	if err := c.refresh(); err != nil {
+		logrus.WithError(err).Warningln("无法获取 Redis 集群的槽位分配，将按重定向更新")
+	}
+	return c
+}
+
+// keySlot 计算键所在的槽位，键中包含 {hash tag} 时仅使用其中的内容
+func keySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16([]byte(key)) % clusterSlots)
+}
+
+// crc16 CRC16-CCITT (XMODEM)
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// pool 返回节点的连接池
+func (c *cluster) pool(addr string) *redis.Pool {
+	c.mu.RLock()
+	p, ok := c.pools[addr]
+	c.mu.RUnlock()
+	if ok {
+		return p
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if p, ok := c.pools[addr]; ok {
+		return p
+	}
+	p = c.opts.newPool(func() (redis.Conn, error) {
+		return c.opts.dial(addr, c.opts.Username, c.opts.Password, 0)
+	}, ping)
+	c.pools[addr] = p
+	return p
+}
+
+// masters 返回持有槽位的节点，尚未获取槽位分配时返回配置的节点
+func (c *cluster) masters() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	masters := lo.Uniq(lo.Compact(c.slots[:]))
+	if len(masters) == 0 {
+		return c.opts.Addrs
+	}
+	return masters
+}
+
+// refresh 通过 CLUSTER SLOTS 更新槽位分配
+func (c *cluster) refresh() error {
+	var lastErr error
+	for _, addr := range lo.Uniq(append(c.masters(), c.opts.Addrs...)) {
+		conn := c.pool(addr).Get()
+		reply, err := redis.Values(conn.Do("CLUSTER", "SLOTS"))
+		conn.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		var slots [clusterSlots]string
+		for _, entry := range reply {
+			fields, err := redis.Values(entry, nil)
+			if err != nil || len(fields) < 3 {
+				continue
+			}
+			start, _ := redis.Int(fields[0], nil)
+			end, _ := redis.Int(fields[1], nil)
+			node, err := redis.Values(fields[2], nil)
+			if err != nil || len(node) < 2 {
+				continue
+			}
+			host, _ := redis.String(node[0], nil)
+			port, _ := redis.Int(node[1], nil)
+			if host == "" {
+				host, _, _ = net.SplitHostPort(addr)
+			}
+
+			nodeAddr := net.JoinHostPort(host, strconv.Itoa(port))
+			for slot := start; slot <= end && slot < clusterSlots; slot++ {
+				slots[slot] = nodeAddr
+			}
+		}
+
+		c.mu.Lock()
+		c.slots = slots
+		c.mu.Unlock()
+		return nil
+	}
+	return fmt.Errorf("无法获取 Redis 集群的槽位分配: %w", lastErr)
+}
+
+// addr 返回键所在的节点
+func (c *cluster) addr(key string) string {
+	c.mu.RLock()
+	addr := c.slots[keySlot(key)]
+	c.mu.RUnlock()
+
+	if addr == "" {
+		return c.opts.Addrs[0]
+	}
+	return addr
+}
+
+// do 在键所在的节点上执行命令，节点返回 MOVED 时更新槽位，返回 ASK 时仅本次命令转到目标节点
+func (c *cluster) do(key, cmd string, args ...interface{}) (interface{}, error) {
+	addr := c.addr(key)
+	asking := false
+	for i := 0; i < clusterMaxRedirects; i++ {
+		conn := c.pool(addr).Get()
+		if asking {
+			if _, err := conn.Do("ASKING"); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		reply, err := conn.Do(cmd, args...)
+		conn.Close()
+
+		var redisErr redis.Error
+		if !errors.As(err, &redisErr) {
+			return reply, err
+		}
+		kind, slot, target, ok := parseRedirect(redisErr)
+		if !ok {
+			return reply, err
+		}
+
+		addr, asking = target, kind == "ASK"
+		if kind == "MOVED" {
+			c.mu.Lock()
+			c.slots[slot] = target
+			c.mu.Unlock()
+		}
+	}
+	return nil, errors.New("Redis 集群重定向次数过多")
+}
+
+// parseRedirect 解析 MOVED 与 ASK 错误，格式为 "MOVED 槽位 地址"
+func parseRedirect(err redis.Error) (string, int, string, bool) {
+	fields := strings.Fields(string(err))
+	if len(fields) != 3 || (fields[0] != "MOVED" && fields[0] != "ASK") {
+		return "", 0, "", false
+	}
+	slot, convErr := strconv.Atoi(fields[1])
+	if convErr != nil || slot < 0 || slot >= clusterSlots {
+		return "", 0, "", false
+	}
+	return fields[0], slot, fields[2], true
+}
+
+// clusterConn 将命令发送到指定键所在节点的连接，仅支持 Do
+type clusterConn struct {
+	cluster *cluster
+	key     string
+}
+
+func (c clusterConn) Close() error { return nil }
+
+func (c clusterConn) Err() error { return nil }
+
+func (c clusterConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return c.cluster.do(c.key, cmd, args...)
+}
+
+func (c clusterConn) Send(string, ...interface{}) error { return errPipelineUnsupported }
+
+func (c clusterConn) Flush() error { return errPipelineUnsupported }
+
+func (c clusterConn) Receive() (interface{}, error) { return nil, errPipelineUnsupported }
@@ -0,0 +1,287 @@
+package cache
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+)
+
+// RedisMode Redis 部署方式
+type RedisMode string
+
+const (
+	// RedisStandalone 单机
+	RedisStandalone RedisMode = "standalone"
+	// RedisSentinel 通过哨兵发现主节点
+	RedisSentinel RedisMode = "sentinel"
+	// RedisCluster 集群
+	RedisCluster RedisMode = "cluster"
+)
+
+const (
+	defaultRedisPort    = "6379"
+	defaultSentinelPort = "26379"
+)
+
+// RedisOptions Redis 连接配置
+type RedisOptions struct {
+	Mode RedisMode
+	// 单机模式为服务器地址，哨兵模式为哨兵地址，集群模式为任意若干节点地址
+	Addrs []string
+	// ACL 用户名，为空时仅使用密码认证
+	Username string
+	Password string
+	// 集群模式只能使用 0 号数据库
+	DB int
+
+	// 哨兵模式下主节点的名称，以及连接哨兵时使用的认证信息
+	MasterName       string
+	SentinelUsername string
+	SentinelPassword string
+
+	// 为 nil 时不使用 TLS
+	TLS *tls.Config
+
+	MaxIdle        int
+	MaxActive      int
+	IdleTimeout    time.Duration
+	ConnectTimeout time.Duration
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+
+	// 是否读取旧版本以 gob 编码的值
+	GobFallback bool
+}
+
+// NewRedisOptions 从配置中读取 Redis 连接配置。配置了 CR_EPAY_REDIS_URL 时，
+// 地址、认证信息、数据库与部署方式均以 URL 为准
+func NewRedisOptions(conf *appconf.Config) (*RedisOptions, error) {
+	opts := &RedisOptions{
+		Mode:           RedisStandalone,
+		Addrs:          []string{conf.RedisServer},
+		Username:       conf.RedisUsername,
+		Password:       conf.RedisPassword,
+		DB:             conf.RedisDB,
+		MaxIdle:        conf.RedisMaxIdle,
+		MaxActive:      conf.RedisMaxActive,
+		IdleTimeout:    conf.RedisIdleTimeout,
+		ConnectTimeout: conf.RedisConnectTimeout,
+		ReadTimeout:    conf.RedisReadTimeout,
+		WriteTimeout:   conf.RedisWriteTimeout,
+		GobFallback:    conf.RedisGobFallback,
+	}
+
+	useTLS := conf.RedisTLS
+	if conf.RedisURL != "" {
+		var err error
+		if useTLS, err = opts.parseURL(conf.RedisURL); err != nil {
+			return nil, err
+		}
+	}
+
+	if useTLS || conf.RedisCACert != "" || conf.RedisClientCert != "" {
+		tlsConfig, err := loadTLSConfig(conf)
+		if err != nil {
+			return nil, err
+		}
+		opts.TLS = tlsConfig
+	}
+
+	return opts, nil
+}
+
+// parseURL 解析 Redis URL，返回是否使用 TLS。支持的格式：
+//
+//	redis://[用户名[:密码]@]主机[:端口][/数据库]
+//	redis-sentinel://[用户名[:密码]@]哨兵1[:端口],哨兵2[:端口]/主节点名称[/数据库][?sentinel_username=&sentinel_password=]
+//	redis-cluster://[用户名[:密码]@]节点1[:端口],节点2[:端口]
+//
+// 协议名中的 redis 替换为 rediss 时使用 TLS
+func (opts *RedisOptions) parseURL(raw string) (bool, error) {
+	scheme, rest, ok := strings.Cut(raw, "://")
+	if !ok {
+		return false, fmt.Errorf("无效的 Redis URL: %q", raw)
+	}
+
+	scheme = strings.ToLower(scheme)
+	var useTLS bool
+	switch scheme {
+	case "redis", "redis-sentinel", "redis-cluster":
+	case "rediss", "rediss-sentinel", "rediss-cluster":
+		useTLS = true
+	default:
+		return false, fmt.Errorf("不支持的 Redis URL 协议: %q", scheme)
+	}
+
+	opts.Mode = RedisStandalone
+	defaultPort := defaultRedisPort
+	switch {
+	case strings.HasSuffix(scheme, "-sentinel"):
+		opts.Mode = RedisSentinel
+		defaultPort = defaultSentinelPort
+	case strings.HasSuffix(scheme, "-cluster"):
+		opts.Mode = RedisCluster
+	}
+
+	// 多个主机以逗号分隔，net/url 无法解析，单独处理
+	authority, tail := rest, ""
+	if i := strings.IndexAny(rest, "/?#"); i >= 0 {
+		authority, tail = rest[:i], rest[i:]
+	}
+	userinfo, hosts := "", authority
+	if i := strings.LastIndex(authority, "@"); i >= 0 {
+		userinfo, hosts = authority[:i+1], authority[i+1:]
+	}
+
+	u, err := url.Parse("redis://" + userinfo + "localhost" + tail)
+	if err != nil {
+		return false, fmt.Errorf("无效的 Redis URL: %w", err)
+	}
+
+	opts.Addrs = nil
+	for _, host := range strings.Split(hosts, ",") {
+		if host = strings.TrimSpace(host); host == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), defaultPort)
+		}
+		opts.Addrs = append(opts.Addrs, host)
+	}
+	if len(opts.Addrs) == 0 {
+		return false, errors.New("Redis URL 中没有主机地址")
+	}
+	if opts.Mode == RedisStandalone && len(opts.Addrs) > 1 {
+		return false, errors.New("单机模式的 Redis URL 只能包含一个主机地址")
+	}
+
+	opts.Username, opts.Password = "", ""
+	if u.User != nil {
+		opts.Username = u.User.Username()
+		opts.Password, _ = u.User.Password()
+		// redis://:password@host 形式仅包含密码
+		if _, ok := u.User.Password(); !ok {
+			opts.Username, opts.Password = "", opts.Username
+		}
+	}
+
+	segments := strings.FieldsFunc(u.Path, func(r rune) bool { return r == '/' })
+	if opts.Mode == RedisSentinel {
+		if len(segments) == 0 {
+			return false, errors.New("哨兵模式的 Redis URL 需要在路径中指定主节点名称")
+		}
+		opts.MasterName, segments = segments[0], segments[1:]
+		opts.SentinelUsername = u.Query().Get("sentinel_username")
+		opts.SentinelPassword = u.Query().Get("sentinel_password")
+	}
+
+	opts.DB = 0
+	switch len(segments) {
+	case 0:
+	case 1:
+		if opts.DB, err = strconv.Atoi(segments[0]); err != nil || opts.DB < 0 {
+			return false, fmt.Errorf("无效的 Redis 数据库: %q", segments[0])
+		}
+	default:
+		return false, fmt.Errorf("无效的 Redis URL 路径: %q", u.Path)
+	}
+	if opts.Mode == RedisCluster && opts.DB != 0 {
+		return false, errors.New("集群模式只能使用 0 号数据库")
+	}
+
+	return useTLS, nil
+}
+
+// loadTLSConfig 读取 CA 证书与客户端证书
+func loadTLSConfig(conf *appconf.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         conf.RedisTLSServerName,
+		InsecureSkipVerify: conf.RedisTLSInsecureSkipVerify,
+	}
+
+	if conf.RedisCACert != "" {
+		pem, err := os.ReadFile(conf.RedisCACert)
+		if err != nil {
+			return nil, fmt.Errorf("无法读取 Redis CA 证书: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("Redis CA 证书中没有有效的证书")
+		}
+	}
+
+	if conf.RedisClientCert != "" || conf.RedisClientKey != "" {
+		cert, err := tls.LoadX509KeyPair(conf.RedisClientCert, conf.RedisClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("无法读取 Redis 客户端证书: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// dial 连接 addr 并完成认证与数据库选择
+func (opts *RedisOptions) dial(addr, username, password string, db int) (redis.Conn, error) {
+	options := []redis.DialOption{
+		redis.DialConnectTimeout(opts.ConnectTimeout),
+		redis.DialReadTimeout(opts.ReadTimeout),
+		redis.DialWriteTimeout(opts.WriteTimeout),
+	}
+	if opts.TLS != nil {
+		options = append(options, redis.DialUseTLS(true), redis.DialTLSConfig(opts.TLS))
+	}
+
+	c, err := redis.Dial("tcp", addr, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	// redigo 的 DialPassword 不支持 ACL 用户名，连接后自行认证
+	if password != "" {
+		args := []interface{}{password}
+		if username != "" {
+			args = []interface{}{username, password}
+		}
+		if _, err := c.Do("AUTH", args...); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if db != 0 {
+		if _, err := c.Do("SELECT", db); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// newPool 创建连接池，dial 用于建立新连接
+func (opts *RedisOptions) newPool(dial func() (redis.Conn, error), testOnBorrow func(c redis.Conn, t time.Time) error) *redis.Pool {
+	return &redis.Pool{
+		MaxIdle:      opts.MaxIdle,
+		MaxActive:    opts.MaxActive,
+		Wait:         opts.MaxActive > 0,
+		IdleTimeout:  opts.IdleTimeout,
+		TestOnBorrow: testOnBorrow,
+		Dial:         dial,
+	}
+}
+
+// ping 检查空闲连接是否可用
+func ping(c redis.Conn, t time.Time) error {
+	_, err := c.Do("PING")
+	return err
+}
@@ -0,0 +1,102 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/sirupsen/logrus"
+)
+
+// sentinel 通过哨兵查询当前主节点的地址
+type sentinel struct {
+	opts *RedisOptions
+
+	mu    sync.Mutex
+	addrs []string
+}
+
+func newSentinel(opts *RedisOptions) *sentinel {
+	return &sentinel{
+		opts:  opts,
+		addrs: append([]string(nil), opts.Addrs...),
+	}
+}
+
+// master 依次询问各个哨兵，返回主节点地址。应答的哨兵移到列表最前，下次优先询问
+func (s *sentinel) master() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var lastErr error
+	for i, addr := range s.addrs {
+		master, err := s.query(addr)
+		if err != nil {
+			logrus.WithError(err).WithField("sentinel", addr).Warningln("无法从哨兵获取 Redis 主节点地址")
+			lastErr = err
+			continue
+		}
+
+		if i > 0 {
+			s.addrs = append(append([]string{addr}, s.addrs[:i]...), s.addrs[i+1:]...)
+		}
+		return master, nil
+	}
+	return "", fmt.Errorf("所有哨兵均无法提供主节点 %s 的地址: %w", s.opts.MasterName, lastErr)
+}
+
+func (s *sentinel) query(addr string) (string, error) {
+	c, err := s.opts.dial(addr, s.opts.SentinelUsername, s.opts.SentinelPassword, 0)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	reply, err := redis.Strings(c.Do("SENTINEL", "get-master-addr-by-name", s.opts.MasterName))
+	if err != nil {
+		if errors.Is(err, redis.ErrNil) {
+			return "", fmt.Errorf("哨兵未监控主节点 %s", s.opts.MasterName)
+		}
+		return "", err
+	}
+	if len(reply) != 2 {
+		return "", fmt.Errorf("哨兵返回的主节点地址无效: %v", reply)
+	}
+	return net.JoinHostPort(reply[0], reply[1]), nil
+}
+
+// dial 连接当前主节点，并确认其角色仍为主节点
+func (s *sentinel) dial() (redis.Conn, error) {
+	addr, err := s.master()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := s.opts.dial(addr, s.opts.Username, s.opts.Password, s.opts.DB)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkMaster(c, time.Time{}); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("Redis 节点 %s: %w", addr, err)
+	}
+	return c, nil
+}
+
+// checkMaster 检查连接的节点是否为主节点，故障转移后原主节点的连接不再使用
+func checkMaster(c redis.Conn, _ time.Time) error {
+	reply, err := redis.Values(c.Do("ROLE"))
+	if err != nil {
+		return err
+	}
+	if len(reply) == 0 {
+		return errors.New("无法获取节点角色")
+	}
+	if role, _ := redis.String(reply[0], nil); role != "master" {
+		return fmt.Errorf("节点角色为 %s，不是主节点", role)
+	}
+	return nil
+}
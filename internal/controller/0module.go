@@ -5,30 +5,52 @@ import (
 	"github.com/imroc/req/v3"
 	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
 	"github.com/topjohncian/cloudreve-pro-epay/internal/cache"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/currency"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/netguard"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/outbox"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/payment"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/provider"
 	"go.uber.org/fx"
 )
 
 type CloudrevePayController struct {
 	fx.In
 
-	Conf   *appconf.Config
-	Cache  cache.Driver
-	Client *req.Client
+	Conf      *appconf.Config
+	Cache     cache.Driver
+	Orders    order.Repository
+	Payments  *payment.Service
+	Providers *provider.Registry
+	Currency  *currency.Converter
+	Client    *req.Client
+
+	// 发送 Cloudreve 通知时使用，连接前检查目标地址
+	NotifyPolicy *netguard.Policy
+	NotifyClient *req.Client `name:"notify"`
 }
 
 func RegisterControllers(c CloudrevePayController, r *gin.Engine) {
 	r.POST("/cloudreve/purchase", c.BearerAuthMiddleware(), c.Purchase)
 	r.GET("/cloudreve/purchase", c.BearerAuthMiddleware(), c.QueryOrderStatus)
+	r.POST("/cloudreve/refund", c.BearerAuthMiddleware(), c.Refund)
 	r.GET("/purchase/:id", c.PurchasePage)
 	r.GET("/notify/:id", c.Notify)
 	r.GET("/return/:id", c.Return)
 	r.GET("/cloudreve/callback", c.Callback)
-	
+	r.GET("/payment/:provider/notify/:id", c.ProviderNotify)
+	r.POST("/payment/:provider/notify/:id", c.ProviderNotify)
+
 	// 添加 Cloudreve V4 版本的回调路由
 	r.GET("/api/v4/callback/custom/:id", c.CloudreveV4Callback)
 	r.POST("/api/v4/callback/custom/:id", c.CloudreveV4Callback)
 }
 
 func Module() fx.Option {
-	return fx.Module("controller", fx.Invoke(RegisterControllers))
+	return fx.Module("controller",
+		fx.Provide(func(c CloudrevePayController) outbox.Deliverer {
+			return &c
+		}),
+		fx.Invoke(RegisterControllers),
+	)
 }
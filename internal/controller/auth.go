@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"crypto/hmac"
 	"net/http"
 	"strconv"
 	"strings"
@@ -12,52 +13,33 @@ import (
 
 func (pc *CloudrevePayController) BearerAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 检查是否有 sign 参数，如果有，则直接验证 sign
+		// 检查是否有 sign 参数，如果有，则直接验证 sign。
+		// URL 签名不包含请求正文，仅用于 GET 请求，POST 请求必须使用 Authorization 头
 		sign := c.Query("sign")
-		if sign != "" {
+		if sign != "" && c.Request.Method != http.MethodGet {
+			logrus.WithField("method", c.Request.Method).WithField("path", c.Request.URL.Path).Debugln("URL 签名仅可用于 GET 请求，忽略 sign 参数")
+		}
+		if sign != "" && c.Request.Method == http.MethodGet {
 			logrus.WithField("sign", sign).Debugln("从 URL 参数中获取到 sign")
-			
-			// 分解 sign 参数
-			signParts := strings.Split(sign, ":")
-			if len(signParts) != 2 {
-				logrus.WithField("sign", sign).Debugln("sign 参数格式无效")
-				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-					"code":    http.StatusUnauthorized,
-					"data":    "",
-					"message": "sign 参数格式无效",
-				})
-				return
-			}
-			
-			// 验证是否过期
-			expires, err := strconv.ParseInt(signParts[1], 10, 64)
-			if err != nil {
-				logrus.WithField("sign", sign).WithField("expires", signParts[1]).Debugln("sign 参数的过期时间无效")
-				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
-					"code":    http.StatusUnauthorized,
-					"data":    "",
-					"message": "sign 参数的过期时间无效",
-				})
-				return
+
+			auth := HMACAuth{
+				CloudreveKey: []byte(pc.Conf.CloudreveKey),
 			}
-			
-			// 如果签名过期
-			if expires < time.Now().Unix() && expires != 0 {
-				logrus.WithField("sign", sign).WithField("expires", expires).Debugln("sign 参数已过期")
+			if err := CheckURI(auth, c.Request.URL, pc.Conf.CloudreveAllowNonExpiringSign); err != nil {
+				logrus.WithField("sign", sign).WithField("path", c.Request.URL.Path).WithError(err).Debugln("sign 参数验证失败")
 				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 					"code":    http.StatusUnauthorized,
 					"data":    "",
-					"message": "sign 参数已过期",
+					"message": "sign 参数无效，" + err.Error(),
 				})
 				return
 			}
-			
-			// 对于 URL 参数中的 sign，直接允许通过
+
 			logrus.WithField("sign", sign).Debugln("sign 参数验证成功")
 			c.Set("CloudreveAuthUser", "url_sign_user")
 			return
 		}
-		
+
 		// 如果没有 sign 参数，则检查 Authorization 头
 		authorization := c.Request.Header.Get("Authorization")
 		if authorization == "" || !strings.HasPrefix(authorization, "Bearer ") {
@@ -111,10 +93,10 @@ func (pc *CloudrevePayController) BearerAuthMiddleware() gin.HandlerFunc {
 
 		// 获取待签名内容
 		signContent := getSignContent(c.Request)
-		
+
 		// 生成签名
 		generatedSign := auth.Sign(signContent, expires)
-		
+
 		// 检查签名是否匹配
 		// 修复可能的前缀问题
 		signatureTrimmed := signature
@@ -123,14 +105,14 @@ func (pc *CloudrevePayController) BearerAuthMiddleware() gin.HandlerFunc {
 			// 取最后一部分作为实际签名
 			signatureTrimmed = parts[len(parts)-1]
 		}
-		
-		if signatureTrimmed != generatedSign {
+
+		if !hmac.Equal([]byte(signatureTrimmed), []byte(generatedSign)) {
 			logrus.WithFields(logrus.Fields{
-				"Authorization": authorization,
-				"signature": signature,
+				"Authorization":    authorization,
+				"signature":        signature,
 				"signatureTrimmed": signatureTrimmed,
-				"generatedSign": generatedSign,
-				"signContent": signContent,
+				"generatedSign":    generatedSign,
+				"signContent":      signContent,
 			}).Debugln("Authorization 头无效，签名不匹配")
 
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+)
+
+const testCloudreveKey = "cloudreve-key"
+
+// newAuthEngine 返回使用 BearerAuthMiddleware 保护的 GET 与 POST 路由
+func newAuthEngine(allowNonExpiring bool) *gin.Engine {
+	pc := &CloudrevePayController{Conf: &appconf.Config{
+		CloudreveKey:                  testCloudreveKey,
+		CloudreveAllowNonExpiringSign: allowNonExpiring,
+	}}
+
+	r := gin.New()
+	ok := func(c *gin.Context) { c.String(http.StatusOK, "ok") }
+	r.GET("/cloudreve/purchase", pc.BearerAuthMiddleware(), ok)
+	r.POST("/cloudreve/purchase", pc.BearerAuthMiddleware(), ok)
+	return r
+}
+
+// signedURL 返回以 key 签名、expires 秒后过期的 URL，expires 为 0 时永不过期
+func signedURL(t *testing.T, key, uri string, expires int64) string {
+	t.Helper()
+
+	u, err := SignURI(HMACAuth{CloudreveKey: []byte(key)}, uri, expires)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.String()
+}
+
+func TestBearerAuthMiddleware(t *testing.T) {
+	body := `{"order_no":"o1","amount":100}`
+	bearer := func(key, body string, expires int64) http.Header {
+		r := httptest.NewRequest(http.MethodPost, "/cloudreve/purchase", strings.NewReader(body))
+		SignRequest(HMACAuth{CloudreveKey: []byte(key)}, r, expires)
+		return r.Header
+	}
+
+	cases := []struct {
+		name             string
+		method           string
+		target           string
+		header           http.Header
+		body             string
+		allowNonExpiring bool
+		code             int
+	}{
+		{"有效的 URL 签名", http.MethodGet, signedURL(t, testCloudreveKey, "/cloudreve/purchase?order_no=o1", 60), nil, "", false, http.StatusOK},
+		{"伪造的 URL 签名", http.MethodGet, signedURL(t, "other-key", "/cloudreve/purchase?order_no=o1", 60), nil, "", false, http.StatusUnauthorized},
+		{"任意 MAC", http.MethodGet, "/cloudreve/purchase?order_no=o1&sign=x:0", nil, "", true, http.StatusUnauthorized},
+		{"过期的 URL 签名", http.MethodGet, signedURL(t, testCloudreveKey, "/cloudreve/purchase?order_no=o1", -60), nil, "", false, http.StatusUnauthorized},
+		{"未允许永不过期的签名", http.MethodGet, signedURL(t, testCloudreveKey, "/cloudreve/purchase?order_no=o1", 0), nil, "", false, http.StatusUnauthorized},
+		{"允许永不过期的签名", http.MethodGet, signedURL(t, testCloudreveKey, "/cloudreve/purchase?order_no=o1", 0), nil, "", true, http.StatusOK},
+		{"POST 请求使用 URL 签名", http.MethodPost, signedURL(t, testCloudreveKey, "/cloudreve/purchase", 60), nil, body, false, http.StatusUnauthorized},
+		{"POST 请求使用永不过期的 URL 签名", http.MethodPost, signedURL(t, testCloudreveKey, "/cloudreve/purchase", 0), nil, body, true, http.StatusUnauthorized},
+		{"有效的 Authorization 头", http.MethodPost, "/cloudreve/purchase", bearer(testCloudreveKey, body, 60), body, false, http.StatusOK},
+		{"Authorization 头签名后修改正文", http.MethodPost, "/cloudreve/purchase", bearer(testCloudreveKey, body, 60), `{"order_no":"o1","amount":1}`, false, http.StatusUnauthorized},
+		{"其他密钥的 Authorization 头", http.MethodPost, "/cloudreve/purchase", bearer("other-key", body, 60), body, false, http.StatusUnauthorized},
+		{"过期的 Authorization 头", http.MethodPost, "/cloudreve/purchase", bearer(testCloudreveKey, body, -60), body, false, http.StatusUnauthorized},
+		{"缺少签名", http.MethodPost, "/cloudreve/purchase", nil, body, false, http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(c.method, c.target, bytes.NewBufferString(c.body))
+		for k, v := range c.header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		newAuthEngine(c.allowNonExpiring).ServeHTTP(w, r)
+
+		if w.Code != c.code {
+			t.Fatalf("%s: 返回 HTTP %d，应为 %d: %s", c.name, w.Code, c.code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/outbox"
+)
+
+type NotifyResponse struct {
+	Code  int    `json:"code"`
+	Error string `json:"error"`
+}
+
+var _ outbox.Deliverer = &CloudrevePayController{}
+
+// Deliver 将订单的支付成功通知发送给 Cloudreve，成功后将订单标记为已通知。
+// 订单不存在、订单未支付或通知地址不被允许时重试也无法成功，返回 outbox.PermanentError；
+// 缓存读取失败时订单可能存在，返回普通错误以便稍后重试
+func (pc *CloudrevePayController) Deliver(ctx context.Context, entry *outbox.Entry) error {
+	orderInfo, err := pc.Orders.Get(entry.OrderNo)
+	if err != nil {
+		if errors.Is(err, order.ErrNotFound) {
+			return outbox.Permanent(err)
+		}
+		return err
+	}
+
+	switch orderInfo.Status {
+	case order.StatusPaid:
+	case order.StatusNotified, order.StatusRefunded:
+		// 订单已通知或已退款，无需再次通知
+		return nil
+	default:
+		return outbox.Permanent(fmt.Errorf("订单状态为 %s，无法通知", orderInfo.Status))
+	}
+
+	// 订单可能在调整允许列表前创建，发送前再次检查
+	if err := pc.NotifyPolicy.CheckURL(orderInfo.NotifyUrl); err != nil {
+		return outbox.Permanent(err)
+	}
+
+	if err := pc.notifyCloudreve(ctx, orderInfo); err != nil {
+		return err
+	}
+
+	_, err = pc.Orders.Update(entry.OrderNo, func(o *order.Order) error {
+		if o.Status != order.StatusPaid {
+			return nil
+		}
+		return o.Transition(order.StatusNotified, "已通知 Cloudreve")
+	})
+	return err
+}
+
+// notifyCloudreve 向订单的 notify_url 发送带 HMAC 签名的 GET 请求
+func (pc *CloudrevePayController) notifyCloudreve(ctx context.Context, orderInfo *order.Order) error {
+	var notifyRes NotifyResponse
+
+	// 生成 HMAC 签名用于授权
+	auth := &HMACAuth{
+		CloudreveKey: []byte(pc.Conf.CloudreveKey),
+	}
+
+	// 生成带有过期时间的签名（10分钟后过期）
+	expires := time.Now().Add(10 * time.Minute).Unix()
+
+	// 解析通知 URL
+	parsedURL, err := url.Parse(orderInfo.NotifyUrl)
+	if err != nil {
+		return err
+	}
+
+	// 生成签名内容
+	// 使用与服务器相同的方式生成签名内容
+	req := RequestRawSign{
+		Path:   parsedURL.Path,
+		Header: "",
+		Body:   "", // 通知请求没有请求体
+	}
+	signContentBytes, _ := json.Marshal(req)
+	signContent := string(signContentBytes)
+
+	// 生成签名
+	signature := auth.Sign(signContent, expires)
+
+	// 生成 Authorization 头
+	authHeader := "Bearer " + signature
+	logrus.WithField("order_no", orderInfo.OrderNo).WithField("Authorization", authHeader).Debugln("生成的 Authorization 头")
+
+	// 发送 GET 请求
+	// 根据文档要求，回调通知应该使用 GET 请求
+	resp, err := pc.NotifyClient.R().
+		SetContext(ctx).
+		SetSuccessResult(&notifyRes).
+		SetHeader("Authorization", authHeader).
+		Get(orderInfo.NotifyUrl)
+
+	if err != nil {
+		return err
+	}
+
+	if !resp.IsSuccessState() {
+		logrus.WithField("order_no", orderInfo.OrderNo).WithField("dump", resp.Dump()).Debugln("通知失败")
+		return errors.New("http code: " + strconv.Itoa(resp.StatusCode))
+	}
+
+	if notifyRes.Code != 0 {
+		logrus.WithField("order_no", orderInfo.OrderNo).WithField("dump", resp.Dump()).Debugln("通知失败")
+		return errors.New("code: " + strconv.Itoa(notifyRes.Code) + ", error: " + notifyRes.Error)
+	}
+
+	return nil
+}
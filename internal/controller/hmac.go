@@ -6,9 +6,11 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"sort"
 	"strconv"
 	"strings"
@@ -17,6 +19,13 @@ import (
 
 const CrHeaderPrefix = "X-Cr-"
 
+var (
+	ErrExpiresMissing  = errors.New("签名缺少过期时间")
+	ErrExpired         = errors.New("签名已过期")
+	ErrAuthFailed      = errors.New("签名不匹配")
+	ErrNonExpiringSign = errors.New("不允许使用永不过期的签名")
+)
+
 // General 通用的认证接口
 var General Auth
 
@@ -120,27 +129,63 @@ func (auth HMACAuth) Sign(body string, expires int64) string {
 	return base64.URLEncoding.EncodeToString(h.Sum(nil)) + ":" + expireTimeStamp
 }
 
-// // Check 对给定Body和Sign进行鉴权，包括对expires的检查
-// func (auth HMACAuth) Check(body string, sign string) error {
-// 	signSlice := strings.Split(sign, ":")
-// 	// 如果未携带expires字段
-// 	if signSlice[len(signSlice)-1] == "" {
-// 		return ErrExpiresMissing
-// 	}
+// Check 对给定Body和Sign进行鉴权，包括对expires的检查
+func (auth HMACAuth) Check(body string, sign string) error {
+	signSlice := strings.Split(sign, ":")
+	// 如果未携带expires字段
+	if signSlice[len(signSlice)-1] == "" {
+		return ErrExpiresMissing
+	}
 
-// 	// 验证是否过期
-// 	expires, err := strconv.ParseInt(signSlice[len(signSlice)-1], 10, 64)
-// 	if err != nil {
-// 		return ErrAuthFailed.WithError(err)
-// 	}
-// 	// 如果签名过期
-// 	if expires < time.Now().Unix() && expires != 0 {
-// 		return ErrExpired
-// 	}
+	// 验证是否过期
+	expires, err := strconv.ParseInt(signSlice[len(signSlice)-1], 10, 64)
+	if err != nil {
+		return ErrExpiresMissing
+	}
+	// 如果签名过期
+	if expires < time.Now().Unix() && expires != 0 {
+		return ErrExpired
+	}
 
-// 	// 验证签名
-// 	if auth.Sign(body, expires) != sign {
-// 		return ErrAuthFailed
-// 	}
-// 	return nil
-// }
+	// 验证签名
+	if !hmac.Equal([]byte(auth.Sign(body, expires)), []byte(sign)) {
+		return ErrAuthFailed
+	}
+	return nil
+}
+
+// SignURI 对URI进行签名，签名只针对Path部分，query部分不做验证，
+// 与 Cloudreve 的 URL 签名方式一致
+func SignURI(instance Auth, uri string, expires int64) (*url.URL, error) {
+	// 处理有效期
+	if expires != 0 {
+		expires += time.Now().Unix()
+	}
+
+	base, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	// 生成签名
+	sign := instance.Sign(base.Path, expires)
+
+	// 将签名加到URI中
+	queries := base.Query()
+	queries.Set("sign", sign)
+	base.RawQuery = queries.Encode()
+
+	return base, nil
+}
+
+// CheckURI 对URI进行鉴权，allowNonExpiring 为 false 时拒绝过期时间为 0 的签名
+func CheckURI(instance Auth, uri *url.URL, allowNonExpiring bool) error {
+	sign := uri.Query().Get("sign")
+	signSlice := strings.Split(sign, ":")
+	expires, err := strconv.ParseInt(signSlice[len(signSlice)-1], 10, 64)
+	if err == nil && expires == 0 && !allowNonExpiring {
+		return ErrNonExpiringSign
+	}
+
+	return instance.Check(uri.Path, sign)
+}
@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/provider"
+)
+
+// defaultMethodNames 常见支付方式的默认显示名称
+var defaultMethodNames = map[string]string{
+	"alipay": "支付宝",
+	"wxpay":  "微信支付",
+	"qqpay":  "QQ 钱包",
+	"bank":   "云闪付",
+	"jdpay":  "京东支付",
+	"paypal": "PayPal",
+	"usdt":   "USDT",
+}
+
+// PurchaseMethod 支付页面上可供选择的支付方式
+type PurchaseMethod struct {
+	Type string
+	Name string
+	Icon string
+	// 处理该支付方式的支付渠道，为空时使用默认渠道
+	Provider string
+}
+
+// purchaseMethods 解析启用的支付方式，每项格式为 type|显示名称|图标地址|支付渠道，
+// 名称、图标与渠道可省略。未配置时仅启用 EpayPurchaseType。
+// 重复的支付方式只保留第一项，支付渠道未启用的支付方式会被忽略
+func purchaseMethods(conf *appconf.Config, providers *provider.Registry) []PurchaseMethod {
+	entries := conf.EpayPurchaseMethods
+	if len(entries) == 0 {
+		entries = []string{conf.EpayPurchaseType}
+	}
+
+	var methods []PurchaseMethod
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		parts := strings.SplitN(strings.TrimSpace(entry), "|", 4)
+		if parts[0] == "" {
+			continue
+		}
+		if seen[parts[0]] {
+			logrus.WithField("type", parts[0]).Debugln("重复的支付方式，已忽略")
+			continue
+		}
+
+		method := PurchaseMethod{Type: parts[0]}
+		if len(parts) > 1 {
+			method.Name = parts[1]
+		}
+		if len(parts) > 2 {
+			method.Icon = parts[2]
+		}
+		if len(parts) > 3 {
+			method.Provider = parts[3]
+		}
+		if method.Name == "" {
+			method.Name = defaultMethodNames[method.Type]
+		}
+		if method.Name == "" {
+			method.Name = parts[0]
+		}
+		if _, err := providers.Get(method.Provider); err != nil {
+			logrus.WithError(err).WithField("type", method.Type).Debugln("支付方式的支付渠道未启用，已忽略")
+			continue
+		}
+
+		seen[method.Type] = true
+		methods = append(methods, method)
+	}
+
+	return methods
+}
+
+// findPurchaseMethod 返回已启用的支付方式
+func findPurchaseMethod(methods []PurchaseMethod, purchaseType string) (PurchaseMethod, bool) {
+	for _, method := range methods {
+		if method.Type == purchaseType {
+			return method, true
+		}
+	}
+	return PurchaseMethod{}, false
+}
@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/provider"
+)
+
+// namedProvider 仅用于注册渠道名称的支付渠道
+type namedProvider string
+
+func (p namedProvider) Name() string { return string(p) }
+
+func (p namedProvider) CreatePayment(context.Context, *provider.PaymentRequest) (*provider.Checkout, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (p namedProvider) VerifyNotification(*http.Request, *order.Order) (*provider.Result, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (p namedProvider) Query(context.Context, *order.Order) (*provider.Result, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (p namedProvider) Refund(context.Context, *order.Order, int) error {
+	return errors.New("not implemented")
+}
+
+func (p namedProvider) Ack(http.ResponseWriter, error) {}
+
+func TestPurchaseMethods(t *testing.T) {
+	// 启用 epay（默认渠道）与 alipay 渠道，wechatpay 未启用
+	conf := &appconf.Config{PaymentProvider: "epay", EpayPurchaseType: "alipay"}
+	registry, err := provider.NewRegistry(conf, []provider.Provider{namedProvider("epay"), namedProvider("alipay")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		entries []string
+		want    []PurchaseMethod
+	}{
+		{
+			name: "未配置时使用 EpayPurchaseType",
+			want: []PurchaseMethod{{Type: "alipay", Name: "支付宝"}},
+		},
+		{
+			name:    "空白项",
+			entries: []string{"", "  ", "|微信支付", "wxpay"},
+			want:    []PurchaseMethod{{Type: "wxpay", Name: "微信支付"}},
+		},
+		{
+			name:    "完整格式",
+			entries: []string{" wxpay|微信|https://example.com/wxpay.svg|alipay "},
+			want:    []PurchaseMethod{{Type: "wxpay", Name: "微信", Icon: "https://example.com/wxpay.svg", Provider: "alipay"}},
+		},
+		{
+			name:    "未知类型使用类型作为名称",
+			entries: []string{"ecny", "ecny2||https://example.com/ecny.svg"},
+			want: []PurchaseMethod{
+				{Type: "ecny", Name: "ecny"},
+				{Type: "ecny2", Name: "ecny2", Icon: "https://example.com/ecny.svg"},
+			},
+		},
+		{
+			name:    "重复的类型只保留第一项",
+			entries: []string{"alipay|支付宝||alipay", "wxpay", "alipay|易支付支付宝"},
+			want: []PurchaseMethod{
+				{Type: "alipay", Name: "支付宝", Provider: "alipay"},
+				{Type: "wxpay", Name: "微信支付"},
+			},
+		},
+		{
+			name:    "忽略未启用渠道的支付方式",
+			entries: []string{"wxpay|微信支付||wechatpay", "alipay|支付宝||alipay", "usdt|||usdt", "qqpay||"},
+			want: []PurchaseMethod{
+				{Type: "alipay", Name: "支付宝", Provider: "alipay"},
+				{Type: "qqpay", Name: "QQ 钱包"},
+			},
+		},
+		{
+			name:    "渠道未启用时使用后续的同类型支付方式",
+			entries: []string{"wxpay|微信支付||wechatpay", "wxpay|微信支付"},
+			want:    []PurchaseMethod{{Type: "wxpay", Name: "微信支付"}},
+		},
+		{
+			name:    "全部渠道均未启用",
+			entries: []string{"wxpay|||wechatpay", "usdt|||usdt"},
+		},
+	}
+	for _, c := range cases {
+		conf.EpayPurchaseMethods = c.entries
+		if got := purchaseMethods(conf, registry); !reflect.DeepEqual(got, c.want) {
+			t.Fatalf("%s: 解析结果为 %+v，应为 %+v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestFindPurchaseMethod(t *testing.T) {
+	methods := []PurchaseMethod{{Type: "alipay", Name: "支付宝"}, {Type: "wxpay", Name: "微信支付", Provider: "wechatpay"}}
+
+	cases := []struct {
+		purchaseType string
+		ok           bool
+	}{
+		{"alipay", true},
+		{"wxpay", true},
+		{"qqpay", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		method, ok := findPurchaseMethod(methods, c.purchaseType)
+		if ok != c.ok || ok && method.Type != c.purchaseType {
+			t.Fatalf("%q: 返回 %+v, %v", c.purchaseType, method, ok)
+		}
+	}
+}
@@ -1,21 +1,41 @@
 package controller
 
 import (
-	"encoding/gob"
+	"encoding/base64"
+	"errors"
+	"html/template"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/shopspring/decimal"
 	"github.com/sirupsen/logrus"
-	"github.com/topjohncian/cloudreve-pro-epay/internal/epay"
+	"github.com/skip2/go-qrcode"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/cache"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/provider"
 )
 
 const (
-	paymentTTL            = 3600 * 24 // 24h
-	PurchaseSessionPrefix = "purchase_session_"
+	paymentTTL = 24 * time.Hour
+
+	// checkoutPrefix 已向支付网关发起的支付，订单等待支付期间重新打开支付页面时复用
+	checkoutPrefix = "order_checkout_"
 )
 
+// savedCheckout 订单已发起的支付及发起时的支付方式与设备
+type savedCheckout struct {
+	Provider string
+	Method   string
+	Device   provider.Device
+	Checkout *provider.Checkout
+}
+
+func init() {
+	cache.RegisterType("controller.savedCheckout", &savedCheckout{})
+}
+
 type PurchaseRequest struct {
 	Name      string `json:"name" binding:"required"`
 	OrderNo   string `json:"order_no" binding:"required"`
@@ -29,10 +49,6 @@ type PurchaseResponse struct {
 	Data string `json:"data"`
 }
 
-func init() {
-	gob.Register(&PurchaseRequest{})
-}
-
 func (pc *CloudrevePayController) Purchase(c *gin.Context) {
 	var req PurchaseRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -44,7 +60,44 @@ func (pc *CloudrevePayController) Purchase(c *gin.Context) {
 		return
 	}
 
-	if err := pc.Cache.Set(PurchaseSessionPrefix+req.OrderNo, req, paymentTTL); err != nil {
+	if err := pc.NotifyPolicy.CheckURL(req.NotifyUrl); err != nil {
+		logrus.WithError(err).WithField("notify_url", req.NotifyUrl).Warningln("不允许的通知地址")
+		c.JSON(http.StatusOK, PurchaseResponse{
+			Code: 400,
+			Data: "",
+		})
+		return
+	}
+
+	conversion, err := pc.Currency.Convert(c.Request.Context(), req.Amount, req.Currency)
+	if err != nil {
+		logrus.WithError(err).WithField("currency", req.Currency).Warningln("无法换算订单金额")
+		c.JSON(http.StatusOK, PurchaseResponse{
+			Code: 400,
+			Data: "",
+		})
+		return
+	}
+
+	newOrder := order.New(req.OrderNo, req.Name, req.NotifyUrl, conversion.Amount, conversion.Currency, paymentTTL)
+	newOrder.OriginalAmount = conversion.OriginalAmount
+	newOrder.OriginalCurrency = conversion.OriginalCurrency
+	newOrder.ExchangeRate = conversion.Rate.String()
+
+	// 同一订单号只创建一次：相同的重复请求返回相同的支付地址，内容不同的请求不会覆盖已有订单
+	existing, err := pc.Orders.Create(newOrder)
+	switch {
+	case errors.Is(err, order.ErrExists):
+		if !existing.SameRequest(newOrder) {
+			logrus.WithField("order_no", req.OrderNo).WithField("status", existing.Status).Warningln("订单号已存在且内容不一致，拒绝覆盖")
+			c.JSON(http.StatusOK, PurchaseResponse{
+				Code: 409,
+				Data: "",
+			})
+			return
+		}
+		logrus.WithField("order_no", req.OrderNo).WithField("status", existing.Status).Debugln("重复的创建订单请求，返回已有订单")
+	case err != nil:
 		logrus.WithError(err).Warningln("无法保存订单信息")
 		c.JSON(http.StatusOK, PurchaseResponse{
 			Code: 500,
@@ -81,28 +134,105 @@ func (pc *CloudrevePayController) PurchasePage(c *gin.Context) {
 		return
 	}
 
-	req, ok := pc.Cache.Get(PurchaseSessionPrefix + orderId)
-	if !ok {
-		logrus.WithField("id", orderId).Debugln("订单信息不存在")
+	// 仅启用一种支付方式时跳过选择页面
+	methods := purchaseMethods(pc.Conf, pc.Providers)
+	selected := c.Query("type")
+	if selected == "" && len(methods) == 1 {
+		selected = methods[0].Type
+	}
+	method, chosen := findPurchaseMethod(methods, selected)
+	if selected != "" && !chosen {
+		logrus.WithField("id", orderId).WithField("type", selected).Debugln("不支持的支付方式")
 		c.HTML(http.StatusOK, "error.tmpl", gin.H{
-			"message": "订单信息不存在",
+			"message": "不支持的支付方式",
 		})
 		return
 	}
 
-	order, ok := req.(*PurchaseRequest)
-	if !ok {
-		logrus.WithField("id", orderId).Debugln("订单信息非法")
+	device := provider.DetectDevice(c.Request.UserAgent())
+	if chosen && !device.Supports(method.Type) {
+		logrus.WithField("id", orderId).WithField("device", device).WithField("type", method.Type).Debugln("当前应用内浏览器无法使用该支付方式")
+
+		baseURL, _ := url.Parse(pc.Conf.Base)
+		pageURL := baseURL.ResolveReference(&url.URL{
+			Path:     "/purchase/" + orderId,
+			RawQuery: url.Values{"type": {method.Type}}.Encode(),
+		})
+		c.HTML(http.StatusOK, "browser.tmpl", gin.H{
+			"Method": method.Name,
+			"URL":    pageURL.String(),
+		})
+		return
+	}
+
+	orderInfo, err := pc.Orders.Update(orderId, func(o *order.Order) error {
+		if o.IsExpired(time.Now()) {
+			return o.Transition(order.StatusExpired, "支付超时")
+		}
+		if !chosen || !o.IsOpen() {
+			return nil
+		}
+
+		o.PaymentType = method.Type
+		if o.Status == order.StatusCreated {
+			return o.Transition(order.StatusPending, "跳转至支付网关")
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, order.ErrNotFound) {
+			logrus.WithField("id", orderId).Debugln("订单信息不存在")
+			c.HTML(http.StatusOK, "error.tmpl", gin.H{
+				"message": "订单信息不存在",
+			})
+			return
+		}
+
+		logrus.WithField("id", orderId).WithError(err).Warningln("无法读取订单信息")
 		c.HTML(http.StatusOK, "error.tmpl", gin.H{
 			"message": "订单信息非法",
 		})
 		return
 	}
 
+	if !orderInfo.IsOpen() {
+		logrus.WithField("id", orderId).WithField("status", orderInfo.Status).Debugln("订单不在待支付状态")
+		message := "订单已支付"
+		if !orderInfo.IsPaid() {
+			message = "订单已过期或已失败"
+		}
+		c.HTML(http.StatusOK, "error.tmpl", gin.H{
+			"message": message,
+		})
+		return
+	}
+
+	name := orderInfo.Name
+	if pc.Conf.CustomName != "" {
+		name = pc.Conf.CustomName
+	}
+
+	if !chosen {
+		c.HTML(http.StatusOK, "method.tmpl", gin.H{
+			"Name":    name,
+			"Money":   formatMoney(orderInfo.Amount, orderInfo.Currency),
+			"Methods": methods,
+		})
+		return
+	}
+
+	p, err := pc.Providers.Get(method.Provider)
+	if err != nil {
+		logrus.WithField("id", orderId).WithError(err).Warningln("支付方式配置的支付渠道不存在")
+		c.HTML(http.StatusOK, "error.tmpl", gin.H{
+			"message": "不支持的支付方式",
+		})
+		return
+	}
+
 	baseURL, _ := url.Parse(pc.Conf.Base)
-	// 修改为 Cloudreve V4 格式的回调地址
-	purchaseURL, _ := url.Parse("/api/v4/callback/custom/" + order.OrderNo)
-	returnURL, err := url.Parse("/return/" + order.OrderNo)
+	notifyURL, _ := url.Parse("/payment/" + p.Name() + "/notify/" + orderInfo.OrderNo)
+	returnURL, err := url.Parse("/return/" + orderInfo.OrderNo)
 
 	if err != nil {
 		logrus.WithError(err).Warningln("无法解析 URL")
@@ -113,32 +243,162 @@ func (pc *CloudrevePayController) PurchasePage(c *gin.Context) {
 		return
 	}
 
-	amount := decimal.NewFromInt(int64(order.Amount)).Div(decimal.NewFromInt(100)).StringFixedBank(2)
+	paymentReq := &provider.PaymentRequest{
+		OrderNo:   orderInfo.OrderNo,
+		Name:      name,
+		Amount:    orderInfo.Amount,
+		Currency:  orderInfo.Currency,
+		Method:    method.Type,
+		Device:    device,
+		ClientIP:  c.ClientIP(),
+		NotifyURL: baseURL.ResolveReference(notifyURL),
+		ReturnURL: baseURL.ResolveReference(returnURL),
+		ExpiresAt: orderInfo.ExpiresAt,
+	}
 
-	args := &epay.PurchaseArgs{
-		Type:           epay.PurchaseType(pc.Conf.EpayPurchaseType),
-		ServiceTradeNo: order.OrderNo,
-		Name:           order.Name,
-		Money:          amount,
-		Device:         epay.PC,
-		NotifyUrl:      baseURL.ResolveReference(purchaseURL),
-		ReturnUrl:      baseURL.ResolveReference(returnURL),
+	// 刷新页面时复用已发起的支付，避免以相同的订单号重复请求支付网关
+	if checkout := pc.savedCheckout(orderInfo, p.Name(), method.Type, device); checkout != nil {
+		logrus.WithField("id", orderId).Debugln("复用已发起的支付")
+		renderCheckout(c, checkout, paymentReq)
+		return
 	}
 
-	if pc.Conf.CustomName != "" {
-		args.Name = pc.Conf.CustomName
+	checkout, err := p.CreatePayment(c.Request.Context(), paymentReq)
+	if err != nil {
+		logrus.WithField("id", orderId).WithError(err).Warningln("无法发起支付")
+		c.HTML(http.StatusOK, "error.tmpl", gin.H{
+			"message": "支付网关暂时不可用，请稍后重试",
+		})
+		return
 	}
+	pc.saveCheckout(orderInfo, p.Name(), method.Type, device, checkout)
 
-	client := epay.NewClient(&epay.Config{
-		PartnerID: pc.Conf.EpayPartnerID,
-		Key:       pc.Conf.EpayKey,
-		Endpoint:  pc.Conf.EpayEndpoint,
-	})
+	if orderInfo.Provider != p.Name() || orderInfo.Merchant != checkout.Merchant || checkout.TradeNo != "" && orderInfo.TradeNo == "" {
+		if _, err := pc.Orders.Update(orderId, func(o *order.Order) error {
+			if o.Provider != p.Name() || o.Merchant != checkout.Merchant {
+				o.Provider = p.Name()
+				o.Merchant = checkout.Merchant
+				o.TradeNo = ""
+			}
+			if checkout.TradeNo != "" {
+				o.TradeNo = checkout.TradeNo
+			}
+			return nil
+		}); err != nil {
+			logrus.WithField("id", orderId).WithError(err).Warningln("无法保存易支付订单号")
+		}
+	}
 
-	endpoint, purchaseParams := client.Purchase(args)
+	renderCheckout(c, checkout, paymentReq)
+}
 
-	c.HTML(http.StatusOK, "purchase.tmpl", gin.H{
-		"Endpoint": endpoint,
-		"Params":   purchaseParams,
-	})
+// savedCheckout 返回订单以相同支付渠道、支付方式与设备发起过的支付，
+// 订单的支付渠道或商户已变更时不复用
+func (pc *CloudrevePayController) savedCheckout(orderInfo *order.Order, providerName, method string, device provider.Device) *provider.Checkout {
+	value, ok := pc.Cache.Get(checkoutPrefix + orderInfo.OrderNo)
+	if !ok {
+		return nil
+	}
+
+	saved, ok := value.(*savedCheckout)
+	if !ok || saved.Checkout == nil || saved.Provider != providerName || saved.Method != method || saved.Device != device {
+		return nil
+	}
+	if orderInfo.Provider != providerName || orderInfo.Merchant != saved.Checkout.Merchant {
+		return nil
+	}
+	return saved.Checkout
+}
+
+// saveCheckout 保存发起的支付，订单过期后失效
+func (pc *CloudrevePayController) saveCheckout(orderInfo *order.Order, providerName, method string, device provider.Device, checkout *provider.Checkout) {
+	ttl := int(time.Until(orderInfo.ExpiresAt).Seconds())
+	if ttl <= 0 {
+		return
+	}
+
+	err := pc.Cache.Set(checkoutPrefix+orderInfo.OrderNo, &savedCheckout{
+		Provider: providerName,
+		Method:   method,
+		Device:   device,
+		Checkout: checkout,
+	}, ttl)
+	if err != nil {
+		logrus.WithField("id", orderInfo.OrderNo).WithError(err).Warningln("无法保存已发起的支付")
+	}
+}
+
+// currencySymbols 常见货币的符号，其他货币在金额后显示货币代码
+var currencySymbols = map[string]string{
+	"CNY": "￥",
+	"USD": "$",
+	"EUR": "€",
+	"GBP": "£",
+	"HKD": "HK$",
+}
+
+// formatMoney 按货币格式化以分为单位的金额，currency 为空时视为人民币
+func formatMoney(amount int, currency string) string {
+	money := decimal.New(int64(amount), -2).StringFixed(2)
+	if currency == "" {
+		currency = "CNY"
+	}
+	if symbol, ok := currencySymbols[currency]; ok {
+		return symbol + money
+	}
+	return money + " " + currency
+}
+
+// renderCheckout 根据支付网关返回的结果展示收款信息、提交表单、展示二维码或跳转
+func renderCheckout(c *gin.Context, checkout *provider.Checkout, req *provider.PaymentRequest) {
+	switch {
+	case checkout.Transfer != nil:
+		png, err := qrcode.Encode(checkout.Transfer.Address, qrcode.Medium, 256)
+		if err != nil {
+			logrus.WithError(err).Warningln("无法生成收款地址二维码")
+			c.HTML(http.StatusOK, "error.tmpl", gin.H{
+				"message": "无法生成收款地址二维码",
+			})
+			return
+		}
+
+		c.HTML(http.StatusOK, "transfer.tmpl", gin.H{
+			"QRCode":    template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)),
+			"Name":      req.Name,
+			"Network":   checkout.Transfer.Network,
+			"Address":   checkout.Transfer.Address,
+			"Amount":    checkout.Transfer.Amount,
+			"Currency":  checkout.Transfer.Currency,
+			"ExpiresAt": checkout.Transfer.ExpiresAt.Format("2006-01-02 15:04:05"),
+			"ReturnURL": req.ReturnURL.String(),
+		})
+	case checkout.IsForm():
+		c.HTML(http.StatusOK, "purchase.tmpl", gin.H{
+			"Endpoint": checkout.Endpoint,
+			"Params":   checkout.Params,
+		})
+	case checkout.URLScheme != "" && (req.Device != provider.DevicePC || checkout.PayURL == "" && checkout.QRCode == ""):
+		c.Redirect(http.StatusFound, checkout.URLScheme)
+	// 移动设备上优先跳转支付链接，无法跳转时才展示二维码
+	case checkout.QRCode != "" && (req.Device == provider.DevicePC || checkout.PayURL == ""):
+		png, err := qrcode.Encode(checkout.QRCode, qrcode.Medium, 256)
+		if err != nil {
+			logrus.WithError(err).Warningln("无法生成支付二维码")
+			c.HTML(http.StatusOK, "error.tmpl", gin.H{
+				"message": "无法生成支付二维码",
+			})
+			return
+		}
+
+		c.HTML(http.StatusOK, "qrcode.tmpl", gin.H{
+			"QRCode":    template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)),
+			"PayURL":    checkout.PayURL,
+			"Name":      req.Name,
+			"Money":     formatMoney(req.Amount, req.Currency),
+			"Type":      req.Method,
+			"ReturnURL": req.ReturnURL.String(),
+		})
+	default:
+		c.Redirect(http.StatusFound, checkout.PayURL)
+	}
 }
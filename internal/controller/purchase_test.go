@@ -0,0 +1,23 @@
+package controller
+
+import "testing"
+
+func TestFormatMoney(t *testing.T) {
+	cases := []struct {
+		amount   int
+		currency string
+		want     string
+	}{
+		{100, "CNY", "￥1.00"},
+		{100, "", "￥1.00"},
+		{999, "USD", "$9.99"},
+		{1, "EUR", "€0.01"},
+		{1234, "USDT", "12.34 USDT"},
+		{1234, "JPY", "12.34 JPY"},
+	}
+	for _, c := range cases {
+		if got := formatMoney(c.amount, c.currency); got != c.want {
+			t.Fatalf("%d %s 格式化为 %s，应为 %s", c.amount, c.currency, got, c.want)
+		}
+	}
+}
@@ -1,11 +1,14 @@
 package controller
 
 import (
+	"context"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
-	"github.com/topjohncian/cloudreve-pro-epay/internal/cache"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/payment"
 )
 
 type QueryOrderStatusResponse struct {
@@ -16,10 +19,30 @@ type QueryOrderStatusResponse struct {
 
 // 订单状态常量
 const (
-	OrderStatusPaid   = "PAID"    // 已支付
-	OrderStatusUnpaid = "UNPAID"  // 未支付
+	OrderStatusPaid   = "PAID"   // 已支付
+	OrderStatusUnpaid = "UNPAID" // 未支付
 )
 
+// reconcileThrottlePrefix 查询订单状态时主动查询支付网关的限流标记
+const reconcileThrottlePrefix = "order_reconcile_throttle_"
+
+// shouldReconcile 判断查询订单状态时是否需要主动查询支付网关。订单进入等待支付状态
+// 超过 ReconcileMinAge 后才查询，同一订单在 ReconcileQueryInterval 内最多查询一次，
+// 避免前端轮询时每次都同步请求支付网关
+func (pc *CloudrevePayController) shouldReconcile(orderInfo *order.Order) bool {
+	interval := pc.Conf.ReconcileQueryInterval
+	if interval <= 0 || time.Since(orderInfo.UpdatedAt) < pc.Conf.ReconcileMinAge {
+		return false
+	}
+
+	ok, err := pc.Cache.SetNX(reconcileThrottlePrefix+orderInfo.OrderNo, true, max(1, int(interval.Seconds())))
+	if err != nil {
+		logrus.WithField("order_no", orderInfo.OrderNo).WithError(err).Debugln("无法写入主动查询限流标记")
+		return false
+	}
+	return ok
+}
+
 // QueryOrderStatus handles the GET request to check the payment status of an order
 // This implements the specification from custom.md
 func (pc *CloudrevePayController) QueryOrderStatus(c *gin.Context) {
@@ -33,39 +56,42 @@ func (pc *CloudrevePayController) QueryOrderStatus(c *gin.Context) {
 		return
 	}
 
-	// Check if the order is marked as paid first
-	if cache.IsOrderPaid(pc.Cache, orderNo) {
+	orderInfo, err := pc.Orders.Get(orderNo)
+	if err != nil {
+		// 订单不存在，可能从未创建
+		logrus.WithField("order_no", orderNo).WithError(err).Debugln("订单信息不存在")
 		c.JSON(http.StatusOK, QueryOrderStatusResponse{
 			Code: 0,
-			Data: OrderStatusPaid,
+			Data: OrderStatusUnpaid,
 		})
 		return
 	}
 
-	// Try to get the order from cache
-	req, ok := pc.Cache.Get(PurchaseSessionPrefix + orderNo)
-	if !ok {
-		// If we can't find it in the cache and it's not marked as paid,
-		// it's either expired or never existed
-		logrus.WithField("order_no", orderNo).Debugln("订单信息不存在")
-		c.JSON(http.StatusOK, QueryOrderStatusResponse{
-			Code:  0,
-			Data:  OrderStatusUnpaid,
-		})
-		return
+	// 等待支付的订单可能丢失了异步通知，主动向易支付查询
+	if orderInfo.Status == order.StatusPending && pc.shouldReconcile(orderInfo) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		outcome, err := pc.Payments.Reconcile(ctx, orderNo)
+		if err != nil {
+			logrus.WithField("order_no", orderNo).WithError(err).Debugln("主动查询订单失败")
+		} else if outcome != payment.OutcomeIgnored {
+			c.JSON(http.StatusOK, QueryOrderStatusResponse{
+				Code: 0,
+				Data: OrderStatusPaid,
+			})
+			return
+		}
 	}
 
-	_, ok2 := req.(*PurchaseRequest)
-	if !ok2 {
-		logrus.WithField("order_no", orderNo).Debugln("订单信息非法")
+	if orderInfo.IsPaid() {
 		c.JSON(http.StatusOK, QueryOrderStatusResponse{
-			Code:  500,
-			Error: "Invalid order information",
+			Code: 0,
+			Data: OrderStatusPaid,
 		})
 		return
 	}
 
-	// 如果订单存在于缓存中，但没有被标记为已支付，则返回未支付状态
 	c.JSON(http.StatusOK, QueryOrderStatusResponse{
 		Code: 0,
 		Data: OrderStatusUnpaid,
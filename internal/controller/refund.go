@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/payment"
+)
+
+type RefundRequest struct {
+	OrderNo string `json:"order_no" binding:"required"`
+	// 退款金额，单位为分，为 0 时全额退款
+	Amount int    `json:"amount" binding:"min=0"`
+	Reason string `json:"reason"`
+}
+
+type RefundResponse struct {
+	Code  int    `json:"code"`
+	Data  string `json:"data,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Refund 退款，返回退款后的订单状态
+func (pc *CloudrevePayController) Refund(c *gin.Context) {
+	var req RefundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		logrus.WithError(err).Debugln("无法解析请求")
+		c.JSON(http.StatusOK, RefundResponse{
+			Code:  400,
+			Error: "无法解析请求",
+		})
+		return
+	}
+
+	orderInfo, err := pc.Payments.Refund(c.Request.Context(), req.OrderNo, req.Amount, req.Reason)
+	if err != nil {
+		code := 500
+		switch {
+		case errors.Is(err, order.ErrNotFound):
+			code = 404
+		case errors.Is(err, order.ErrRefundNotAllowed), errors.Is(err, order.ErrRefundAmount), errors.Is(err, payment.ErrTradeNoMissing):
+			code = 400
+		case errors.Is(err, payment.ErrRefundInProgress):
+			code = 409
+		}
+
+		c.JSON(http.StatusOK, RefundResponse{
+			Code:  code,
+			Error: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, RefundResponse{
+		Code: 0,
+		Data: string(orderInfo.Status),
+	})
+}
@@ -0,0 +1,124 @@
+package currency
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+	"go.uber.org/fx"
+)
+
+func Module() fx.Option {
+	return fx.Module("currency",
+		fx.Provide(NewProvider),
+		fx.Provide(NewConverter),
+	)
+}
+
+// Rounding 换算后金额取整到分的规则
+type Rounding string
+
+const (
+	// RoundHalfUp 四舍五入
+	RoundHalfUp Rounding = "half_up"
+	// RoundHalfEven 银行家舍入
+	RoundHalfEven Rounding = "half_even"
+	// RoundUp 向上取整，商家不会少收
+	RoundUp Rounding = "up"
+	// RoundDown 向下取整，用户不会多付
+	RoundDown Rounding = "down"
+)
+
+// round 将以分为单位的金额按规则取整
+func (r Rounding) round(cents decimal.Decimal) (decimal.Decimal, error) {
+	switch r {
+	case RoundHalfUp:
+		return cents.Round(0), nil
+	case RoundHalfEven:
+		return cents.RoundBank(0), nil
+	case RoundUp:
+		return cents.Ceil(), nil
+	case RoundDown:
+		return cents.Floor(), nil
+	default:
+		return decimal.Zero, fmt.Errorf("未知的取整规则 %q", string(r))
+	}
+}
+
+// NewProvider 配置了汇率文件时从文件读取汇率，否则使用配置中的汇率表
+func NewProvider(conf *appconf.Config) (Provider, error) {
+	if conf.CurrencyRatesFile != "" {
+		return &FileProvider{Path: conf.CurrencyRatesFile}, nil
+	}
+	return NewTableProvider(conf.CurrencyRates)
+}
+
+// Conversion 一次金额换算的结果，金额单位均为分
+type Conversion struct {
+	OriginalAmount   int
+	OriginalCurrency string
+	Amount           int
+	Currency         string
+	Rate             decimal.Decimal
+}
+
+// Converter 将 Cloudreve 订单金额换算为支付网关货币
+type Converter struct {
+	// 支付网关使用的货币
+	Currency string
+	Rounding Rounding
+	Provider Provider
+}
+
+func NewConverter(conf *appconf.Config, provider Provider) (*Converter, error) {
+	converter := &Converter{
+		Currency: strings.ToUpper(conf.Currency),
+		Rounding: Rounding(conf.CurrencyRounding),
+		Provider: provider,
+	}
+	if _, err := converter.Rounding.round(decimal.Zero); err != nil {
+		return nil, err
+	}
+	return converter, nil
+}
+
+// Convert 将以分为单位的金额换算为支付网关货币，currency 为空或与网关货币相同时不换算。
+// 换算结果按 Rounding 取整到分，且不少于 1 分。
+// 与 Cloudreve 的金额一致，所有货币均以 1/100 为最小单位，不区分货币的小数位数，
+// JPY、KRW 等没有小数位的货币的金额同样按分换算
+func (c *Converter) Convert(ctx context.Context, amount int, currency string) (*Conversion, error) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == "" || currency == c.Currency {
+		return &Conversion{
+			OriginalAmount:   amount,
+			OriginalCurrency: c.Currency,
+			Amount:           amount,
+			Currency:         c.Currency,
+			Rate:             decimal.NewFromInt(1),
+		}, nil
+	}
+
+	rate, err := c.Provider.Rate(ctx, currency, c.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	cents, err := c.Rounding.round(decimal.NewFromInt(int64(amount)).Mul(rate))
+	if err != nil {
+		return nil, err
+	}
+	converted := int(cents.IntPart())
+	if converted < 1 {
+		converted = 1
+	}
+
+	return &Conversion{
+		OriginalAmount:   amount,
+		OriginalCurrency: currency,
+		Amount:           converted,
+		Currency:         c.Currency,
+		Rate:             rate,
+	}, nil
+}
@@ -0,0 +1,108 @@
+package currency
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+)
+
+func TestConvert(t *testing.T) {
+	provider, err := NewTableProvider(map[string]string{
+		"USD": "7.125",
+		"EUR": "7.135",
+		"GBP": "9.1234",
+		"JPY": "0.048",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		rounding Rounding
+		amount   int
+		currency string
+		want     int
+	}{
+		// 712.5 分
+		{"四舍五入进位", RoundHalfUp, 100, "USD", 713},
+		{"银行家舍入到偶数", RoundHalfEven, 100, "USD", 712},
+		{"向上取整", RoundUp, 100, "USD", 713},
+		{"向下取整", RoundDown, 100, "USD", 712},
+		// 713.5 分
+		{"银行家舍入进位到偶数", RoundHalfEven, 100, "EUR", 714},
+		// 912.34 分
+		{"四舍五入舍去", RoundHalfUp, 100, "GBP", 912},
+		{"银行家舍入舍去", RoundHalfEven, 100, "GBP", 912},
+		{"向上取整不足 1 分", RoundUp, 100, "GBP", 913},
+		{"向下取整不足 1 分", RoundDown, 100, "GBP", 912},
+		// 0.048 分，不足 1 分时按 1 分收取
+		{"四舍五入后为 0", RoundHalfUp, 1, "JPY", 1},
+		{"向下取整后为 0", RoundDown, 1, "JPY", 1},
+		{"向上取整为 1 分", RoundUp, 1, "JPY", 1},
+		{"货币代码不区分大小写", RoundHalfUp, 100, " usd ", 713},
+	}
+	for _, c := range cases {
+		converter := &Converter{Currency: "CNY", Rounding: c.rounding, Provider: provider}
+		got, err := converter.Convert(context.Background(), c.amount, c.currency)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got.Amount != c.want || got.Currency != "CNY" || got.OriginalAmount != c.amount {
+			t.Fatalf("%s: 换算结果为 %+v，金额应为 %d", c.name, got, c.want)
+		}
+		if rate, _ := provider.Rate(context.Background(), got.OriginalCurrency, "CNY"); !got.Rate.Equal(rate) {
+			t.Fatalf("%s: 记录的汇率为 %s，应为 %s", c.name, got.Rate, rate)
+		}
+	}
+}
+
+func TestConvertSameCurrency(t *testing.T) {
+	converter := &Converter{Currency: "CNY", Rounding: RoundHalfUp, Provider: TableProvider{}}
+
+	for _, currency := range []string{"", "CNY", "cny"} {
+		got, err := converter.Convert(context.Background(), 123, currency)
+		if err != nil {
+			t.Fatalf("%q: %v", currency, err)
+		}
+		if got.Amount != 123 || got.OriginalCurrency != "CNY" || got.Currency != "CNY" || !got.Rate.Equal(decimal.NewFromInt(1)) {
+			t.Fatalf("%q: 不应换算，结果为 %+v", currency, got)
+		}
+	}
+}
+
+func TestConvertUnknownCurrency(t *testing.T) {
+	converter := &Converter{Currency: "CNY", Rounding: RoundHalfUp, Provider: TableProvider{"USD": decimal.NewFromInt(7)}}
+
+	_, err := converter.Convert(context.Background(), 100, "EUR")
+	var notFound *ErrRateNotFound
+	if !errors.As(err, &notFound) || notFound.From != "EUR" || notFound.To != "CNY" {
+		t.Fatalf("未知货币返回 %v，应为 ErrRateNotFound", err)
+	}
+}
+
+func TestNewConverter(t *testing.T) {
+	cases := []struct {
+		rounding string
+		ok       bool
+	}{
+		{"half_up", true},
+		{"half_even", true},
+		{"up", true},
+		{"down", true},
+		{"", false},
+		{"ceil", false},
+	}
+	for _, c := range cases {
+		converter, err := NewConverter(&appconf.Config{Currency: "cny", CurrencyRounding: c.rounding}, TableProvider{})
+		if (err == nil) != c.ok {
+			t.Fatalf("取整规则 %q: 返回 %v", c.rounding, err)
+		}
+		if c.ok && converter.Currency != "CNY" {
+			t.Fatalf("取整规则 %q: 网关货币为 %s", c.rounding, converter.Currency)
+		}
+	}
+}
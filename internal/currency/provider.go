@@ -0,0 +1,104 @@
+package currency
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Provider 汇率来源，返回 1 单位 from 货币可兑换的 to 货币数量
+type Provider interface {
+	Rate(ctx context.Context, from, to string) (decimal.Decimal, error)
+}
+
+// ErrRateNotFound 没有该货币的汇率
+type ErrRateNotFound struct {
+	From, To string
+}
+
+func (e *ErrRateNotFound) Error() string {
+	return fmt.Sprintf("没有 %s 到 %s 的汇率", e.From, e.To)
+}
+
+// TableProvider 固定汇率表，键为货币代码，值为 1 单位该货币可兑换的支付网关货币数量
+type TableProvider map[string]decimal.Decimal
+
+// NewTableProvider 解析配置中的汇率表
+func NewTableProvider(rates map[string]string) (TableProvider, error) {
+	table := TableProvider{}
+	for code, value := range rates {
+		rate, err := decimal.NewFromString(strings.TrimSpace(value))
+		if err != nil || !rate.IsPositive() {
+			return nil, fmt.Errorf("货币 %s 的汇率 %q 无效", code, value)
+		}
+		table[strings.ToUpper(strings.TrimSpace(code))] = rate
+	}
+	return table, nil
+}
+
+func (t TableProvider) Rate(ctx context.Context, from, to string) (decimal.Decimal, error) {
+	rate, ok := t[from]
+	if !ok {
+		return decimal.Zero, &ErrRateNotFound{From: from, To: to}
+	}
+	return rate, nil
+}
+
+// FileProvider 从本地 JSON 文件读取汇率表，文件格式为 {"USD": "7.1", "EUR": 7.8}，
+// 文件修改后会自动重新读取，便于由定时任务更新汇率
+type FileProvider struct {
+	Path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	table   TableProvider
+}
+
+func (f *FileProvider) Rate(ctx context.Context, from, to string) (decimal.Decimal, error) {
+	table, err := f.load()
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return table.Rate(ctx, from, to)
+}
+
+func (f *FileProvider) load() (TableProvider, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return nil, fmt.Errorf("无法读取汇率文件: %w", err)
+	}
+	if f.table != nil && info.ModTime().Equal(f.modTime) {
+		return f.table, nil
+	}
+
+	content, err := os.ReadFile(f.Path)
+	if err != nil {
+		return nil, fmt.Errorf("无法读取汇率文件: %w", err)
+	}
+
+	var rates map[string]decimal.Decimal
+	if err := json.Unmarshal(content, &rates); err != nil {
+		return nil, fmt.Errorf("无法解析汇率文件: %w", err)
+	}
+
+	table := TableProvider{}
+	for code, rate := range rates {
+		if !rate.IsPositive() {
+			return nil, fmt.Errorf("汇率文件中货币 %s 的汇率无效", code)
+		}
+		table[strings.ToUpper(code)] = rate
+	}
+
+	f.table = table
+	f.modTime = info.ModTime()
+	return table, nil
+}
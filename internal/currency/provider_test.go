@@ -0,0 +1,96 @@
+package currency
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestNewTableProvider(t *testing.T) {
+	table, err := NewTableProvider(map[string]string{" usd ": " 7.1 ", "EUR": "7.8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate, err := table.Rate(context.Background(), "USD", "CNY"); err != nil || !rate.Equal(decimal.RequireFromString("7.1")) {
+		t.Fatalf("USD 的汇率为 %s, %v", rate, err)
+	}
+
+	for _, value := range []string{"", "abc", "0", "-1"} {
+		if _, err := NewTableProvider(map[string]string{"USD": value}); err == nil {
+			t.Fatalf("汇率 %q 应返回错误", value)
+		}
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.json")
+	provider := &FileProvider{Path: path}
+	modTime := time.Now()
+
+	// write 写入汇率文件，并将修改时间后移以确保能被识别为已修改
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		modTime = modTime.Add(time.Second)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name    string
+		content string
+		from    string
+		rate    string
+		ok      bool
+	}{
+		{"文件不存在", "", "USD", "", false},
+		{"字符串与数字格式的汇率", `{"usd": "7.1", "EUR": 7.8}`, "USD", "7.1", true},
+		{"数字格式的汇率", `{"usd": "7.1", "EUR": 7.8}`, "EUR", "7.8", true},
+		{"修改后重新读取", `{"USD": "7.2"}`, "USD", "7.2", true},
+		{"修改后不存在的货币", `{"USD": "7.2"}`, "EUR", "", false},
+		{"无法解析的文件", `{"USD": `, "USD", "", false},
+		{"无效的汇率", `{"USD": "0"}`, "USD", "", false},
+		{"修复后重新读取", `{"USD": "7.3"}`, "USD", "7.3", true},
+	}
+	for i, c := range cases {
+		if i > 0 && c.content != cases[i-1].content {
+			write(c.content)
+		}
+
+		rate, err := provider.Rate(context.Background(), c.from, "CNY")
+		if (err == nil) != c.ok {
+			t.Fatalf("%s: 返回 %v", c.name, err)
+		}
+		if c.ok && !rate.Equal(decimal.RequireFromString(c.rate)) {
+			t.Fatalf("%s: 汇率为 %s，应为 %s", c.name, rate, c.rate)
+		}
+	}
+
+	// 文件未修改时使用已读取的汇率
+	if err := os.WriteFile(path, []byte(`{"USD": "9"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	if rate, err := provider.Rate(context.Background(), "USD", "CNY"); err != nil || !rate.Equal(decimal.RequireFromString("7.3")) {
+		t.Fatalf("文件未修改时汇率为 %s, %v，应为 7.3", rate, err)
+	}
+
+	// 文件被删除时返回错误
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	var notFound *ErrRateNotFound
+	if _, err := provider.Rate(context.Background(), "USD", "CNY"); err == nil || errors.As(err, &notFound) {
+		t.Fatalf("文件被删除时返回 %v", err)
+	}
+}
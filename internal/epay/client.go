@@ -1,9 +1,39 @@
 package epay
 
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/imroc/req/v3"
+)
+
+// ErrKeyRequired 未配置商户 key，无法调用以商户 key 鉴权的 api.php 接口
+var ErrKeyRequired = errors.New("未配置商户密钥，无法查询订单或退款")
+
+// Mode 发起支付的方式
+type Mode string
+
+var (
+	// ModeSubmit 生成表单，由用户浏览器提交至 submit.php
+	ModeSubmit Mode = "submit"
+	// ModeMapi 服务端调用 mapi.php，获取支付链接或二维码
+	ModeMapi Mode = "mapi"
+)
+
 type Config struct {
 	PartnerID string
 	Key       string
 	Endpoint  string
+
+	// 签名算法，为空时使用商户 key 进行 MD5 签名
+	Signer Signer
+
+	Mode Mode
+	// mapi.php 地址，为空时由 Endpoint 推导
+	MapiEndpoint string
+	// 服务端调用易支付接口使用的 HTTP 客户端
+	HTTPClient *req.Client
 }
 
 var _ Client = &EPayClient{}
@@ -11,9 +41,15 @@ var _ Client = &EPayClient{}
 // Client 易支付API
 type Client interface {
 	// Purchase 生成支付链接和参数
-	Purchase(args *PurchaseArgs) (string, map[string]string)
+	Purchase(args *PurchaseArgs) (string, map[string]string, error)
+	// Checkout 按配置的方式发起支付
+	Checkout(ctx context.Context, args *PurchaseArgs) (*Checkout, error)
 	// Verify 验证回调参数是否符合签名
 	Verify(params map[string]string) (*VerifyRes, error)
+	// Query 按商家订单号查询订单
+	Query(ctx context.Context, outTradeNo string) (*QueryRes, error)
+	// Refund 按易支付订单号退款
+	Refund(ctx context.Context, tradeNo string, money string) error
 }
 
 type EPayClient struct {
@@ -21,7 +57,28 @@ type EPayClient struct {
 }
 
 func NewClient(config *Config) *EPayClient {
+	if config.HTTPClient == nil {
+		config.HTTPClient = req.C()
+	}
+
 	return &EPayClient{
 		Config: config,
 	}
 }
+
+// signer 返回配置的签名算法
+func (c *EPayClient) signer() Signer {
+	if c.Config.Signer == nil {
+		return &MD5Signer{Key: c.Config.Key}
+	}
+	return c.Config.Signer
+}
+
+// apiURL 将 submit.php 地址替换为同目录下的其他接口地址
+func (c *EPayClient) apiURL(name string) string {
+	endpoint := c.Config.Endpoint
+	if i := strings.LastIndex(endpoint, "/"); i >= 0 {
+		return endpoint[:i+1] + name
+	}
+	return endpoint
+}
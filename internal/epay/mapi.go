@@ -0,0 +1,114 @@
+package epay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Checkout 发起支付的结果，表单提交方式返回 Endpoint 和 Params，
+// mapi 方式返回支付链接、二维码内容或 App 跳转链接中的至少一项
+type Checkout struct {
+	Endpoint string
+	Params   map[string]string
+
+	// 易支付订单号
+	TradeNo string
+	// 支付跳转链接
+	PayURL string
+	// 二维码内容
+	QRCode string
+	// App 跳转链接
+	URLScheme string
+}
+
+// IsForm 返回是否需要由浏览器提交表单
+func (c *Checkout) IsForm() bool {
+	return c.Endpoint != ""
+}
+
+// APIError 易支付 mapi.php / api.php 接口返回的错误
+type APIError struct {
+	Code string
+	Msg  string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("易支付接口返回错误 %s: %s", e.Code, e.Msg)
+}
+
+// NotFound 查询的订单不存在。易支付对多种错误都返回 -1，只能通过错误信息区分
+func (e *APIError) NotFound() bool {
+	return e.Code == "-1" && strings.Contains(e.Msg, "订单") && strings.Contains(e.Msg, "不存在")
+}
+
+type mapiResponse struct {
+	Code      json.Number `json:"code"`
+	Msg       string      `json:"msg"`
+	TradeNo   string      `json:"trade_no"`
+	PayURL    string      `json:"payurl"`
+	QRCode    string      `json:"qrcode"`
+	URLScheme string      `json:"urlscheme"`
+}
+
+// Checkout 按配置的方式发起支付
+func (c *EPayClient) Checkout(ctx context.Context, args *PurchaseArgs) (*Checkout, error) {
+	if c.Config.Mode != ModeMapi {
+		endpoint, params, err := c.Purchase(args)
+		if err != nil {
+			return nil, err
+		}
+		return &Checkout{
+			Endpoint: endpoint,
+			Params:   params,
+		}, nil
+	}
+
+	return c.Mapi(ctx, args)
+}
+
+// Mapi 调用 mapi.php 接口创建订单
+func (c *EPayClient) Mapi(ctx context.Context, args *PurchaseArgs) (*Checkout, error) {
+	params := c.purchaseParams(args)
+	params["clientip"] = args.ClientIP
+	params, err := SignParams(params, c.signer())
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := c.Config.MapiEndpoint
+	if endpoint == "" {
+		endpoint = c.apiURL("mapi.php")
+	}
+
+	resp, err := c.Config.HTTPClient.R().
+		SetContext(ctx).
+		SetFormData(params).
+		Post(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.IsSuccessState() {
+		return nil, fmt.Errorf("易支付网关返回 HTTP %d", resp.StatusCode)
+	}
+
+	var res mapiResponse
+	if err := json.Unmarshal(resp.Bytes(), &res); err != nil {
+		return nil, fmt.Errorf("无法解析易支付网关响应: %w", err)
+	}
+	if res.Code.String() != "1" {
+		return nil, &APIError{Code: res.Code.String(), Msg: res.Msg}
+	}
+	if res.PayURL == "" && res.QRCode == "" && res.URLScheme == "" {
+		return nil, errors.New("易支付网关未返回支付链接")
+	}
+
+	return &Checkout{
+		TradeNo:   res.TradeNo,
+		PayURL:    res.PayURL,
+		QRCode:    res.QRCode,
+		URLScheme: res.URLScheme,
+	}, nil
+}
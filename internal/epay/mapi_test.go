@@ -0,0 +1,203 @@
+package epay
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+const testKey = "test-key"
+
+// newMapiServer 返回以 body 响应 mapi.php 请求的易支付网关，并校验请求签名
+func newMapiServer(t *testing.T, status int, body string) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/mapi.php" || r.Method != http.MethodPost {
+			t.Errorf("请求了 %s %s", r.Method, r.URL.Path)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		params := map[string]string{}
+		for key := range r.PostForm {
+			params[key] = r.PostForm.Get(key)
+		}
+		if err := VerifySign(params, testKey); err != nil {
+			t.Errorf("mapi 请求签名无效: %v", err)
+		}
+		if params["clientip"] != "203.0.113.1" || params["out_trade_no"] != "o1" {
+			t.Errorf("mapi 请求参数为 %v", params)
+		}
+
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func mapiArgs() *PurchaseArgs {
+	notifyURL, _ := url.Parse("https://shop.example.com/notify/o1")
+	returnURL, _ := url.Parse("https://shop.example.com/return/o1")
+	return &PurchaseArgs{
+		Type:           "alipay",
+		ServiceTradeNo: "o1",
+		Name:           "测试商品",
+		Money:          "1.00",
+		Device:         PC,
+		ClientIP:       "203.0.113.1",
+		NotifyUrl:      notifyURL,
+		ReturnUrl:      returnURL,
+	}
+}
+
+func TestMapi(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+		body   string
+		want   *Checkout
+		code   string
+	}{
+		{
+			name:   "支付链接",
+			status: http.StatusOK,
+			body:   `{"code":1,"msg":"success","trade_no":"T1","payurl":"https://pay.example.com/T1"}`,
+			want:   &Checkout{TradeNo: "T1", PayURL: "https://pay.example.com/T1"},
+		},
+		{
+			name:   "二维码",
+			status: http.StatusOK,
+			body:   `{"code":"1","trade_no":"T2","qrcode":"weixin://wxpay/bizpayurl?pr=T2"}`,
+			want:   &Checkout{TradeNo: "T2", QRCode: "weixin://wxpay/bizpayurl?pr=T2"},
+		},
+		{
+			name:   "App 跳转链接",
+			status: http.StatusOK,
+			body:   `{"code":1,"trade_no":"T3","urlscheme":"alipays://platformapi/startapp?T3"}`,
+			want:   &Checkout{TradeNo: "T3", URLScheme: "alipays://platformapi/startapp?T3"},
+		},
+		{
+			name:   "网关返回错误",
+			status: http.StatusOK,
+			body:   `{"code":-1,"msg":"签名校验失败"}`,
+			code:   "-1",
+		},
+		{
+			name:   "没有支付链接",
+			status: http.StatusOK,
+			body:   `{"code":1,"trade_no":"T4"}`,
+		},
+		{
+			name:   "响应不是 JSON",
+			status: http.StatusOK,
+			body:   `<html>502 Bad Gateway</html>`,
+		},
+		{
+			name:   "HTTP 错误",
+			status: http.StatusBadGateway,
+			body:   `{"code":1,"payurl":"https://pay.example.com/T5"}`,
+		},
+	}
+
+	for _, c := range cases {
+		server := newMapiServer(t, c.status, c.body)
+		client := NewClient(&Config{
+			PartnerID: "1000",
+			Key:       testKey,
+			Endpoint:  server.URL + "/submit.php",
+			Mode:      ModeMapi,
+		})
+
+		checkout, err := client.Checkout(context.Background(), mapiArgs())
+		if c.want != nil {
+			if err != nil {
+				t.Fatalf("%s: %v", c.name, err)
+			}
+			if checkout.IsForm() || checkout.TradeNo != c.want.TradeNo || checkout.PayURL != c.want.PayURL ||
+				checkout.QRCode != c.want.QRCode || checkout.URLScheme != c.want.URLScheme {
+				t.Fatalf("%s: 返回 %+v，应为 %+v", c.name, checkout, c.want)
+			}
+			continue
+		}
+
+		if err == nil {
+			t.Fatalf("%s: 应返回错误，实际返回 %+v", c.name, checkout)
+		}
+		var apiErr *APIError
+		if isAPIErr := errors.As(err, &apiErr); isAPIErr != (c.code != "") || isAPIErr && apiErr.Code != c.code {
+			t.Fatalf("%s: 返回 %v", c.name, err)
+		}
+	}
+}
+
+func TestMapiEndpoint(t *testing.T) {
+	server := newMapiServer(t, http.StatusOK, `{"code":1,"payurl":"https://pay.example.com/T1"}`)
+	client := NewClient(&Config{
+		PartnerID:    "1000",
+		Key:          testKey,
+		Endpoint:     "https://unreachable.invalid/submit.php",
+		Mode:         ModeMapi,
+		MapiEndpoint: server.URL + "/mapi.php",
+	})
+
+	if _, err := client.Checkout(context.Background(), mapiArgs()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckoutSubmit(t *testing.T) {
+	client := NewClient(&Config{PartnerID: "1000", Key: testKey, Endpoint: "https://pay.example.com/submit.php"})
+
+	checkout, err := client.Checkout(context.Background(), mapiArgs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !checkout.IsForm() || checkout.Endpoint != "https://pay.example.com/submit.php" {
+		t.Fatalf("表单提交方式返回 %+v", checkout)
+	}
+	if err := VerifySign(checkout.Params, testKey); err != nil {
+		t.Fatalf("表单参数签名无效: %v", err)
+	}
+}
+
+func TestAPIKeyRequired(t *testing.T) {
+	merchant, platform := rsaKeys(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("未配置商户密钥时不应请求 %s", r.URL)
+	}))
+	t.Cleanup(server.Close)
+
+	// 仅配置 RSA 签名的商户没有商户 key，不能以空 key 调用 api.php
+	client := NewClient(&Config{
+		PartnerID: "1000",
+		Endpoint:  server.URL + "/submit.php",
+		Signer:    &RSASigner{PrivateKey: merchant, PlatformPublicKey: &platform.PublicKey},
+	})
+	if _, err := client.Query(context.Background(), "o1"); !errors.Is(err, ErrKeyRequired) {
+		t.Fatalf("查询返回 %v，应为 ErrKeyRequired", err)
+	}
+	if err := client.Refund(context.Background(), "T1", "1.00"); !errors.Is(err, ErrKeyRequired) {
+		t.Fatalf("退款返回 %v，应为 ErrKeyRequired", err)
+	}
+}
+
+func TestAPIErrorNotFound(t *testing.T) {
+	for _, c := range []struct {
+		err  *APIError
+		want bool
+	}{
+		{&APIError{Code: "-1", Msg: "订单号不存在"}, true},
+		{&APIError{Code: "-1", Msg: "订单不存在"}, true},
+		{&APIError{Code: "-3", Msg: "KEY校验失败"}, false},
+		{&APIError{Code: "-1", Msg: "商户不存在"}, false},
+	} {
+		if got := c.err.NotFound(); got != c.want {
+			t.Fatalf("%v: NotFound 返回 %v", c.err, got)
+		}
+	}
+}
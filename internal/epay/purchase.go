@@ -2,6 +2,8 @@ package epay
 
 import (
 	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
 )
@@ -11,6 +13,11 @@ type PurchaseType string
 var (
 	Alipay PurchaseType = "alipay"
 	Wxpay  PurchaseType = "wxpay"
+	QQPay  PurchaseType = "qqpay"
+	Bank   PurchaseType = "bank"
+	JDPay  PurchaseType = "jdpay"
+	PayPal PurchaseType = "paypal"
+	USDT   PurchaseType = "usdt"
 )
 
 type DeviceType string
@@ -18,6 +25,9 @@ type DeviceType string
 var (
 	PC     DeviceType = "pc"
 	MOBILE DeviceType = "mobile"
+	QQ     DeviceType = "qq"
+	WECHAT DeviceType = "wechat"
+	ALIPAY DeviceType = "alipay"
 )
 
 type PurchaseArgs struct {
@@ -30,7 +40,9 @@ type PurchaseArgs struct {
 	// 金额
 	Money string
 	// 设备类型
-	Device    DeviceType
+	Device DeviceType
+	// 用户 IP，仅 mapi 方式使用
+	ClientIP  string
 	NotifyUrl *url.URL
 	ReturnUrl *url.URL
 }
@@ -38,9 +50,18 @@ type PurchaseArgs struct {
 // const PURCHASE_API = "https://payment.moe/submit.php"
 
 // Purchase 生成支付链接和参数
-func (c *EPayClient) Purchase(args *PurchaseArgs) (string, map[string]string) {
+func (c *EPayClient) Purchase(args *PurchaseArgs) (string, map[string]string, error) {
+	params, err := SignParams(c.purchaseParams(args), c.signer())
+	if err != nil {
+		return "", nil, err
+	}
+	return c.Config.Endpoint, params, nil
+}
+
+// purchaseParams 生成未签名的支付参数
+func (c *EPayClient) purchaseParams(args *PurchaseArgs) map[string]string {
 	// see https://payment.moe/doc.html
-	requestParams := map[string]string{
+	params := map[string]string{
 		"pid":          c.Config.PartnerID,
 		"type":         string(args.Type),
 		"out_trade_no": args.ServiceTradeNo,
@@ -48,12 +69,17 @@ func (c *EPayClient) Purchase(args *PurchaseArgs) (string, map[string]string) {
 		"name":         args.Name,
 		"money":        args.Money,
 		"device":       string(args.Device),
-		"sign_type":    "MD5",
+		"sign_type":    string(SignTypeMD5),
 		"return_url":   args.ReturnUrl.String(),
 		"sign":         "",
 	}
 
-	return c.Config.Endpoint, GenerateParams(requestParams, c.Config.Key)
+	// 易支付 V2 接口要求携带时间戳
+	if c.signer().SignType() == SignTypeRSA {
+		params["timestamp"] = strconv.FormatInt(time.Now().Unix(), 10)
+	}
+
+	return params
 }
 
 const TRADE_SUCCESS = "TRADE_SUCCESS"
@@ -76,15 +102,17 @@ type VerifyRes struct {
 }
 
 func (c *EPayClient) Verify(params map[string]string) (*VerifyRes, error) {
-	sign := params["sign"]
 	var verifyRes VerifyRes
 	// 从 map 映射到 struct 上
-	err := mapstructure.Decode(params, &verifyRes)
-	// 验证签名
-	verifyRes.VerifyStatus = sign == GenerateParams(params, c.Config.Key)["sign"]
-	if err != nil {
+	if err := mapstructure.Decode(params, &verifyRes); err != nil {
 		return nil, err
-	} else {
-		return &verifyRes, nil
 	}
+
+	// 验证签名
+	if err := c.verifySign(params); err != nil {
+		return &verifyRes, err
+	}
+
+	verifyRes.VerifyStatus = true
+	return &verifyRes, nil
 }
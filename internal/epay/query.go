@@ -0,0 +1,70 @@
+package epay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// QueryRes 订单查询结果
+type QueryRes struct {
+	// 易支付订单号
+	TradeNo string
+	// 商家订单号
+	ServiceTradeNo string
+	// 支付类型
+	Type PurchaseType
+	// 金额
+	Money string
+	// 是否已支付
+	Paid bool
+}
+
+type queryResponse struct {
+	Code       json.Number `json:"code"`
+	Msg        string      `json:"msg"`
+	TradeNo    string      `json:"trade_no"`
+	OutTradeNo string      `json:"out_trade_no"`
+	Type       string      `json:"type"`
+	Money      string      `json:"money"`
+	Status     json.Number `json:"status"`
+}
+
+// Query 通过 api.php?act=order 按商家订单号查询订单，接口以商户 key 鉴权，未配置时返回 ErrKeyRequired
+func (c *EPayClient) Query(ctx context.Context, outTradeNo string) (*QueryRes, error) {
+	if c.Config.Key == "" {
+		return nil, ErrKeyRequired
+	}
+
+	resp, err := c.Config.HTTPClient.R().
+		SetContext(ctx).
+		SetQueryParams(map[string]string{
+			"act":          "order",
+			"pid":          c.Config.PartnerID,
+			"key":          c.Config.Key,
+			"out_trade_no": outTradeNo,
+		}).
+		Get(c.apiURL("api.php"))
+	if err != nil {
+		return nil, err
+	}
+	if !resp.IsSuccessState() {
+		return nil, fmt.Errorf("易支付网关返回 HTTP %d", resp.StatusCode)
+	}
+
+	var res queryResponse
+	if err := json.Unmarshal(resp.Bytes(), &res); err != nil {
+		return nil, fmt.Errorf("无法解析易支付网关响应: %w", err)
+	}
+	if res.Code.String() != "1" {
+		return nil, &APIError{Code: res.Code.String(), Msg: res.Msg}
+	}
+
+	return &QueryRes{
+		TradeNo:        res.TradeNo,
+		ServiceTradeNo: res.OutTradeNo,
+		Type:           PurchaseType(res.Type),
+		Money:          res.Money,
+		Paid:           res.Status.String() == "1",
+	}, nil
+}
@@ -0,0 +1,47 @@
+package epay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+type refundResponse struct {
+	Code json.Number `json:"code"`
+	Msg  string      `json:"msg"`
+}
+
+// Refund 通过 api.php?act=refund 按易支付订单号退款，money 为退款金额，单位为元。
+// 接口以商户 key 鉴权，未配置时返回 ErrKeyRequired
+func (c *EPayClient) Refund(ctx context.Context, tradeNo string, money string) error {
+	if c.Config.Key == "" {
+		return ErrKeyRequired
+	}
+
+	resp, err := c.Config.HTTPClient.R().
+		SetContext(ctx).
+		SetQueryParam("act", "refund").
+		SetFormData(map[string]string{
+			"pid":      c.Config.PartnerID,
+			"key":      c.Config.Key,
+			"trade_no": tradeNo,
+			"money":    money,
+		}).
+		Post(c.apiURL("api.php"))
+	if err != nil {
+		return err
+	}
+	if !resp.IsSuccessState() {
+		return fmt.Errorf("易支付网关返回 HTTP %d", resp.StatusCode)
+	}
+
+	var res refundResponse
+	if err := json.Unmarshal(resp.Bytes(), &res); err != nil {
+		return fmt.Errorf("无法解析易支付网关响应: %w", err)
+	}
+	if res.Code.String() != "1" {
+		return &APIError{Code: res.Code.String(), Msg: res.Msg}
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package epay
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var SignTypeRSA SignType = "RSA"
+
+var ErrSignMismatch = errors.New("签名不匹配")
+
+// Signer 易支付签名算法，content 为按参数名排序拼接的待签名字符串
+type Signer interface {
+	SignType() SignType
+	// Sign 对待签名字符串签名
+	Sign(content string) (string, error)
+	// Verify 校验易支付平台对待签名字符串的签名，不匹配时返回 ErrSignMismatch
+	Verify(content string, sign string) error
+}
+
+// MD5Signer 易支付 V1 加盐(商户 key) MD5 签名
+type MD5Signer struct {
+	Key string
+}
+
+func (s *MD5Signer) SignType() SignType {
+	return SignTypeMD5
+}
+
+func (s *MD5Signer) Sign(content string) (string, error) {
+	return MD5String(content, s.Key), nil
+}
+
+func (s *MD5Signer) Verify(content string, sign string) error {
+	expected := MD5String(content, s.Key)
+	if subtle.ConstantTimeCompare([]byte(strings.ToLower(sign)), []byte(expected)) != 1 {
+		return ErrSignMismatch
+	}
+	return nil
+}
+
+// RSASigner 易支付 V2 SHA256WithRSA 签名，使用商户私钥签名，易支付平台公钥验签
+type RSASigner struct {
+	PrivateKey        *rsa.PrivateKey
+	PlatformPublicKey *rsa.PublicKey
+}
+
+func (s *RSASigner) SignType() SignType {
+	return SignTypeRSA
+}
+
+func (s *RSASigner) Sign(content string) (string, error) {
+	digest := sha256.Sum256([]byte(content))
+	sign, err := rsa.SignPKCS1v15(rand.Reader, s.PrivateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sign), nil
+}
+
+func (s *RSASigner) Verify(content string, sign string) error {
+	raw, err := base64.StdEncoding.DecodeString(sign)
+	if err != nil {
+		return ErrSignMismatch
+	}
+
+	digest := sha256.Sum256([]byte(content))
+	if err := rsa.VerifyPKCS1v15(s.PlatformPublicKey, crypto.SHA256, digest[:], raw); err != nil {
+		return ErrSignMismatch
+	}
+	return nil
+}
+
+// NewSigner 根据签名类型创建签名器，RSA 类型的 privateKey 与 platformPublicKey 可以是
+// PEM 内容、PEM 文件路径或不含头尾的 base64 DER 内容
+func NewSigner(signType string, key string, privateKey string, platformPublicKey string) (Signer, error) {
+	switch SignType(strings.ToUpper(signType)) {
+	case "", SignTypeMD5:
+		if key == "" {
+			return nil, errors.New("商户密钥为空")
+		}
+		return &MD5Signer{Key: key}, nil
+	case SignTypeRSA:
+		priv, err := LoadPrivateKey(privateKey)
+		if err != nil {
+			return nil, fmt.Errorf("无法加载商户私钥: %w", err)
+		}
+		pub, err := LoadPublicKey(platformPublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("无法加载平台公钥: %w", err)
+		}
+		return &RSASigner{
+			PrivateKey:        priv,
+			PlatformPublicKey: pub,
+		}, nil
+	default:
+		return nil, fmt.Errorf("不支持的签名类型 %q", signType)
+	}
+}
+
+// LoadPrivateKey 加载 PKCS#1 或 PKCS#8 格式的 RSA 私钥
+func LoadPrivateKey(value string) (*rsa.PrivateKey, error) {
+	der, err := loadKeyDER(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("不是 RSA 私钥")
+	}
+	return rsaKey, nil
+}
+
+// LoadPublicKey 加载 PKIX 或 PKCS#1 格式的 RSA 公钥
+func LoadPublicKey(value string) (*rsa.PublicKey, error) {
+	der, err := loadKeyDER(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if key, err := x509.ParsePKCS1PublicKey(der); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("不是 RSA 公钥")
+	}
+	return rsaKey, nil
+}
+
+// loadKeyDER 从 PEM 内容、PEM 文件或 base64 内容中读取 DER 编码的密钥
+func loadKeyDER(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, errors.New("密钥为空")
+	}
+
+	if !strings.Contains(value, "-----BEGIN") {
+		if content, err := os.ReadFile(value); err == nil {
+			value = strings.TrimSpace(string(content))
+		}
+	}
+
+	if strings.Contains(value, "-----BEGIN") {
+		block, _ := pem.Decode([]byte(value))
+		if block == nil {
+			return nil, errors.New("无法解析 PEM 内容")
+		}
+		return block.Bytes, nil
+	}
+
+	return base64.StdEncoding.DecodeString(value)
+}
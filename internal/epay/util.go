@@ -47,12 +47,29 @@ func MD5String(urlString string, key string) string {
 // GenerateParams 生成加签参数
 func GenerateParams(params map[string]string, key string) map[string]string {
 	params["sign"] = GenerateSign(params, key)
-	params["sign_type"] = "MD5"
+	params["sign_type"] = string(SignTypeMD5)
 	return params
 }
 
 func GenerateSign(params map[string]string, key string) string {
+	return MD5String(SignContent(params), key)
+}
+
+// SignContent 生成参数的待签名字符串
+func SignContent(params map[string]string) string {
 	filtered := ParamsFilter(params)
 	keys, values := ParamsSort(filtered)
-	return MD5String(CreateUrlString(keys, values), key)
+	return CreateUrlString(keys, values)
+}
+
+// SignParams 使用 signer 生成加签参数
+func SignParams(params map[string]string, signer Signer) (map[string]string, error) {
+	sign, err := signer.Sign(SignContent(params))
+	if err != nil {
+		return nil, err
+	}
+
+	params["sign"] = sign
+	params["sign_type"] = string(signer.SignType())
+	return params, nil
 }
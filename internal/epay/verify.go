@@ -0,0 +1,105 @@
+package epay
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+)
+
+type SignType string
+
+var (
+	SignTypeMD5    SignType = "MD5"
+	SignTypeSHA256 SignType = "SHA256"
+)
+
+// 回调验签失败的原因代码，用于日志记录
+const (
+	RejectSignMissing         = "sign_missing"
+	RejectSignTypeUnsupported = "sign_type_unsupported"
+	RejectSignMismatch        = "sign_mismatch"
+)
+
+// VerifyError 回调验签失败
+type VerifyError struct {
+	Reason   string
+	SignType string
+}
+
+func (e *VerifyError) Error() string {
+	return "epay: 回调验签失败: " + e.Reason
+}
+
+// RejectReason 返回 err 对应的验签失败原因代码，非验签错误返回空字符串
+func RejectReason(err error) string {
+	if verifyErr, ok := err.(*VerifyError); ok {
+		return verifyErr.Reason
+	}
+	return ""
+}
+
+// signers 各签名类型对应的签名函数，参数为待签名字符串和商户 key
+var signers = map[SignType]func(urlString string, key string) string{
+	SignTypeMD5:    MD5String,
+	SignTypeSHA256: SHA256String,
+}
+
+// SHA256String 生成 加盐(商户 key) SHA256 字符串，部分易支付衍生版本使用
+func SHA256String(urlString string, key string) string {
+	digest := sha256.Sum256([]byte(urlString + key))
+	return fmt.Sprintf("%x", digest)
+}
+
+// paramsSignType 返回回调中的 sign_type，缺省时按 MD5 处理
+func paramsSignType(params map[string]string) SignType {
+	signType := SignType(strings.ToUpper(strings.TrimSpace(params["sign_type"])))
+	if signType == "" {
+		return SignTypeMD5
+	}
+	return signType
+}
+
+// VerifySign 按回调中的 sign_type 使用商户 key 校验 MD5 或 SHA256 签名
+func VerifySign(params map[string]string, key string) error {
+	signType := paramsSignType(params)
+
+	sign := strings.ToLower(params["sign"])
+	if sign == "" {
+		return &VerifyError{Reason: RejectSignMissing, SignType: string(signType)}
+	}
+
+	signer, ok := signers[signType]
+	if !ok {
+		return &VerifyError{Reason: RejectSignTypeUnsupported, SignType: string(signType)}
+	}
+
+	expected := signer(SignContent(params), key)
+	if subtle.ConstantTimeCompare([]byte(sign), []byte(expected)) != 1 {
+		return &VerifyError{Reason: RejectSignMismatch, SignType: string(signType)}
+	}
+
+	return nil
+}
+
+// verifySign 校验回调签名，sign_type 与配置的签名器一致时使用签名器校验。
+// 使用商户 key 签名的商户同样接受 SHA256 回调；配置了 RSA 签名的商户只接受 RSA 回调，
+// 即使同时配置了商户 key 也不降级为 MD5 或 SHA256 校验
+func (c *EPayClient) verifySign(params map[string]string) error {
+	signType := paramsSignType(params)
+	signer := c.signer()
+	if signType != signer.SignType() {
+		if _, ok := signer.(*MD5Signer); !ok {
+			return &VerifyError{Reason: RejectSignTypeUnsupported, SignType: string(signType)}
+		}
+		return VerifySign(params, c.Config.Key)
+	}
+
+	if params["sign"] == "" {
+		return &VerifyError{Reason: RejectSignMissing, SignType: string(signType)}
+	}
+	if err := signer.Verify(SignContent(params), params["sign"]); err != nil {
+		return &VerifyError{Reason: RejectSignMismatch, SignType: string(signType)}
+	}
+	return nil
+}
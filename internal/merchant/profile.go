@@ -0,0 +1,118 @@
+package merchant
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+)
+
+// DefaultName 由单商户配置生成的商户名称
+const DefaultName = "default"
+
+// Profile 一个易支付商户的配置及其路由规则
+type Profile struct {
+	Name              string `json:"name"`
+	PartnerID         string `json:"partner_id"`
+	Key               string `json:"key"`
+	Endpoint          string `json:"endpoint"`
+	SignType          string `json:"sign_type"`
+	PrivateKey        string `json:"private_key"`
+	PlatformPublicKey string `json:"platform_public_key"`
+	Mode              string `json:"mode"`
+	MapiEndpoint      string `json:"mapi_endpoint"`
+
+	// 支持的支付方式，为空时不限制
+	Types []string `json:"types"`
+	// 订单金额范围，单位为分，MaxAmount 为 0 时不限制上限
+	MinAmount int `json:"min_amount"`
+	MaxAmount int `json:"max_amount"`
+	// 优先级，数值越小越优先；相同优先级的商户按权重随机选择
+	Priority int `json:"priority"`
+	Weight   int `json:"weight"`
+	// 健康检查地址，为空时使用 Endpoint
+	HealthCheckURL string `json:"health_check_url"`
+}
+
+// LoadProfiles 读取商户配置。配置了 EpayMerchantsFile 时从该 JSON 文件读取商户列表，
+// 否则使用单商户配置生成名为 default 的商户；均未配置时返回空列表，不启用易支付渠道
+func LoadProfiles(conf *appconf.Config) ([]Profile, error) {
+	if conf.EpayMerchantsFile == "" {
+		if conf.EpayPartnerID == "" && conf.EpayEndpoint == "" {
+			return nil, nil
+		}
+		if conf.EpayPartnerID == "" || conf.EpayEndpoint == "" {
+			return nil, errors.New("CR_EPAY_EPAY_PARTNER_ID 与 CR_EPAY_EPAY_ENDPOINT 需同时配置")
+		}
+
+		return []Profile{{
+			Name:              DefaultName,
+			PartnerID:         conf.EpayPartnerID,
+			Key:               conf.EpayKey,
+			Endpoint:          conf.EpayEndpoint,
+			SignType:          conf.EpaySignType,
+			PrivateKey:        conf.EpayPrivateKey,
+			PlatformPublicKey: conf.EpayPlatformPublicKey,
+			Mode:              conf.EpayMode,
+			MapiEndpoint:      conf.EpayMapiEndpoint,
+		}}, nil
+	}
+
+	content, err := os.ReadFile(conf.EpayMerchantsFile)
+	if err != nil {
+		return nil, fmt.Errorf("无法读取商户配置文件: %w", err)
+	}
+
+	var profiles []Profile
+	if err := json.Unmarshal(content, &profiles); err != nil {
+		return nil, fmt.Errorf("无法解析商户配置文件: %w", err)
+	}
+	if len(profiles) == 0 {
+		return nil, errors.New("商户配置文件中没有商户")
+	}
+
+	names := map[string]bool{}
+	for i, p := range profiles {
+		if p.Name == "" || names[p.Name] {
+			return nil, fmt.Errorf("第 %d 个商户名称为空或重复", i+1)
+		}
+		names[p.Name] = true
+
+		if p.PartnerID == "" || p.Endpoint == "" {
+			return nil, fmt.Errorf("商户 %s 未配置 partner_id 或 endpoint", p.Name)
+		}
+
+		if p.Mode == "" {
+			profiles[i].Mode = conf.EpayMode
+		}
+	}
+
+	return profiles, nil
+}
+
+// supports 返回商户是否可以处理指定支付方式和金额的订单
+func (p *Profile) supports(purchaseType string, amount int) bool {
+	if len(p.Types) > 0 {
+		matched := false
+		for _, t := range p.Types {
+			if t == purchaseType {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if amount < p.MinAmount {
+		return false
+	}
+	if p.MaxAmount > 0 && amount > p.MaxAmount {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,175 @@
+package merchant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/shopspring/decimal"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/epay"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/provider"
+)
+
+// ProviderName 易支付渠道的名称
+const ProviderName = "epay"
+
+// RejectMerchantMismatch 通知中的商户不能处理该订单
+const RejectMerchantMismatch = "merchant_mismatch"
+
+// Provider 以易支付商户路由实现的支付渠道
+type Provider struct {
+	router *Router
+	orders order.Repository
+}
+
+// NewProvider 未配置任何商户时返回 nil，不启用易支付渠道
+func NewProvider(router *Router, orders order.Repository) provider.Provider {
+	if len(router.merchants) == 0 {
+		return nil
+	}
+	return &Provider{router: router, orders: orders}
+}
+
+func (p *Provider) Name() string {
+	return ProviderName
+}
+
+// SupportsCurrency 易支付只能以人民币收款
+func (p *Provider) SupportsCurrency(currency string) bool {
+	return currency == "CNY"
+}
+
+func (p *Provider) CreatePayment(ctx context.Context, req *provider.PaymentRequest) (*provider.Checkout, error) {
+	args := &epay.PurchaseArgs{
+		Type:           epay.PurchaseType(req.Method),
+		ServiceTradeNo: req.OrderNo,
+		Name:           req.Name,
+		Money:          decimal.New(int64(req.Amount), -2).StringFixed(2),
+		Device:         epay.DeviceType(req.Device),
+		ClientIP:       req.ClientIP,
+		NotifyUrl:      req.NotifyURL,
+		ReturnUrl:      req.ReturnURL,
+	}
+
+	m, checkout, err := p.router.Checkout(ctx, req.Amount, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &provider.Checkout{
+		Endpoint:  checkout.Endpoint,
+		Params:    checkout.Params,
+		TradeNo:   checkout.TradeNo,
+		PayURL:    checkout.PayURL,
+		QRCode:    checkout.QRCode,
+		URLScheme: checkout.URLScheme,
+		Merchant:  m.Name,
+	}, nil
+}
+
+// notificationParams 合并 query 参数与 POST 表单参数，易支付可能以 GET 或 POST 表单的方式发送回调
+func notificationParams(r *http.Request) map[string]string {
+	params := map[string]string{}
+	if err := r.ParseForm(); err != nil {
+		return params
+	}
+	for key := range r.Form {
+		params[key] = r.Form.Get(key)
+	}
+	return params
+}
+
+// VerifyNotification 使用订单记录的商户验签。用户可能在故障切换前后分别打开过支付页面，
+// 通知中的商户 ID 与订单记录不同时，仅接受该订单可能路由到的商户。返回验签的商户，
+// 确认支付时记录到订单，之后的查询与退款使用实际收款的商户
+func (p *Provider) VerifyNotification(r *http.Request, orderInfo *order.Order) (*provider.Result, error) {
+	params := notificationParams(r)
+
+	if orderInfo == nil {
+		// 旧版回调地址中没有订单号，按通知中的订单号读取
+		orderInfo, _ = p.orders.Get(params["out_trade_no"])
+	}
+
+	m, err := p.verifier(params["pid"], orderInfo)
+	if err != nil {
+		return nil, &provider.VerifyError{Reason: RejectMerchantMismatch, Err: err}
+	}
+
+	res, err := m.Client.Verify(params)
+	if err != nil {
+		return nil, &provider.VerifyError{Reason: epay.RejectReason(err), Err: err}
+	}
+
+	return &provider.Result{
+		OrderNo:  res.ServiceTradeNo,
+		TradeNo:  res.TradeNo,
+		Method:   string(res.Type),
+		Money:    res.Money,
+		Paid:     res.TradeStatus == epay.TRADE_SUCCESS,
+		Merchant: m.Name,
+	}, nil
+}
+
+// verifier 返回校验订单通知的商户。pid 为通知中的商户 ID，为空或与订单记录的商户一致时使用订单记录的商户，
+// 否则只能是按订单的支付方式与金额可以路由到的商户；订单不存在时使用第一个商户
+func (p *Provider) verifier(pid string, orderInfo *order.Order) (*Merchant, error) {
+	if orderInfo == nil {
+		return p.router.Get(""), nil
+	}
+
+	m := p.router.Get(orderInfo.Merchant)
+	if pid == "" || pid == m.PartnerID {
+		return m, nil
+	}
+	for _, candidate := range p.router.Route(orderInfo.PaymentType, orderInfo.Amount) {
+		if candidate.PartnerID == pid {
+			return candidate, nil
+		}
+	}
+	return nil, fmt.Errorf("商户 %s 不能处理订单 %s", pid, orderInfo.OrderNo)
+}
+
+// notFound 将订单不存在的错误转换为 ErrPaymentNotFound，其他错误（如密钥错误）原样返回
+func notFound(err error) error {
+	var apiErr *epay.APIError
+	if errors.As(err, &apiErr) && apiErr.NotFound() {
+		return fmt.Errorf("%w: %v", provider.ErrPaymentNotFound, err)
+	}
+	return err
+}
+
+func (p *Provider) Query(ctx context.Context, orderInfo *order.Order) (*provider.Result, error) {
+	m := p.router.Get(orderInfo.Merchant)
+
+	res, err := m.Client.Query(ctx, orderInfo.OrderNo)
+	if err != nil {
+		return nil, notFound(err)
+	}
+
+	return &provider.Result{
+		OrderNo:  res.ServiceTradeNo,
+		TradeNo:  res.TradeNo,
+		Method:   string(res.Type),
+		Money:    res.Money,
+		Paid:     res.Paid,
+		Merchant: m.Name,
+	}, nil
+}
+
+func (p *Provider) Refund(ctx context.Context, orderInfo *order.Order, amount int) error {
+	m := p.router.Get(orderInfo.Merchant)
+	return m.Client.Refund(ctx, orderInfo.TradeNo, decimal.New(int64(amount), -2).StringFixed(2))
+}
+
+// Ack 易支付要求处理成功时返回纯文本 success
+func (p *Provider) Ack(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("fail"))
+		return
+	}
+	_, _ = w.Write([]byte("success"))
+}
@@ -0,0 +1,267 @@
+package merchant
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/imroc/req/v3"
+
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/cache"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/epay"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/outbox"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/payment"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/provider"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/sandbox"
+)
+
+// notification 生成商户 pid 以 key 签名的支付成功通知
+func notification(pid, key, orderNo string) *http.Request {
+	params := epay.GenerateParams(map[string]string{
+		"pid":          pid,
+		"trade_no":     "T" + orderNo,
+		"out_trade_no": orderNo,
+		"type":         "alipay",
+		"name":         "测试商品",
+		"money":        "1.00",
+		"trade_status": epay.TRADE_SUCCESS,
+	}, key)
+
+	query := url.Values{}
+	for k, v := range params {
+		query.Set(k, v)
+	}
+	r, _ := http.NewRequest(http.MethodGet, "/payment/epay/notify/"+orderNo+"?"+query.Encode(), nil)
+	return r
+}
+
+func TestVerifyNotification(t *testing.T) {
+	router := &Router{Cooldown: time.Minute}
+	for _, p := range []Profile{
+		{Name: "main", PartnerID: "1010", Key: "main-key", Types: []string{"alipay"}},
+		{Name: "backup", PartnerID: "2020", Key: "backup-key", Priority: 1},
+		{Name: "wxpay-only", PartnerID: "3030", Key: "wxpay-key", Types: []string{"wxpay"}},
+	} {
+		router.merchants = append(router.merchants, &Merchant{
+			Profile: p,
+			Client:  epay.NewClient(&epay.Config{PartnerID: p.PartnerID, Key: p.Key}),
+		})
+	}
+
+	orders := order.NewCacheRepository(cache.NewMemoStore())
+	orderInfo := order.New("o1", "测试商品", "", 100, "CNY", time.Hour)
+	orderInfo.Merchant = "main"
+	orderInfo.PaymentType = "alipay"
+	if err := orders.Save(orderInfo); err != nil {
+		t.Fatal(err)
+	}
+	p := NewProvider(router, orders)
+
+	cases := []struct {
+		name     string
+		r        *http.Request
+		order    *order.Order
+		merchant string
+		reason   string
+	}{
+		{"订单记录的商户", notification("1010", "main-key", "o1"), orderInfo, "main", ""},
+		{"可以路由到的其他商户", notification("2020", "backup-key", "o1"), orderInfo, "backup", ""},
+		{"不支持该支付方式的商户", notification("3030", "wxpay-key", "o1"), orderInfo, "", RejectMerchantMismatch},
+		{"未配置的商户", notification("9999", "attacker-key", "o1"), orderInfo, "", RejectMerchantMismatch},
+		{"冒用订单商户的 pid", notification("1010", "backup-key", "o1"), orderInfo, "", epay.RejectSignMismatch},
+		{"冒用其他商户的 pid", notification("2020", "main-key", "o1"), orderInfo, "", epay.RejectSignMismatch},
+		{"回调地址中没有订单号", notification("1010", "main-key", "o1"), nil, "main", ""},
+		{"回调地址中没有订单号且商户不匹配", notification("3030", "wxpay-key", "o1"), nil, "", RejectMerchantMismatch},
+	}
+	for _, c := range cases {
+		res, err := p.VerifyNotification(c.r, c.order)
+		if c.reason == "" {
+			if err != nil {
+				t.Fatalf("%s: %v", c.name, err)
+			}
+			if res.OrderNo != "o1" || !res.Paid || res.Merchant != c.merchant {
+				t.Fatalf("%s: 校验结果为 %+v", c.name, res)
+			}
+			continue
+		}
+
+		var verifyErr *provider.VerifyError
+		if !errors.As(err, &verifyErr) || verifyErr.Reason != c.reason {
+			t.Fatalf("%s: 返回 %v，拒绝原因应为 %s", c.name, err, c.reason)
+		}
+	}
+}
+
+func TestQuery(t *testing.T) {
+	gateway := httptest.NewServer(sandbox.New("1010", "main-key", nil).Handler())
+	t.Cleanup(gateway.Close)
+
+	router := &Router{Cooldown: time.Minute}
+	for _, p := range []Profile{
+		{Name: "main", PartnerID: "1010", Key: "main-key"},
+		{Name: "wrong-key", PartnerID: "1010", Key: "other-key"},
+	} {
+		router.merchants = append(router.merchants, &Merchant{
+			Profile: p,
+			Client: epay.NewClient(&epay.Config{
+				PartnerID: p.PartnerID,
+				Key:       p.Key,
+				Endpoint:  gateway.URL + "/submit.php",
+				Mode:      epay.ModeMapi,
+			}),
+		})
+	}
+	p := NewProvider(router, order.NewCacheRepository(cache.NewMemoStore()))
+
+	notifyURL, _ := url.Parse("https://shop.example.com/notify")
+	returnURL, _ := url.Parse("https://shop.example.com/return")
+	if _, err := router.Get("main").Client.Checkout(context.Background(), &epay.PurchaseArgs{
+		Type:           "alipay",
+		ServiceTradeNo: "o1",
+		Name:           "测试商品",
+		Money:          "1.00",
+		NotifyUrl:      notifyURL,
+		ReturnUrl:      returnURL,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := p.Query(context.Background(), &order.Order{OrderNo: "o1", Merchant: "main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.OrderNo != "o1" || res.TradeNo == "" || res.Paid || res.Money != "1.00" || res.Merchant != "main" {
+		t.Fatalf("查询结果为 %+v", res)
+	}
+
+	// 只有订单不存在时返回 ErrPaymentNotFound
+	if _, err := p.Query(context.Background(), &order.Order{OrderNo: "missing", Merchant: "main"}); !errors.Is(err, provider.ErrPaymentNotFound) {
+		t.Fatalf("订单不存在时返回 %v", err)
+	}
+	_, err = p.Query(context.Background(), &order.Order{OrderNo: "missing", Merchant: "wrong-key"})
+	var apiErr *epay.APIError
+	if !errors.As(err, &apiErr) || errors.Is(err, provider.ErrPaymentNotFound) {
+		t.Fatalf("密钥错误时返回 %v", err)
+	}
+}
+
+func TestFailoverRefund(t *testing.T) {
+	gatewayA := sandbox.New("1010", "main-key", nil)
+	gatewayB := sandbox.New("2020", "backup-key", nil)
+	serverA := httptest.NewServer(gatewayA.Handler())
+	t.Cleanup(serverA.Close)
+	serverB := httptest.NewServer(gatewayB.Handler())
+	t.Cleanup(serverB.Close)
+
+	router := &Router{Cooldown: time.Minute}
+	for _, m := range []struct {
+		profile Profile
+		server  *httptest.Server
+	}{
+		{Profile{Name: "main", PartnerID: "1010", Key: "main-key"}, serverA},
+		{Profile{Name: "backup", PartnerID: "2020", Key: "backup-key", Priority: 1}, serverB},
+	} {
+		router.merchants = append(router.merchants, &Merchant{
+			Profile: m.profile,
+			Client: epay.NewClient(&epay.Config{
+				PartnerID: m.profile.PartnerID,
+				Key:       m.profile.Key,
+				Endpoint:  m.server.URL + "/submit.php",
+				Mode:      epay.ModeMapi,
+			}),
+		})
+	}
+
+	driver := cache.NewMemoStore()
+	orders := order.NewCacheRepository(driver)
+	p := NewProvider(router, orders)
+	conf := &appconf.Config{PaymentProvider: ProviderName, Currency: "CNY"}
+	registry, err := provider.NewRegistry(conf, []provider.Provider{p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payments := payment.NewService(conf, registry, orders, outbox.NewStore(driver), driver, req.C())
+
+	shop := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/notify/o1" {
+			return
+		}
+		_, err := payments.ConfirmNotification(r, ProviderName, "o1")
+		p.Ack(w, err)
+	}))
+	t.Cleanup(shop.Close)
+
+	orderInfo := order.New("o1", "测试商品", "", 100, "CNY", time.Hour)
+	orderInfo.Provider = ProviderName
+	orderInfo.Merchant = "main"
+	orderInfo.PaymentType = "alipay"
+	if err := orderInfo.Transition(order.StatusPending, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := orders.Save(orderInfo); err != nil {
+		t.Fatal(err)
+	}
+
+	notifyURL, _ := url.Parse(shop.URL + "/notify/o1")
+	returnURL, _ := url.Parse(shop.URL + "/return/o1")
+	args := &epay.PurchaseArgs{
+		Type:           "alipay",
+		ServiceTradeNo: "o1",
+		Name:           "测试商品",
+		Money:          "1.00",
+		NotifyUrl:      notifyURL,
+		ReturnUrl:      returnURL,
+	}
+
+	// 订单通过商户 A 发起支付，用户最终在故障切换后的商户 B 完成支付
+	if _, err := router.Get("main").Client.Checkout(context.Background(), args); err != nil {
+		t.Fatal(err)
+	}
+	checkout, err := router.Get("backup").Client.Checkout(context.Background(), args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.PostForm(checkout.PayURL, url.Values{"action": {sandbox.ActionPay}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	tradeB, _ := gatewayB.Trade("o1")
+	orderInfo, _ = orders.Get("o1")
+	if !orderInfo.IsPaid() || orderInfo.Merchant != "backup" || orderInfo.TradeNo != tradeB.TradeNo {
+		t.Fatalf("商户 B 确认支付后订单为 %+v", orderInfo)
+	}
+
+	// 退款发往实际收款的商户 B
+	if _, err := payments.Refund(context.Background(), "o1", 0, "测试退款"); err != nil {
+		t.Fatal(err)
+	}
+	if trade, _ := gatewayB.Trade("o1"); trade.Refunded.StringFixed(2) != "1.00" {
+		t.Fatalf("商户 B 的退款金额为 %s", trade.Refunded)
+	}
+	if trade, _ := gatewayA.Trade("o1"); !trade.Refunded.IsZero() {
+		t.Fatalf("商户 A 的退款金额为 %s", trade.Refunded)
+	}
+}
+
+func TestRegistryCurrency(t *testing.T) {
+	router := newTestRouter(Profile{Name: "main"})
+	p := NewProvider(router, order.NewCacheRepository(cache.NewMemoStore()))
+
+	for currency, ok := range map[string]bool{"CNY": true, "cny": true, "USD": false, "USDT": false} {
+		conf := &appconf.Config{PaymentProvider: ProviderName, Currency: currency}
+		if _, err := provider.NewRegistry(conf, []provider.Provider{p}); (err == nil) != ok {
+			t.Fatalf("网关货币为 %s 时返回 %v", currency, err)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package merchant
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/imroc/req/v3"
+	"github.com/sirupsen/logrus"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/epay"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/provider"
+	"go.uber.org/fx"
+)
+
+func Module() fx.Option {
+	return fx.Module("merchant",
+		fx.Provide(NewRouter),
+		provider.Register(NewProvider),
+		fx.Invoke(func(lc fx.Lifecycle, r *Router) {
+			lc.Append(fx.Hook{
+				OnStart: r.Start,
+				OnStop:  r.Stop,
+			})
+		}),
+	)
+}
+
+// Merchant 一个已初始化的易支付商户
+type Merchant struct {
+	Profile
+	Client epay.Client
+
+	mu             sync.Mutex
+	unhealthyUntil time.Time
+}
+
+// Healthy 返回商户当前是否可用
+func (m *Merchant) Healthy(now time.Time) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return !now.Before(m.unhealthyUntil)
+}
+
+// Router 按支付方式、金额、优先级和权重在多个商户间选择，
+// 发起支付或健康检查失败的商户在冷却时间内会被排到最后
+type Router struct {
+	merchants []*Merchant
+	client    *req.Client
+
+	Cooldown            time.Duration
+	HealthCheckInterval time.Duration
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewRouter(conf *appconf.Config, client *req.Client) (*Router, error) {
+	profiles, err := LoadProfiles(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	router := &Router{
+		client:              client,
+		Cooldown:            conf.EpayFailoverCooldown,
+		HealthCheckInterval: conf.EpayHealthCheckInterval,
+	}
+
+	for _, p := range profiles {
+		signer, err := epay.NewSigner(p.SignType, p.Key, p.PrivateKey, p.PlatformPublicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		router.merchants = append(router.merchants, &Merchant{
+			Profile: p,
+			Client: epay.NewClient(&epay.Config{
+				PartnerID:    p.PartnerID,
+				Key:          p.Key,
+				Endpoint:     p.Endpoint,
+				Signer:       signer,
+				Mode:         epay.Mode(p.Mode),
+				MapiEndpoint: p.MapiEndpoint,
+				HTTPClient:   client,
+			}),
+		})
+	}
+
+	return router, nil
+}
+
+// Get 按名称获取商户，名称为空或不存在时返回第一个商户
+func (r *Router) Get(name string) *Merchant {
+	for _, m := range r.merchants {
+		if m.Name == name {
+			return m
+		}
+	}
+	return r.merchants[0]
+}
+
+// Route 返回可以处理该订单的商户，按尝试顺序排列
+func (r *Router) Route(purchaseType string, amount int) []*Merchant {
+	now := time.Now()
+
+	var healthy, unhealthy []*Merchant
+	for _, m := range r.merchants {
+		if !m.supports(purchaseType, amount) {
+			continue
+		}
+		if m.Healthy(now) {
+			healthy = append(healthy, m)
+		} else {
+			unhealthy = append(unhealthy, m)
+		}
+	}
+
+	return append(weightedOrder(healthy), weightedOrder(unhealthy)...)
+}
+
+// ErrNoMerchant 没有可以处理该订单的商户
+var ErrNoMerchant = errors.New("没有可用的商户")
+
+// Checkout 依次尝试路由选出的商户发起支付，失败的商户会被标记为不可用。
+// amount 为订单金额，单位为分
+func (r *Router) Checkout(ctx context.Context, amount int, args *epay.PurchaseArgs) (*Merchant, *epay.Checkout, error) {
+	candidates := r.Route(string(args.Type), amount)
+	if len(candidates) == 0 {
+		return nil, nil, ErrNoMerchant
+	}
+
+	var lastErr error
+	for _, m := range candidates {
+		checkout, err := m.Client.Checkout(ctx, args)
+		if err == nil {
+			return m, checkout, nil
+		}
+		if ctx.Err() != nil {
+			return nil, nil, err
+		}
+
+		r.MarkFailed(m, err)
+		lastErr = err
+	}
+
+	return nil, nil, lastErr
+}
+
+// MarkFailed 将商户标记为不可用，冷却时间后恢复
+func (r *Router) MarkFailed(m *Merchant, cause error) {
+	m.mu.Lock()
+	m.unhealthyUntil = time.Now().Add(r.Cooldown)
+	m.mu.Unlock()
+
+	logrus.WithField("merchant", m.Name).WithError(cause).Warningln("商户不可用，切换至其他商户")
+}
+
+// markHealthy 将商户恢复为可用
+func (r *Router) markHealthy(m *Merchant) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.unhealthyUntil.IsZero() {
+		logrus.WithField("merchant", m.Name).Infoln("商户已恢复可用")
+	}
+	m.unhealthyUntil = time.Time{}
+}
+
+// Start 启动定期健康检查，HealthCheckInterval 不大于 0 时不启动
+func (r *Router) Start(ctx context.Context) error {
+	if r.HealthCheckInterval <= 0 {
+		return nil
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(r.HealthCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			r.CheckHealth(runCtx)
+
+			select {
+			case <-runCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Stop 停止健康检查
+func (r *Router) Stop(ctx context.Context) error {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.wg.Wait()
+	return nil
+}
+
+// CheckHealth 请求每个商户的健康检查地址，网络错误或 5xx 响应视为不可用
+func (r *Router) CheckHealth(ctx context.Context) {
+	for _, m := range r.merchants {
+		url := m.HealthCheckURL
+		if url == "" {
+			url = m.Endpoint
+		}
+
+		checkCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		resp, err := r.client.R().SetContext(checkCtx).Get(url)
+		cancel()
+
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil && resp.StatusCode >= 500 {
+			err = &healthError{status: resp.StatusCode}
+		}
+
+		if err != nil {
+			r.MarkFailed(m, err)
+		} else {
+			r.markHealthy(m)
+		}
+	}
+}
+
+type healthError struct {
+	status int
+}
+
+func (e *healthError) Error() string {
+	return "健康检查返回 HTTP " + http.StatusText(e.status)
+}
+
+// weightedOrder 按优先级升序排列，相同优先级内按权重随机排序
+func weightedOrder(merchants []*Merchant) []*Merchant {
+	keys := make(map[*Merchant]float64, len(merchants))
+	for _, m := range merchants {
+		weight := m.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		// 加权随机排序：权重越大，排序键越可能靠前
+		keys[m] = -math.Pow(rand.Float64(), 1/float64(weight))
+	}
+
+	sorted := append([]*Merchant(nil), merchants...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Priority != sorted[j].Priority {
+			return sorted[i].Priority < sorted[j].Priority
+		}
+		return keys[sorted[i]] < keys[sorted[j]]
+	})
+	return sorted
+}
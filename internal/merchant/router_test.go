@@ -0,0 +1,153 @@
+package merchant
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/topjohncian/cloudreve-pro-epay/internal/epay"
+)
+
+// fakeClient 发起支付时按 err 返回结果的易支付客户端
+type fakeClient struct {
+	epay.Client
+	err   error
+	calls int
+}
+
+func (c *fakeClient) Checkout(ctx context.Context, args *epay.PurchaseArgs) (*epay.Checkout, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &epay.Checkout{PayURL: "https://pay.example.com/" + args.ServiceTradeNo}, nil
+}
+
+func newTestRouter(profiles ...Profile) *Router {
+	router := &Router{Cooldown: time.Minute}
+	for _, p := range profiles {
+		router.merchants = append(router.merchants, &Merchant{Profile: p, Client: &fakeClient{}})
+	}
+	return router
+}
+
+func names(merchants []*Merchant) []string {
+	var res []string
+	for _, m := range merchants {
+		res = append(res, m.Name)
+	}
+	return res
+}
+
+func TestRoute(t *testing.T) {
+	router := newTestRouter(
+		Profile{Name: "backup", Priority: 10},
+		Profile{Name: "alipay-only", Types: []string{"alipay"}, Priority: 0},
+		Profile{Name: "small", MaxAmount: 1000, Priority: 1},
+		Profile{Name: "large", MinAmount: 5000, Priority: 2},
+	)
+
+	cases := []struct {
+		purchaseType string
+		amount       int
+		want         []string
+	}{
+		{"alipay", 100, []string{"alipay-only", "small", "backup"}},
+		{"wxpay", 100, []string{"small", "backup"}},
+		{"wxpay", 1000, []string{"small", "backup"}},
+		{"wxpay", 3000, []string{"backup"}},
+		{"alipay", 5000, []string{"alipay-only", "large", "backup"}},
+	}
+	for _, c := range cases {
+		got := names(router.Route(c.purchaseType, c.amount))
+		if len(got) != len(c.want) {
+			t.Fatalf("%s %d 路由为 %v，应为 %v", c.purchaseType, c.amount, got, c.want)
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Fatalf("%s %d 路由为 %v，应为 %v", c.purchaseType, c.amount, got, c.want)
+			}
+		}
+	}
+
+	// 不可用的商户排到最后
+	router.MarkFailed(router.Get("alipay-only"), errors.New("down"))
+	if got := names(router.Route("alipay", 100)); got[len(got)-1] != "alipay-only" {
+		t.Fatalf("不可用的商户应排到最后: %v", got)
+	}
+	router.markHealthy(router.Get("alipay-only"))
+	if got := names(router.Route("alipay", 100)); got[0] != "alipay-only" {
+		t.Fatalf("恢复可用后应按优先级排序: %v", got)
+	}
+}
+
+func TestWeightedOrder(t *testing.T) {
+	heavy := &Merchant{Profile: Profile{Name: "heavy", Weight: 3}}
+	light := &Merchant{Profile: Profile{Name: "light", Weight: 1}}
+	zero := &Merchant{Profile: Profile{Name: "zero", Weight: 0}}
+	later := &Merchant{Profile: Profile{Name: "later", Priority: 1, Weight: 100}}
+
+	const rounds = 4000
+	first := map[string]int{}
+	for i := 0; i < rounds; i++ {
+		sorted := weightedOrder([]*Merchant{later, light, heavy})
+		if sorted[2] != later {
+			t.Fatalf("优先级较低的商户应排在最后: %v", names(sorted))
+		}
+		first[sorted[0].Name]++
+	}
+	// 权重 3:1 时，heavy 排在第一位的概率为 3/4
+	if share := float64(first["heavy"]) / rounds; share < 0.7 || share > 0.8 {
+		t.Fatalf("heavy 排在第一位的比例为 %.2f，应约为 0.75", share)
+	}
+
+	// 权重不大于 0 时按 1 处理
+	first = map[string]int{}
+	for i := 0; i < rounds; i++ {
+		first[weightedOrder([]*Merchant{zero, light})[0].Name]++
+	}
+	if share := float64(first["zero"]) / rounds; share < 0.45 || share > 0.55 {
+		t.Fatalf("权重为 0 的商户排在第一位的比例为 %.2f，应约为 0.5", share)
+	}
+}
+
+func TestCheckoutFailover(t *testing.T) {
+	router := newTestRouter(
+		Profile{Name: "main", Priority: 0},
+		Profile{Name: "backup", Priority: 1},
+	)
+	mainClient := router.Get("main").Client.(*fakeClient)
+	backupClient := router.Get("backup").Client.(*fakeClient)
+	mainClient.err = errors.New("mapi 不可用")
+
+	args := &epay.PurchaseArgs{Type: "alipay", ServiceTradeNo: "o1"}
+	m, checkout, err := router.Checkout(context.Background(), 100, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "backup" || checkout.PayURL == "" {
+		t.Fatalf("应切换至 backup，实际为 %s", m.Name)
+	}
+	if router.Get("main").Healthy(time.Now()) {
+		t.Fatal("发起支付失败的商户应标记为不可用")
+	}
+
+	// 冷却时间内优先使用可用的商户
+	if m, _, err := router.Checkout(context.Background(), 100, args); err != nil || m.Name != "backup" || mainClient.calls != 1 {
+		t.Fatalf("冷却时间内应直接使用 backup: %v, %v, main 被调用 %d 次", m, err, mainClient.calls)
+	}
+
+	// 所有商户均失败时返回最后尝试的商户的错误，不可用的 main 排在最后
+	backupClient.err = errors.New("backup 不可用")
+	if _, _, err := router.Checkout(context.Background(), 100, args); err != mainClient.err || backupClient.calls != 3 {
+		t.Fatalf("所有商户失败时返回 %v", err)
+	}
+
+	// 没有支持该支付方式的商户
+	router.merchants[0].Types = []string{"wxpay"}
+	router.merchants[1].Types = []string{"wxpay"}
+	if _, _, err := router.Checkout(context.Background(), 100, args); !errors.Is(err, ErrNoMerchant) {
+		t.Fatalf("没有可用商户时返回 %v", err)
+	}
+}
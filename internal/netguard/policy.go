@@ -0,0 +1,271 @@
+package netguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/imroc/req/v3"
+	"github.com/sirupsen/logrus"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+	"go.uber.org/fx"
+)
+
+// NotifyClientTag 发送 Cloudreve 通知的 req.Client 在 fx 中的名称
+const NotifyClientTag = `name:"notify"`
+
+func Module() fx.Option {
+	return fx.Module("netguard",
+		fx.Provide(NewPolicy),
+		fx.Provide(fx.Annotate(NewNotifyClient, fx.ResultTags(NotifyClientTag))),
+	)
+}
+
+var (
+	ErrInvalidURL        = errors.New("通知地址无效")
+	ErrSchemeNotAllowed  = errors.New("通知地址的协议不在允许列表中")
+	ErrHostNotAllowed    = errors.New("通知地址的主机不在允许列表中")
+	ErrAddressNotAllowed = errors.New("通知地址解析到的 IP 地址不允许访问")
+)
+
+// reservedNetworks 除私有、回环、链路本地等地址外，其他不应从公网访问的地址段
+var reservedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"100.64.0.0/10",
+	"192.0.0.0/24",
+	"198.18.0.0/15",
+	"240.0.0.0/4",
+	"64:ff9b::/96",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// Policy 限制向 Cloudreve 发送通知时可以访问的地址，避免 notify_url 被用于访问内网
+type Policy struct {
+	// 允许的协议
+	Schemes []string
+	// 允许的主机，支持 *.example.com 形式的通配符，含端口时端口也需一致
+	Hosts []string
+	// 是否允许私有、回环等内网地址
+	AllowPrivate bool
+	// 即使属于内网也允许访问的地址段
+	AllowedNetworks []*net.IPNet
+}
+
+// NewPolicy 未配置允许的主机时，仅允许 Cloudreve 站点的主机，未配置 Cloudreve 地址时使用 CR_EPAY_BASE 的主机
+func NewPolicy(conf *appconf.Config) (*Policy, error) {
+	policy := &Policy{
+		AllowPrivate: conf.NotifyAllowPrivate,
+	}
+
+	for _, scheme := range conf.NotifyAllowedSchemes {
+		if scheme = strings.ToLower(strings.TrimSpace(scheme)); scheme != "" {
+			policy.Schemes = append(policy.Schemes, scheme)
+		}
+	}
+
+	for _, host := range conf.NotifyAllowedHosts {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			policy.Hosts = append(policy.Hosts, host)
+		}
+	}
+	defaultHost := len(policy.Hosts) == 0
+	if defaultHost {
+		site := conf.CloudreveURL
+		if site == "" {
+			site = conf.Base
+		}
+		u, err := url.Parse(site)
+		if err != nil || u.Hostname() == "" {
+			return nil, fmt.Errorf("无法从 %q 中获取允许的通知主机", site)
+		}
+		policy.Hosts = []string{strings.ToLower(u.Hostname())}
+	}
+
+	for _, value := range conf.NotifyAllowedNetworks {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("允许的地址段 %q 无效", value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				bits = 8 * net.IPv4len
+			}
+			value = fmt.Sprintf("%s/%d", value, bits)
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("允许的地址段 %q 无效", value)
+		}
+		policy.AllowedNetworks = append(policy.AllowedNetworks, network)
+	}
+
+	// 默认只允许 Cloudreve 站点的主机，该主机解析到不允许访问的地址时所有通知都会失败，启动时即报错
+	if defaultHost {
+		if err := policy.checkHost(policy.Hosts[0]); err != nil {
+			return nil, fmt.Errorf("Cloudreve 站点 %s 无法接收通知，请配置 CR_EPAY_NOTIFY_ALLOWED_NETWORKS 或 CR_EPAY_NOTIFY_ALLOW_PRIVATE: %w", policy.Hosts[0], err)
+		}
+	}
+
+	logrus.WithField("hosts", policy.Hosts).WithField("schemes", policy.Schemes).Debugln("允许的 Cloudreve 通知地址")
+	return policy, nil
+}
+
+// checkHost 解析主机并检查所有地址是否允许访问，解析失败时只记录警告，避免启动时 DNS 暂不可用导致无法启动
+func (p *Policy) checkHost(host string) error {
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		return p.CheckIP(ip)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		logrus.WithField("host", host).WithError(err).Warningln("无法解析 Cloudreve 站点的主机")
+		return nil
+	}
+	for _, addr := range addrs {
+		if err := p.CheckIP(addr.IP); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckURL 检查通知地址的协议与主机是否在允许列表中，主机为 IP 时同时检查 IP 地址
+func (p *Policy) CheckURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil || u.Hostname() == "" {
+		return ErrInvalidURL
+	}
+
+	if !p.schemeAllowed(strings.ToLower(u.Scheme)) {
+		return fmt.Errorf("%w: %s", ErrSchemeNotAllowed, u.Scheme)
+	}
+	if !p.hostAllowed(strings.ToLower(u.Hostname()), strings.ToLower(u.Host)) {
+		return fmt.Errorf("%w: %s", ErrHostNotAllowed, u.Host)
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		return p.CheckIP(ip)
+	}
+	return nil
+}
+
+func (p *Policy) schemeAllowed(scheme string) bool {
+	for _, allowed := range p.Schemes {
+		if scheme == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// hostAllowed hostname 为不含端口的主机名，host 为可能含端口的主机
+func (p *Policy) hostAllowed(hostname, host string) bool {
+	for _, allowed := range p.Hosts {
+		target := hostname
+		if _, _, err := net.SplitHostPort(allowed); err == nil {
+			target = host
+		}
+
+		if suffix, ok := strings.CutPrefix(allowed, "*."); ok {
+			if strings.HasSuffix(target, "."+suffix) {
+				return true
+			}
+			continue
+		}
+		if target == strings.Trim(allowed, "[]") || target == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckIP 检查 IP 地址是否允许访问
+func (p *Policy) CheckIP(ip net.IP) error {
+	for _, network := range p.AllowedNetworks {
+		if network.Contains(ip) {
+			return nil
+		}
+	}
+	if p.AllowPrivate || !internal(ip) {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrAddressNotAllowed, ip)
+}
+
+// internal 返回 IP 是否为内网、回环或其他保留地址
+func internal(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// control 在 DNS 解析完成、建立连接前检查实际连接的 IP 地址，可以防止 DNS 重绑定
+func (p *Policy) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w: %s", ErrAddressNotAllowed, host)
+	}
+	return p.CheckIP(ip)
+}
+
+// DialContext 返回在连接时检查 IP 地址的拨号函数
+func (p *Policy) DialContext() func(ctx context.Context, network, addr string) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   p.control,
+	}
+	return dialer.DialContext
+}
+
+// NewNotifyClient 创建发送 Cloudreve 通知的客户端：连接时检查 IP 地址，不使用代理，
+// 跳转的目标地址同样需要在允许列表中
+func NewNotifyClient(conf *appconf.Config, policy *Policy) *req.Client {
+	client := req.C().
+		SetDial(policy.DialContext()).
+		SetProxy(nil).
+		SetRedirectPolicy(
+			req.MaxRedirectPolicy(10),
+			func(r *http.Request, via []*http.Request) error {
+				return policy.CheckURL(r.URL.String())
+			},
+		)
+	if conf.Debug {
+		client.DevMode()
+	}
+	return client
+}
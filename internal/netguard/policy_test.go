@@ -0,0 +1,159 @@
+package netguard
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+)
+
+func TestDefaultHost(t *testing.T) {
+	policy, err := NewPolicy(&appconf.Config{
+		Base:                 "https://pay.example.com:8443",
+		NotifyAllowedSchemes: []string{"http", "https"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.CheckURL("https://pay.example.com/api/v4/callback/notify/1"); err != nil {
+		t.Fatalf("CR_EPAY_BASE 的主机被拒绝: %v", err)
+	}
+
+	policy, err = NewPolicy(&appconf.Config{
+		Base:                 "https://pay.example.com",
+		CloudreveURL:         "https://cloud.example.com",
+		NotifyAllowedSchemes: []string{"https"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.CheckURL("https://cloud.example.com/api/v4/callback/notify/1"); err != nil {
+		t.Fatalf("Cloudreve 站点的主机被拒绝: %v", err)
+	}
+	if err := policy.CheckURL("https://pay.example.com/api/v4/callback/notify/1"); !errors.Is(err, ErrHostNotAllowed) {
+		t.Fatalf("配置 Cloudreve 地址后 CR_EPAY_BASE 的主机返回 %v", err)
+	}
+}
+
+func TestDefaultHostPrivate(t *testing.T) {
+	cases := []struct {
+		name string
+		conf *appconf.Config
+		ok   bool
+	}{
+		{"回环地址", &appconf.Config{Base: "http://127.0.0.1:4560"}, false},
+		{"允许的主机为空", &appconf.Config{Base: "http://127.0.0.1:4560", NotifyAllowedHosts: []string{""}}, false},
+		{"解析到回环地址", &appconf.Config{Base: "http://localhost:4560"}, false},
+		{"内网地址", &appconf.Config{Base: "https://pay.example.com", CloudreveURL: "http://10.0.0.2"}, false},
+		{"IPv6 回环地址", &appconf.Config{CloudreveURL: "http://[::1]:5212"}, false},
+		{"允许内网地址", &appconf.Config{CloudreveURL: "http://10.0.0.2", NotifyAllowPrivate: true}, true},
+		{"允许的地址段", &appconf.Config{CloudreveURL: "http://10.0.0.2", NotifyAllowedNetworks: []string{"10.0.0.0/24"}}, true},
+		{"公网地址", &appconf.Config{CloudreveURL: "https://203.0.113.10"}, true},
+		// 配置了允许的主机时不检查 Cloudreve 站点
+		{"配置了允许的主机", &appconf.Config{CloudreveURL: "http://10.0.0.2", NotifyAllowedHosts: []string{"cloud.example.com"}}, true},
+	}
+	for _, c := range cases {
+		_, err := NewPolicy(c.conf)
+		if c.ok && err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if !c.ok && !errors.Is(err, ErrAddressNotAllowed) {
+			t.Fatalf("%s: 返回 %v，应拒绝启动", c.name, err)
+		}
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	policy, err := NewPolicy(&appconf.Config{
+		NotifyAllowedHosts:    []string{"cloud.example.com", "*.example.org", "api.example.net:8080", "10.0.0.5", "192.168.1.1"},
+		NotifyAllowedSchemes:  []string{"https"},
+		NotifyAllowedNetworks: []string{"10.0.0.0/24"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		url string
+		err error
+	}{
+		{"https://cloud.example.com/notify", nil},
+		{"https://CLOUD.example.com:8443/notify", nil},
+		{"https://a.b.example.org/notify", nil},
+		{"https://api.example.net:8080/notify", nil},
+		{"https://10.0.0.5/notify", nil},
+		{"http://cloud.example.com/notify", ErrSchemeNotAllowed},
+		{"https://example.org/notify", ErrHostNotAllowed},
+		{"https://cloud.example.com.evil.com/notify", ErrHostNotAllowed},
+		{"https://api.example.net/notify", ErrHostNotAllowed},
+		{"https://192.168.1.1/notify", ErrAddressNotAllowed},
+		{"/notify", ErrInvalidURL},
+		{"://bad", ErrInvalidURL},
+	}
+	for _, c := range cases {
+		if err := policy.CheckURL(c.url); !errors.Is(err, c.err) {
+			t.Errorf("%s 返回 %v，应为 %v", c.url, err, c.err)
+		}
+	}
+}
+
+func TestCheckIP(t *testing.T) {
+	policy := &Policy{}
+	for _, ip := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.0.1", "169.254.169.254", "100.64.0.1", "0.0.0.0", "::1", "fc00::1", "fe80::1", "::ffff:127.0.0.1"} {
+		if err := policy.CheckIP(net.ParseIP(ip)); !errors.Is(err, ErrAddressNotAllowed) {
+			t.Errorf("内网地址 %s 返回 %v", ip, err)
+		}
+	}
+	for _, ip := range []string{"1.1.1.1", "8.8.8.8", "2606:4700::1111"} {
+		if err := policy.CheckIP(net.ParseIP(ip)); err != nil {
+			t.Errorf("公网地址 %s 被拒绝: %v", ip, err)
+		}
+	}
+
+	policy.AllowPrivate = true
+	if err := policy.CheckIP(net.ParseIP("127.0.0.1")); err != nil {
+		t.Errorf("允许内网地址后 127.0.0.1 被拒绝: %v", err)
+	}
+}
+
+func TestNotifyClient(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"code":0}`))
+	}))
+	defer target.Close()
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, strings.Replace(target.URL, "127.0.0.1", "localhost", 1), http.StatusFound)
+	}))
+	defer redirect.Close()
+
+	conf := &appconf.Config{
+		NotifyAllowedHosts:   []string{"127.0.0.1", "localhost"},
+		NotifyAllowedSchemes: []string{"http"},
+	}
+	policy, err := NewPolicy(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := NewNotifyClient(conf, policy)
+
+	// 主机名在允许列表中，但连接的地址为回环地址
+	if _, err := client.R().Get(strings.Replace(target.URL, "127.0.0.1", "localhost", 1)); !errors.Is(err, ErrAddressNotAllowed) {
+		t.Fatalf("连接回环地址返回 %v", err)
+	}
+
+	policy.AllowedNetworks = mustParseCIDRs("127.0.0.1/32")
+	resp, err := client.R().Get(target.URL)
+	if err != nil || !resp.IsSuccessState() {
+		t.Fatalf("允许的地址请求失败: %v", err)
+	}
+
+	// 跳转的目标同样需要在允许列表中
+	policy.Hosts = []string{"127.0.0.1"}
+	if _, err := client.R().Get(redirect.URL); !errors.Is(err, ErrHostNotAllowed) {
+		t.Fatalf("跳转至不允许的主机返回 %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package order
+
+import (
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	"github.com/topjohncian/cloudreve-pro-epay/internal/cache"
+)
+
+const (
+	legacySessionPrefix = "purchase_session_"
+	legacyPaidPrefix    = "paid_order_"
+	legacySessionTTL    = 24 * time.Hour
+)
+
+// legacyPurchaseRequest 旧版本以 purchase_session_ 为前缀保存的订单信息。
+// 以旧版本的 gob 类型名注册，以便升级后仍能读取尚未完成的订单
+type legacyPurchaseRequest struct {
+	Name      string
+	OrderNo   string
+	NotifyUrl string
+	Amount    int
+	Currency  string
+}
+
+func init() {
+	gob.RegisterName("*controller.PurchaseRequest", &legacyPurchaseRequest{})
+	cache.RegisterType("order.legacyPurchaseRequest", &legacyPurchaseRequest{})
+}
+
+// importLegacy 将旧版本缓存中的订单导入订单存储，不存在时返回 ErrNotFound，读取失败时返回读取错误
+func (r *CacheRepository) importLegacy(orderNo string) (*Order, error) {
+	var order *Order
+
+	value, ok, err := r.driver.Fetch(legacySessionPrefix + orderNo)
+	if err != nil {
+		return nil, fmt.Errorf("无法读取旧版本订单: %w", err)
+	}
+	if ok {
+		req, ok := value.(*legacyPurchaseRequest)
+		if !ok {
+			return nil, ErrNotFound
+		}
+		order = New(req.OrderNo, req.Name, req.NotifyUrl, req.Amount, req.Currency, legacySessionTTL)
+	} else {
+		paid, ok, err := r.driver.Fetch(legacyPaidPrefix + orderNo)
+		if err != nil {
+			return nil, fmt.Errorf("无法读取旧版本订单: %w", err)
+		}
+		if !ok || paid != true {
+			return nil, ErrNotFound
+		}
+		// 旧版本仅保留了已支付标记
+		order = New(orderNo, "", "", 0, "", 0)
+		order.Status = StatusNotified
+	}
+
+	if err := r.put(order); err != nil {
+		return nil, err
+	}
+	_ = r.driver.Delete([]string{orderNo}, legacySessionPrefix)
+
+	return order, nil
+}
@@ -0,0 +1,229 @@
+package order
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Status 订单支付状态
+type Status string
+
+const (
+	// StatusCreated Cloudreve 已创建订单，用户尚未打开支付页面
+	StatusCreated Status = "CREATED"
+	// StatusPending 用户已被引导至支付网关，等待支付结果
+	StatusPending Status = "PENDING"
+	// StatusPaid 已收到支付成功通知，尚未通知 Cloudreve
+	StatusPaid Status = "PAID"
+	// StatusNotified 已成功通知 Cloudreve
+	StatusNotified Status = "NOTIFIED"
+	// StatusExpired 订单超时未支付
+	StatusExpired Status = "EXPIRED"
+	// StatusFailed 支付失败
+	StatusFailed Status = "FAILED"
+	// StatusRefunded 已退款
+	StatusRefunded Status = "REFUNDED"
+)
+
+var (
+	ErrNotFound          = errors.New("订单不存在")
+	ErrExists            = errors.New("订单已存在")
+	ErrInvalidTransition = errors.New("订单状态转换无效")
+	ErrRefundNotAllowed  = errors.New("订单当前状态不允许退款")
+	ErrRefundAmount      = errors.New("退款金额无效")
+)
+
+// transitions 允许的状态转换，过期或失败的订单仍可接受迟到的支付成功通知
+var transitions = map[Status][]Status{
+	StatusCreated:  {StatusPending, StatusPaid, StatusExpired, StatusFailed},
+	StatusPending:  {StatusPaid, StatusExpired, StatusFailed},
+	StatusExpired:  {StatusPaid},
+	StatusFailed:   {StatusPaid},
+	StatusPaid:     {StatusNotified, StatusRefunded},
+	StatusNotified: {StatusRefunded},
+}
+
+// Transition 一次状态转换记录
+type Transition struct {
+	From   Status
+	To     Status
+	At     time.Time
+	Reason string
+}
+
+// Refund 一次退款记录
+type Refund struct {
+	// 金额，单位为分
+	Amount int
+	At     time.Time
+	Reason string
+}
+
+// Order 订单
+type Order struct {
+	OrderNo   string
+	Name      string
+	NotifyUrl string
+	// 支付网关货币的金额，单位为分
+	Amount   int
+	Currency string
+	// Cloudreve 下单时的原始金额（单位为分）、货币及换算使用的汇率
+	OriginalAmount   int
+	OriginalCurrency string
+	ExchangeRate     string
+
+	Status Status
+	// 易支付订单号
+	TradeNo string
+	// 支付方式
+	PaymentType string
+	// 处理该订单的支付渠道，为空时为默认渠道
+	Provider string
+	// 处理该订单的商户账号
+	Merchant string
+
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	ExpiresAt  time.Time
+	PaidAt     time.Time
+	NotifiedAt time.Time
+
+	// 累计退款金额，单位为分
+	RefundedAmount int
+	Refunds        []Refund
+
+	History []Transition
+}
+
+// New 创建状态为 CREATED 的订单，ttl 后未支付则视为过期
+func New(orderNo, name, notifyUrl string, amount int, currency string, ttl time.Duration) *Order {
+	now := time.Now()
+	return &Order{
+		OrderNo:   orderNo,
+		Name:      name,
+		NotifyUrl: notifyUrl,
+		Amount:    amount,
+		Currency:  currency,
+		Status:    StatusCreated,
+		CreatedAt: now,
+		UpdatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+}
+
+// CanTransition 返回订单能否转换到 to 状态
+func (o *Order) CanTransition(to Status) bool {
+	for _, next := range transitions[o.Status] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition 将订单转换到 to 状态并记录历史
+func (o *Order) Transition(to Status, reason string) error {
+	if !o.CanTransition(to) {
+		return ErrInvalidTransition
+	}
+
+	now := time.Now()
+	o.History = append(o.History, Transition{
+		From:   o.Status,
+		To:     to,
+		At:     now,
+		Reason: reason,
+	})
+	o.Status = to
+	o.UpdatedAt = now
+
+	switch to {
+	case StatusPaid:
+		o.PaidAt = now
+	case StatusNotified:
+		o.NotifiedAt = now
+	}
+
+	return nil
+}
+
+// IsOpen 返回订单是否仍在等待支付
+func (o *Order) IsOpen() bool {
+	return o.Status == StatusCreated || o.Status == StatusPending
+}
+
+// IsPaid 返回订单是否已收到付款
+func (o *Order) IsPaid() bool {
+	return o.Status == StatusPaid || o.Status == StatusNotified || o.Status == StatusRefunded
+}
+
+// IsExpired 返回等待支付的订单是否已超时
+func (o *Order) IsExpired(now time.Time) bool {
+	return o.IsOpen() && !o.ExpiresAt.IsZero() && now.After(o.ExpiresAt)
+}
+
+// SameRequest 返回 other 是否与订单来自相同的 Cloudreve 下单请求，金额按下单时的原始金额与货币比较
+func (o *Order) SameRequest(other *Order) bool {
+	amount, currency := o.original()
+	otherAmount, otherCurrency := other.original()
+	return o.OrderNo == other.OrderNo &&
+		o.Name == other.Name &&
+		o.NotifyUrl == other.NotifyUrl &&
+		amount == otherAmount &&
+		strings.EqualFold(currency, otherCurrency)
+}
+
+// original 返回下单时的金额与货币，未记录时（旧版本导入的订单）使用订单金额
+func (o *Order) original() (int, string) {
+	if o.OriginalCurrency == "" {
+		return o.Amount, o.Currency
+	}
+	return o.OriginalAmount, o.OriginalCurrency
+}
+
+// Money 返回以元为单位的订单金额
+func (o *Order) Money() decimal.Decimal {
+	return decimal.NewFromInt(int64(o.Amount)).Div(decimal.NewFromInt(100))
+}
+
+// Refundable 返回订单剩余可退款金额，单位为分
+func (o *Order) Refundable() int {
+	if o.Status != StatusPaid && o.Status != StatusNotified {
+		return 0
+	}
+	return o.Amount - o.RefundedAmount
+}
+
+// AddRefund 记录一次退款，全额退款后订单转为 REFUNDED 状态
+func (o *Order) AddRefund(amount int, reason string) error {
+	if o.Status != StatusPaid && o.Status != StatusNotified {
+		return ErrRefundNotAllowed
+	}
+	if amount <= 0 || amount > o.Refundable() {
+		return ErrRefundAmount
+	}
+
+	o.RefundedAmount += amount
+	o.Refunds = append(o.Refunds, Refund{
+		Amount: amount,
+		At:     time.Now(),
+		Reason: reason,
+	})
+	o.UpdatedAt = time.Now()
+
+	if o.RefundedAmount == o.Amount {
+		return o.Transition(StatusRefunded, reason)
+	}
+	return nil
+}
+
+// clone 深拷贝订单，避免内存缓存中的对象被意外修改
+func (o *Order) clone() *Order {
+	c := *o
+	c.History = append([]Transition(nil), o.History...)
+	c.Refunds = append([]Refund(nil), o.Refunds...)
+	return &c
+}
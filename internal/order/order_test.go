@@ -0,0 +1,108 @@
+package order
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTransition(t *testing.T) {
+	all := []Status{StatusCreated, StatusPending, StatusPaid, StatusNotified, StatusExpired, StatusFailed, StatusRefunded}
+
+	cases := []struct {
+		from    Status
+		allowed []Status
+	}{
+		{StatusCreated, []Status{StatusPending, StatusPaid, StatusExpired, StatusFailed}},
+		{StatusPending, []Status{StatusPaid, StatusExpired, StatusFailed}},
+		// 过期或失败的订单仍可接受迟到的支付成功通知
+		{StatusExpired, []Status{StatusPaid}},
+		{StatusFailed, []Status{StatusPaid}},
+		{StatusPaid, []Status{StatusNotified, StatusRefunded}},
+		{StatusNotified, []Status{StatusRefunded}},
+		{StatusRefunded, nil},
+	}
+	for _, c := range cases {
+		allowed := map[Status]bool{}
+		for _, to := range c.allowed {
+			allowed[to] = true
+		}
+
+		for _, to := range all {
+			o := New("o1", "", "", 100, "CNY", time.Hour)
+			o.Status = c.from
+
+			err := o.Transition(to, "test")
+			if allowed[to] {
+				if err != nil {
+					t.Fatalf("%s -> %s: 应允许转换，返回 %v", c.from, to, err)
+				}
+				if o.Status != to || len(o.History) != 1 || o.History[0].From != c.from || o.History[0].To != to {
+					t.Fatalf("%s -> %s: 转换后状态为 %s，历史为 %+v", c.from, to, o.Status, o.History)
+				}
+				continue
+			}
+			if !errors.Is(err, ErrInvalidTransition) {
+				t.Fatalf("%s -> %s: 应拒绝转换，返回 %v", c.from, to, err)
+			}
+			if o.Status != c.from || len(o.History) != 0 {
+				t.Fatalf("%s -> %s: 拒绝转换后订单被修改为 %s", c.from, to, o.Status)
+			}
+		}
+	}
+}
+
+func TestTransitionTimestamps(t *testing.T) {
+	o := New("o1", "", "", 100, "CNY", time.Hour)
+	if err := o.Transition(StatusPaid, "paid"); err != nil {
+		t.Fatal(err)
+	}
+	if o.PaidAt.IsZero() || !o.NotifiedAt.IsZero() {
+		t.Fatalf("支付后 PaidAt 为 %v，NotifiedAt 为 %v", o.PaidAt, o.NotifiedAt)
+	}
+	if err := o.Transition(StatusNotified, "notified"); err != nil {
+		t.Fatal(err)
+	}
+	if o.NotifiedAt.IsZero() {
+		t.Fatal("通知后未记录 NotifiedAt")
+	}
+}
+
+func TestAddRefund(t *testing.T) {
+	cases := []struct {
+		name     string
+		status   Status
+		refunded int
+		amount   int
+		err      error
+		want     Status
+	}{
+		{"部分退款", StatusPaid, 0, 40, nil, StatusPaid},
+		{"已通知的订单全额退款", StatusNotified, 0, 100, nil, StatusRefunded},
+		{"退还剩余金额", StatusNotified, 60, 40, nil, StatusRefunded},
+		{"超过剩余金额", StatusPaid, 60, 41, ErrRefundAmount, StatusPaid},
+		{"金额为 0", StatusPaid, 0, 0, ErrRefundAmount, StatusPaid},
+		{"金额为负数", StatusPaid, 0, -1, ErrRefundAmount, StatusPaid},
+		{"未支付的订单", StatusPending, 0, 100, ErrRefundNotAllowed, StatusPending},
+		{"已退款的订单", StatusRefunded, 100, 1, ErrRefundNotAllowed, StatusRefunded},
+	}
+	for _, c := range cases {
+		o := New("o1", "", "", 100, "CNY", time.Hour)
+		o.Status = c.status
+		o.RefundedAmount = c.refunded
+
+		err := o.AddRefund(c.amount, "test")
+		if !errors.Is(err, c.err) {
+			t.Fatalf("%s: 返回 %v，应为 %v", c.name, err, c.err)
+		}
+		if o.Status != c.want {
+			t.Fatalf("%s: 退款后状态为 %s，应为 %s", c.name, o.Status, c.want)
+		}
+		if c.err == nil && (o.RefundedAmount != c.refunded+c.amount || len(o.Refunds) != 1) {
+			t.Fatalf("%s: 累计退款 %d，退款记录 %+v", c.name, o.RefundedAmount, o.Refunds)
+		}
+		if c.err != nil && (o.RefundedAmount != c.refunded || len(o.Refunds) != 0) {
+			t.Fatalf("%s: 退款失败后订单被修改", c.name)
+		}
+	}
+}
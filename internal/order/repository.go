@@ -0,0 +1,213 @@
+package order
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/cache"
+	"go.uber.org/fx"
+)
+
+const (
+	// OrderPrefix 订单在缓存中的键前缀
+	OrderPrefix = "order_"
+	// openIndexKey 等待支付及已支付但尚未通知的订单号列表
+	openIndexKey = "order_open_index"
+	// lockPrefix 修改订单时持有的锁的键前缀
+	lockPrefix = "order_lock_"
+
+	// lockTTL 修改订单时锁的最长持有时间
+	lockTTL = 10 * time.Second
+	// lockWait 等待其他进程释放订单锁的最长时间
+	lockWait = 5 * time.Second
+)
+
+func init() {
+	// 旧版本以 gob 编码写入 Redis，保留注册以便读取升级前的订单
+	gob.Register(&Order{})
+	cache.RegisterType("order.Order", &Order{})
+}
+
+func Module() fx.Option {
+	return fx.Module("order", fx.Provide(func(driver cache.Driver) Repository {
+		return NewCacheRepository(driver)
+	}))
+}
+
+// Repository 订单持久化存储
+type Repository interface {
+	// Get 获取订单，不存在时返回 ErrNotFound
+	Get(orderNo string) (*Order, error)
+	// Save 保存订单，已存在时覆盖
+	Save(order *Order) error
+	// Create 订单不存在时保存订单；已存在时不做任何修改，返回已存在的订单与 ErrExists
+	Create(order *Order) (*Order, error)
+	// Update 读取订单并交由 fn 修改，fn 返回 nil 时保存修改后的订单
+	Update(orderNo string, fn func(order *Order) error) (*Order, error)
+	// ListOpen 列出所有等待支付的订单
+	ListOpen() ([]*Order, error)
+	// ListPaid 列出所有已支付但尚未通知 Cloudreve 的订单
+	ListPaid() ([]*Order, error)
+}
+
+// CacheRepository 基于缓存驱动的订单存储，订单不会过期。
+// 使用 Redis 驱动时订单可持久保存，内存驱动在重启后丢失。
+// 修改订单时持有缓存中的锁，多个进程共用同一 Redis 时同样不会相互覆盖
+type CacheRepository struct {
+	driver cache.Driver
+}
+
+func NewCacheRepository(driver cache.Driver) *CacheRepository {
+	return &CacheRepository{
+		driver: driver,
+	}
+}
+
+// Get 获取订单，缓存读取失败时返回读取错误而非 ErrNotFound，调用方可稍后重试
+func (r *CacheRepository) Get(orderNo string) (*Order, error) {
+	value, ok, err := r.driver.Fetch(OrderPrefix + orderNo)
+	if err != nil {
+		return nil, fmt.Errorf("无法读取订单: %w", err)
+	}
+	if !ok {
+		return r.importLegacy(orderNo)
+	}
+
+	order, ok := value.(*Order)
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return order.clone(), nil
+}
+
+// Save 保存订单
+func (r *CacheRepository) Save(order *Order) error {
+	return cache.WithLock(r.driver, lockPrefix+order.OrderNo, lockTTL, lockWait, func() error {
+		return r.put(order)
+	})
+}
+
+// Create 订单不存在时保存订单，通过 SetNX 保证同一订单号只会创建一次
+func (r *CacheRepository) Create(order *Order) (*Order, error) {
+	// 同时检查旧版本缓存中的订单
+	existing, err := r.Get(order.OrderNo)
+	if err == nil {
+		return existing, ErrExists
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	created, err := r.driver.SetNX(OrderPrefix+order.OrderNo, order.clone(), 0)
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		existing, err := r.Get(order.OrderNo)
+		if err != nil {
+			return nil, err
+		}
+		return existing, ErrExists
+	}
+
+	if err := r.index(order); err != nil {
+		return nil, err
+	}
+	return order.clone(), nil
+}
+
+// Update 读取、修改并保存订单
+func (r *CacheRepository) Update(orderNo string, fn func(order *Order) error) (*Order, error) {
+	var order *Order
+	var fnErr error
+	err := cache.WithLock(r.driver, lockPrefix+orderNo, lockTTL, lockWait, func() error {
+		var err error
+		if order, err = r.Get(orderNo); err != nil {
+			return err
+		}
+		if fnErr = fn(order); fnErr != nil {
+			return nil
+		}
+		return r.put(order)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if fnErr != nil {
+		return order, fnErr
+	}
+
+	return order, nil
+}
+
+// ListOpen 列出所有等待支付的订单
+func (r *CacheRepository) ListOpen() ([]*Order, error) {
+	return r.list((*Order).IsOpen), nil
+}
+
+// ListPaid 列出所有已支付但尚未通知 Cloudreve 的订单
+func (r *CacheRepository) ListPaid() ([]*Order, error) {
+	return r.list(func(order *Order) bool {
+		return order.Status == StatusPaid
+	}), nil
+}
+
+// list 返回索引中符合 filter 的订单
+func (r *CacheRepository) list(filter func(order *Order) bool) []*Order {
+	var orders []*Order
+	for _, orderNo := range r.openIndex() {
+		order, err := r.Get(orderNo)
+		if err != nil {
+			continue
+		}
+		if filter(order) {
+			orders = append(orders, order)
+		}
+	}
+	return orders
+}
+
+// put 写入订单并维护订单索引
+func (r *CacheRepository) put(order *Order) error {
+	if err := r.driver.Set(OrderPrefix+order.OrderNo, order.clone(), 0); err != nil {
+		return err
+	}
+	return r.index(order)
+}
+
+// indexed 返回订单是否需要保留在索引中：等待支付的订单需要对账，已支付的订单需要确认已加入通知队列
+func indexed(order *Order) bool {
+	return order.IsOpen() || order.Status == StatusPaid
+}
+
+// index 按订单状态将订单号加入或移出订单索引
+func (r *CacheRepository) index(order *Order) error {
+	if indexed(order) == lo.Contains(r.openIndex(), order.OrderNo) {
+		return nil
+	}
+
+	return cache.Modify(r.driver, openIndexKey, 0, func(value interface{}) (interface{}, error) {
+		index, _ := value.([]string)
+		if indexed(order) {
+			if lo.Contains(index, order.OrderNo) {
+				return index, nil
+			}
+			return append(append([]string(nil), index...), order.OrderNo), nil
+		}
+		return lo.Without(index, order.OrderNo), nil
+	})
+}
+
+func (r *CacheRepository) openIndex() []string {
+	value, ok := r.driver.Get(openIndexKey)
+	if !ok {
+		return nil
+	}
+
+	index, _ := value.([]string)
+	return append([]string(nil), index...)
+}
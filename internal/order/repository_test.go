@@ -0,0 +1,271 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/cache"
+)
+
+// forEachRepository 分别使用内存驱动与 Redis 驱动运行测试，keys 返回缓存中的所有键
+func forEachRepository(t *testing.T, fn func(t *testing.T, repo *CacheRepository, driver cache.Driver, keys func() []string)) {
+	t.Run("memo", func(t *testing.T) {
+		driver := cache.NewMemoStore()
+		fn(t, NewCacheRepository(driver), driver, func() []string {
+			var keys []string
+			driver.Store.Range(func(key, _ interface{}) bool {
+				keys = append(keys, key.(string))
+				return true
+			})
+			return keys
+		})
+	})
+	t.Run("redis", func(t *testing.T) {
+		server := miniredis.RunT(t)
+		driver := testRedisStore(server)
+		fn(t, NewCacheRepository(driver), driver, server.Keys)
+	})
+}
+
+func testRedisStore(server *miniredis.Miniredis) *cache.RedisStore {
+	return cache.NewRedisStore(&cache.RedisOptions{
+		Mode:           cache.RedisStandalone,
+		Addrs:          []string{server.Addr()},
+		MaxIdle:        10,
+		ConnectTimeout: time.Second,
+		ReadTimeout:    time.Second,
+		WriteTimeout:   time.Second,
+	})
+}
+
+func TestRepositorySaveGet(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, repo *CacheRepository, _ cache.Driver, _ func() []string) {
+		if _, err := repo.Get("o1"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("订单不存在时返回 %v，应为 ErrNotFound", err)
+		}
+
+		o := New("o1", "商品", "https://cloudreve.example.com/notify", 100, "CNY", time.Hour)
+		o.History = []Transition{{From: StatusCreated, To: StatusPending, Reason: "pay"}}
+		if err := repo.Save(o); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := repo.Get("o1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.OrderNo != o.OrderNo || got.Name != o.Name || got.NotifyUrl != o.NotifyUrl || got.Amount != o.Amount ||
+			got.Status != o.Status || !reflect.DeepEqual(got.History, o.History) {
+			t.Fatalf("读取的订单为 %+v，应为 %+v", got, o)
+		}
+
+		// 修改保存时传入的订单或读取到的订单，均不应影响已保存的订单
+		o.Status = StatusPaid
+		o.History[0].Reason = "modified"
+		got.Amount = 1
+		got.History = append(got.History, Transition{From: StatusPending, To: StatusPaid})
+		got.Refunds = append(got.Refunds, Refund{Amount: 1})
+
+		again, err := repo.Get("o1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again.Status != StatusCreated || again.Amount != 100 || len(again.History) != 1 ||
+			again.History[0].Reason != "pay" || len(again.Refunds) != 0 {
+			t.Fatalf("已保存的订单被修改为 %+v", again)
+		}
+	})
+}
+
+func TestRepositoryCreate(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, repo *CacheRepository, _ cache.Driver, _ func() []string) {
+		if _, err := repo.Create(New("o1", "first", "", 100, "CNY", time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+
+		existing, err := repo.Create(New("o1", "second", "", 200, "CNY", time.Hour))
+		if !errors.Is(err, ErrExists) || existing == nil || existing.Name != "first" {
+			t.Fatalf("重复创建返回 %+v, %v，应返回已存在的订单与 ErrExists", existing, err)
+		}
+	})
+}
+
+func TestRepositoryIndex(t *testing.T) {
+	forEachRepository(t, func(t *testing.T, repo *CacheRepository, driver cache.Driver, keys func() []string) {
+		const count = 20
+
+		var wg sync.WaitGroup
+		for i := 0; i < count; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if err := repo.Save(New(fmt.Sprintf("o%d", i), "", "", 100, "CNY", time.Hour)); err != nil {
+					t.Error(err)
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		// 并发修改订单状态：偶数订单已支付，能被 3 整除的奇数订单已通知，其余保持等待支付
+		var wantOpen, wantPaid []string
+		for i := 0; i < count; i++ {
+			orderNo := fmt.Sprintf("o%d", i)
+			var to []Status
+			switch {
+			case i%2 == 0:
+				to = []Status{StatusPaid}
+				wantPaid = append(wantPaid, orderNo)
+			case i%3 == 0:
+				to = []Status{StatusPaid, StatusNotified}
+			default:
+				wantOpen = append(wantOpen, orderNo)
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := repo.Update(orderNo, func(order *Order) error {
+					for _, status := range to {
+						if err := order.Transition(status, "test"); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+				if err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		orderNos := func(orders []*Order) []string {
+			var res []string
+			for _, order := range orders {
+				res = append(res, order.OrderNo)
+			}
+			sort.Strings(res)
+			return res
+		}
+		sort.Strings(wantOpen)
+		sort.Strings(wantPaid)
+
+		open, _ := repo.ListOpen()
+		if got := orderNos(open); !reflect.DeepEqual(got, wantOpen) {
+			t.Fatalf("等待支付的订单为 %v，应为 %v", got, wantOpen)
+		}
+		paid, _ := repo.ListPaid()
+		if got := orderNos(paid); !reflect.DeepEqual(got, wantPaid) {
+			t.Fatalf("已支付的订单为 %v，应为 %v", got, wantPaid)
+		}
+
+		// 所有订单共用一个索引键，已通知的订单已移出索引
+		index, _ := driver.Get(openIndexKey)
+		got, _ := index.([]string)
+		got = append([]string(nil), got...)
+		sort.Strings(got)
+		want := append(append([]string(nil), wantOpen...), wantPaid...)
+		sort.Strings(want)
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("订单索引为 %v，应为 %v", got, want)
+		}
+		for _, key := range keys() {
+			if key != openIndexKey && !strings.HasPrefix(key, OrderPrefix+"o") {
+				t.Fatalf("缓存中存在多余的键 %s", key)
+			}
+		}
+	})
+}
+
+func TestImportLegacy(t *testing.T) {
+	cases := []struct {
+		name   string
+		legacy map[string]interface{}
+		status Status
+		err    error
+	}{
+		{
+			name:   "未完成的订单",
+			legacy: map[string]interface{}{legacySessionPrefix + "o1": &legacyPurchaseRequest{Name: "商品", OrderNo: "o1", NotifyUrl: "https://cloudreve.example.com/notify", Amount: 100, Currency: "CNY"}},
+			status: StatusCreated,
+		},
+		{
+			name:   "已支付标记",
+			legacy: map[string]interface{}{legacyPaidPrefix + "o1": true},
+			status: StatusNotified,
+		},
+		{
+			name:   "未支付标记",
+			legacy: map[string]interface{}{legacyPaidPrefix + "o1": false},
+			err:    ErrNotFound,
+		},
+		{
+			name:   "类型错误的旧版本订单",
+			legacy: map[string]interface{}{legacySessionPrefix + "o1": "o1"},
+			err:    ErrNotFound,
+		},
+		{
+			name: "不存在",
+			err:  ErrNotFound,
+		},
+	}
+	for _, c := range cases {
+		forEachRepository(t, func(t *testing.T, repo *CacheRepository, driver cache.Driver, _ func() []string) {
+			for key, value := range c.legacy {
+				if err := driver.Set(key, value, 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, err := repo.Get("o1")
+			if !errors.Is(err, c.err) {
+				t.Fatalf("%s: 返回 %v，应为 %v", c.name, err, c.err)
+			}
+			if c.err != nil {
+				return
+			}
+			if got.Status != c.status {
+				t.Fatalf("%s: 导入的订单状态为 %s，应为 %s", c.name, got.Status, c.status)
+			}
+			if req, ok := c.legacy[legacySessionPrefix+"o1"].(*legacyPurchaseRequest); ok {
+				if got.Name != req.Name || got.NotifyUrl != req.NotifyUrl || got.Amount != req.Amount || got.Currency != req.Currency {
+					t.Fatalf("%s: 导入的订单为 %+v", c.name, got)
+				}
+				if _, ok := driver.Get(legacySessionPrefix + "o1"); ok {
+					t.Fatalf("%s: 导入后未删除旧版本订单", c.name)
+				}
+			}
+
+			// 导入后保存为新版本订单，等待支付的订单加入索引
+			if _, ok := driver.Get(OrderPrefix + "o1"); !ok {
+				t.Fatalf("%s: 导入后未保存订单", c.name)
+			}
+			open, _ := repo.ListOpen()
+			if (len(open) == 1) != got.IsOpen() {
+				t.Fatalf("%s: 等待支付的订单为 %d 个", c.name, len(open))
+			}
+		})
+	}
+}
+
+func TestRepositoryGetUnavailable(t *testing.T) {
+	server := miniredis.RunT(t)
+	repo := NewCacheRepository(testRedisStore(server))
+
+	if _, err := repo.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("订单不存在时返回 %v，应为 ErrNotFound", err)
+	}
+
+	// Redis 不可用时订单可能存在，不应返回 ErrNotFound，否则通知会被放弃
+	server.Close()
+	if _, err := repo.Get("missing"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Fatalf("Redis 不可用时返回 %v，应为读取错误", err)
+	}
+}
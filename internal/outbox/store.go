@@ -0,0 +1,190 @@
+package outbox
+
+import (
+	"encoding/gob"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/cache"
+)
+
+const (
+	// EntryPrefix 待通知记录在缓存中的键前缀
+	EntryPrefix = "outbox_"
+	// pendingIndexKey 待投递记录的订单号列表
+	pendingIndexKey = "outbox_pending_index"
+	// lockPrefix 投递通知时持有的锁的键前缀
+	lockPrefix = "outbox_lock_"
+	// recordLockPrefix 添加、删除记录及修改索引时持有的锁的键前缀，与投递锁分开，
+	// 添加记录不需要等待耗时的投递完成
+	recordLockPrefix = "outbox_record_lock_"
+
+	// 修改记录时锁的最长持有时间，以及等待其他进程释放锁的最长时间
+	recordLockTTL  = 10 * time.Second
+	recordLockWait = 10 * time.Second
+)
+
+// State 通知记录状态
+type State string
+
+const (
+	// StatePending 等待投递
+	StatePending State = "pending"
+	// StateDead 超过重试期限，不再投递
+	StateDead State = "dead"
+)
+
+// Entry 一条待发送给 Cloudreve 的支付成功通知
+type Entry struct {
+	OrderNo       string
+	State         State
+	Attempts      int
+	LastError     string
+	CreatedAt     time.Time
+	NextAttemptAt time.Time
+}
+
+func init() {
+	gob.Register(&Entry{})
+	cache.RegisterType("outbox.Entry", &Entry{})
+}
+
+// Store 基于缓存驱动的通知发件箱，记录不会过期。
+// 记录与索引通过比较并交换修改，多个进程共用同一 Redis 时不会丢失记录
+type Store struct {
+	driver cache.Driver
+}
+
+func NewStore(driver cache.Driver) *Store {
+	return &Store{
+		driver: driver,
+	}
+}
+
+// Enqueue 为订单添加一条待投递通知，已存在待投递记录时不做修改。
+// 持有订单的记录锁，避免与 Ack、Bury 交错导致待投递记录不在索引中；投递进行中时不会等待，
+// 正在投递的记录成功后订单即为已通知，无需再次投递。
+// 先写入索引再写入记录，进程在两次写入之间退出时只会在索引中留下空的订单号
+func (s *Store) Enqueue(orderNo string) error {
+	return s.withRecordLock(orderNo, func() error {
+		err := cache.Modify(s.driver, pendingIndexKey, 0, func(value interface{}) (interface{}, error) {
+			index, _ := value.([]string)
+			if lo.Contains(index, orderNo) {
+				return index, nil
+			}
+			return append(append([]string(nil), index...), orderNo), nil
+		})
+		if err != nil {
+			return err
+		}
+
+		return cache.Modify(s.driver, EntryPrefix+orderNo, 0, func(value interface{}) (interface{}, error) {
+			if entry, ok := value.(*Entry); ok && entry.State == StatePending {
+				return entry, nil
+			}
+
+			now := time.Now()
+			return &Entry{
+				OrderNo:       orderNo,
+				State:         StatePending,
+				CreatedAt:     now,
+				NextAttemptAt: now,
+			}, nil
+		})
+	})
+}
+
+// Get 获取订单的通知记录
+func (s *Store) Get(orderNo string) (*Entry, bool) {
+	return s.get(orderNo)
+}
+
+// Lock 获取订单的投递锁，同一时刻只有一个进程投递同一订单的通知；锁已被占用时返回 cache.ErrLocked
+func (s *Store) Lock(orderNo string, ttl time.Duration) (string, error) {
+	return s.driver.Lock(lockPrefix+orderNo, ttl)
+}
+
+// Unlock 释放订单的投递锁
+func (s *Store) Unlock(orderNo, token string) error {
+	return s.driver.Unlock(lockPrefix+orderNo, token)
+}
+
+// Due 返回所有已到投递时间的记录
+func (s *Store) Due(now time.Time) []*Entry {
+	var due []*Entry
+	for _, orderNo := range s.pendingIndex() {
+		entry, ok := s.get(orderNo)
+		if ok && entry.State == StatePending && !entry.NextAttemptAt.After(now) {
+			due = append(due, entry)
+		}
+	}
+	return due
+}
+
+// Ack 投递成功，删除记录。调用方需持有订单的投递锁
+func (s *Store) Ack(orderNo string) error {
+	return s.withRecordLock(orderNo, func() error {
+		if err := s.driver.Delete([]string{orderNo}, EntryPrefix); err != nil {
+			return err
+		}
+		return s.removeFromIndex(orderNo)
+	})
+}
+
+// Retry 投递失败，记录错误并安排在 next 时刻重试。调用方需持有订单的投递锁
+func (s *Store) Retry(entry *Entry, cause error, next time.Time) error {
+	entry.Attempts++
+	entry.LastError = cause.Error()
+	entry.NextAttemptAt = next
+	return s.driver.Set(EntryPrefix+entry.OrderNo, entry, 0)
+}
+
+// Bury 将记录转为死信，不再投递。调用方需持有订单的投递锁
+func (s *Store) Bury(entry *Entry, cause error) error {
+	entry.Attempts++
+	entry.LastError = cause.Error()
+	entry.State = StateDead
+	return s.withRecordLock(entry.OrderNo, func() error {
+		if err := s.driver.Set(EntryPrefix+entry.OrderNo, entry, 0); err != nil {
+			return err
+		}
+		return s.removeFromIndex(entry.OrderNo)
+	})
+}
+
+// withRecordLock 持有订单的记录锁执行 fn
+func (s *Store) withRecordLock(orderNo string, fn func() error) error {
+	return cache.WithLock(s.driver, recordLockPrefix+orderNo, recordLockTTL, recordLockWait, fn)
+}
+
+func (s *Store) get(orderNo string) (*Entry, bool) {
+	value, ok := s.driver.Get(EntryPrefix + orderNo)
+	if !ok {
+		return nil, false
+	}
+
+	entry, ok := value.(*Entry)
+	if !ok {
+		return nil, false
+	}
+
+	copied := *entry
+	return &copied, true
+}
+
+func (s *Store) pendingIndex() []string {
+	value, ok := s.driver.Get(pendingIndexKey)
+	if !ok {
+		return nil
+	}
+
+	index, _ := value.([]string)
+	return append([]string(nil), index...)
+}
+
+func (s *Store) removeFromIndex(orderNo string) error {
+	return cache.Modify(s.driver, pendingIndexKey, 0, func(value interface{}) (interface{}, error) {
+		index, _ := value.([]string)
+		return lo.Without(index, orderNo), nil
+	})
+}
@@ -0,0 +1,219 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/cache"
+	"go.uber.org/fx"
+)
+
+func Module() fx.Option {
+	return fx.Module("outbox",
+		fx.Provide(func(driver cache.Driver) *Store {
+			return NewStore(driver)
+		}),
+		fx.Provide(NewWorker),
+		fx.Invoke(func(lc fx.Lifecycle, w *Worker) {
+			lc.Append(fx.Hook{
+				OnStart: w.Start,
+				OnStop:  w.Stop,
+			})
+		}),
+	)
+}
+
+// deliveryLockTTL 投递一条通知时锁的最长持有时间，进程异常退出时锁在此之后自动释放
+const deliveryLockTTL = 5 * time.Minute
+
+// Deliverer 负责将一条通知实际发送给 Cloudreve，重试也无法成功时返回 PermanentError
+type Deliverer interface {
+	Deliver(ctx context.Context, entry *Entry) error
+}
+
+// PermanentError 重试也无法成功的投递错误，如通知地址不被允许、订单不存在或订单状态无效，
+// 记录会直接转为死信
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent 将 err 标记为无需重试的错误
+func Permanent(err error) error {
+	return &PermanentError{Err: err}
+}
+
+// Worker 后台投递发件箱中的通知，失败时按指数退避加随机抖动重试，
+// 超过 DeadLetterAfter 仍未成功的记录转为死信
+type Worker struct {
+	store     *Store
+	deliverer Deliverer
+
+	PollInterval    time.Duration
+	RetryBase       time.Duration
+	RetryMax        time.Duration
+	DeadLetterAfter time.Duration
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewWorker(conf *appconf.Config, store *Store, deliverer Deliverer) *Worker {
+	return &Worker{
+		store:           store,
+		deliverer:       deliverer,
+		PollInterval:    conf.NotifyPollInterval,
+		RetryBase:       conf.NotifyRetryBase,
+		RetryMax:        conf.NotifyRetryMax,
+		DeadLetterAfter: conf.NotifyDeadLetterAfter,
+	}
+}
+
+// Start 启动后台投递，轮询间隔与重试间隔需大于 0
+func (w *Worker) Start(ctx context.Context) error {
+	if w.PollInterval <= 0 || w.RetryBase <= 0 || w.RetryMax <= 0 {
+		return fmt.Errorf("通知投递的轮询间隔与重试间隔必须大于 0: poll=%s, base=%s, max=%s", w.PollInterval, w.RetryBase, w.RetryMax)
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		ticker := time.NewTicker(w.PollInterval)
+		defer ticker.Stop()
+
+		for {
+			w.Drain(runCtx)
+
+			select {
+			case <-runCtx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	logrus.Infoln("通知投递任务已启动")
+	return nil
+}
+
+// Stop 停止后台投递，等待正在进行的投递结束
+func (w *Worker) Stop(ctx context.Context) error {
+	if w.cancel != nil {
+		w.cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Drain 投递所有已到期的通知
+func (w *Worker) Drain(ctx context.Context) {
+	for _, entry := range w.store.Due(time.Now()) {
+		if ctx.Err() != nil {
+			return
+		}
+		w.process(ctx, entry)
+	}
+}
+
+func (w *Worker) process(ctx context.Context, entry *Entry) {
+	log := logrus.WithField("order_no", entry.OrderNo)
+
+	// 多个进程共用同一 Redis 时，同一订单的通知只由持有锁的进程投递
+	token, err := w.store.Lock(entry.OrderNo, deliveryLockTTL)
+	if err != nil {
+		if !errors.Is(err, cache.ErrLocked) {
+			log.WithError(err).Errorln("无法获取通知投递锁")
+		}
+		return
+	}
+	defer func() {
+		if err := w.store.Unlock(entry.OrderNo, token); err != nil {
+			log.WithError(err).Warningln("无法释放通知投递锁")
+		}
+	}()
+
+	// 获取锁期间记录可能已被其他进程投递或重新安排
+	entry, ok := w.store.Get(entry.OrderNo)
+	if !ok || entry.State != StatePending || entry.NextAttemptAt.After(time.Now()) {
+		return
+	}
+	log = log.WithField("attempts", entry.Attempts+1)
+
+	err = w.deliverer.Deliver(ctx, entry)
+	if err == nil {
+		log.Infoln("通知成功")
+		if err := w.store.Ack(entry.OrderNo); err != nil {
+			log.WithError(err).Errorln("无法删除已投递的通知")
+		}
+		return
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	var permanent *PermanentError
+	if errors.As(err, &permanent) {
+		log.WithError(err).Errorln("通知无法投递，已转为死信")
+		if err := w.store.Bury(entry, err); err != nil {
+			log.WithError(err).Errorln("无法将通知转为死信")
+		}
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(entry.CreatedAt) >= w.DeadLetterAfter {
+		log.WithError(err).Errorln("通知持续失败，已转为死信")
+		if err := w.store.Bury(entry, err); err != nil {
+			log.WithError(err).Errorln("无法将通知转为死信")
+		}
+		return
+	}
+
+	next := now.Add(w.backoff(entry.Attempts + 1))
+	log.WithError(err).WithField("next", next).Warningln("通知失败，稍后重试")
+	if err := w.store.Retry(entry, err, next); err != nil {
+		log.WithError(err).Errorln("无法更新通知重试时间")
+	}
+}
+
+// backoff 返回第 attempts 次失败后的等待时间，在指数退避值的 [1/2, 1] 区间内随机
+func (w *Worker) backoff(attempts int) time.Duration {
+	delay := w.RetryMax
+	if attempts < 32 {
+		if d := w.RetryBase << (attempts - 1); d > 0 && d < w.RetryMax {
+			delay = d
+		}
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
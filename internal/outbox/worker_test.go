@@ -0,0 +1,156 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/topjohncian/cloudreve-pro-epay/internal/cache"
+)
+
+// fakeDeliverer 按订单号返回投递结果
+type fakeDeliverer struct {
+	errs  map[string]error
+	calls map[string]int
+}
+
+func (d *fakeDeliverer) Deliver(ctx context.Context, entry *Entry) error {
+	d.calls[entry.OrderNo]++
+	return d.errs[entry.OrderNo]
+}
+
+func newTestWorker(errs map[string]error) (*Worker, *fakeDeliverer) {
+	d := &fakeDeliverer{errs: errs, calls: map[string]int{}}
+	return &Worker{
+		store:           NewStore(cache.NewMemoStore()),
+		deliverer:       d,
+		PollInterval:    time.Second,
+		RetryBase:       time.Minute,
+		RetryMax:        time.Hour,
+		DeadLetterAfter: 72 * time.Hour,
+	}, d
+}
+
+func TestWorkerDrain(t *testing.T) {
+	w, d := newTestWorker(map[string]error{
+		"transient": errors.New("http code: 502"),
+		"permanent": Permanent(errors.New("订单不存在")),
+	})
+	for _, orderNo := range []string{"ok", "transient", "permanent"} {
+		if err := w.store.Enqueue(orderNo); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w.Drain(context.Background())
+
+	if _, ok := w.store.Get("ok"); ok {
+		t.Fatal("投递成功的记录应被删除")
+	}
+
+	entry, ok := w.store.Get("transient")
+	if !ok || entry.State != StatePending || entry.Attempts != 1 || !entry.NextAttemptAt.After(time.Now()) {
+		t.Fatalf("暂时失败的记录应稍后重试: %+v", entry)
+	}
+
+	// 无需重试的错误在第一次失败时即转为死信
+	entry, ok = w.store.Get("permanent")
+	if !ok || entry.State != StateDead || entry.Attempts != 1 {
+		t.Fatalf("无法投递的记录应转为死信: %+v", entry)
+	}
+
+	// 超过期限仍失败的记录转为死信
+	w.DeadLetterAfter = 0
+	entry, _ = w.store.Get("transient")
+	if err := w.store.Retry(entry, errors.New("http code: 502"), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	w.Drain(context.Background())
+	if entry, _ := w.store.Get("transient"); entry.State != StateDead {
+		t.Fatalf("超过期限的记录应转为死信: %+v", entry)
+	}
+
+	if d.calls["ok"] != 1 || d.calls["permanent"] != 1 || d.calls["transient"] != 2 {
+		t.Fatalf("投递次数为 %v", d.calls)
+	}
+	if due := w.store.Due(time.Now().Add(time.Hour)); len(due) != 0 {
+		t.Fatalf("死信仍在待投递列表中: %d 条", len(due))
+	}
+}
+
+func TestWorkerStartInvalidInterval(t *testing.T) {
+	for _, configure := range []func(w *Worker){
+		func(w *Worker) { w.PollInterval = 0 },
+		func(w *Worker) { w.RetryBase = -time.Second },
+		func(w *Worker) { w.RetryMax = 0 },
+	} {
+		w, _ := newTestWorker(nil)
+		configure(w)
+		if err := w.Start(context.Background()); err == nil {
+			_ = w.Stop(context.Background())
+			t.Fatalf("轮询间隔 %s、重试间隔 %s/%s 时应拒绝启动", w.PollInterval, w.RetryBase, w.RetryMax)
+		}
+	}
+}
+
+func TestStoreEnqueue(t *testing.T) {
+	driver := cache.NewMemoStore()
+	s := NewStore(driver)
+
+	if err := s.Enqueue("o1"); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := s.Get("o1")
+	if err := s.Enqueue("o1"); err != nil {
+		t.Fatal(err)
+	}
+	if entry, _ := s.Get("o1"); !entry.CreatedAt.Equal(first.CreatedAt) {
+		t.Fatal("已存在待投递记录时不应修改")
+	}
+	if index := s.pendingIndex(); len(index) != 1 || index[0] != "o1" {
+		t.Fatalf("待投递列表为 %v", index)
+	}
+
+	// 投递进行中时不等待投递锁，重复的支付通知可以立即返回
+	token, err := s.Lock("o1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := s.Enqueue("o1"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("投递进行中时 Enqueue 等待了 %s", elapsed)
+	}
+	if err := s.Unlock("o1", token); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ack 修改记录期间添加的记录等待其完成，不会与 Ack 交错
+	token, err = driver.Lock(recordLockPrefix+"o1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Enqueue("o1")
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := driver.Delete([]string{"o1"}, EntryPrefix); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.removeFromIndex("o1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := driver.Unlock(recordLockPrefix+"o1", token); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if due := s.Due(time.Now()); len(due) != 1 || due[0].OrderNo != "o1" {
+		t.Fatalf("Ack 之后添加的记录不在待投递列表中: %v", s.pendingIndex())
+	}
+}
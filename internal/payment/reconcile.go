@@ -0,0 +1,210 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/provider"
+)
+
+// Reconcile 向支付网关主动查询订单，网关已收款时按正常流程确认支付
+func (s *Service) Reconcile(ctx context.Context, orderNo string) (Outcome, error) {
+	p, orderInfo, err := s.providerFor(orderNo)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := p.Query(ctx, orderInfo)
+	if err != nil {
+		return 0, err
+	}
+	if res.OrderNo != orderNo {
+		logrus.WithField("order_no", orderNo).WithField("reason", RejectOrderMismatch).WithField("out_trade_no", res.OrderNo).Warningln("主动查询返回的订单号不符，拒绝确认支付")
+		return 0, &RejectError{Reason: RejectOrderMismatch}
+	}
+
+	if !res.Paid {
+		return OutcomeIgnored, nil
+	}
+
+	logrus.WithField("order_no", orderNo).WithField("trade_no", res.TradeNo).Infoln("主动查询发现订单已支付")
+	return s.Confirm(&Payment{
+		OrderNo:  orderNo,
+		TradeNo:  res.TradeNo,
+		Type:     res.Method,
+		Money:    res.Money,
+		Merchant: res.Merchant,
+	})
+}
+
+// Close 订单过期后关闭支付网关中的订单，避免用户继续支付。支付渠道不支持关闭订单时不做处理
+func (s *Service) Close(ctx context.Context, orderInfo *order.Order) error {
+	p, err := s.Providers.Get(orderInfo.Provider)
+	if err != nil {
+		return err
+	}
+
+	closer, ok := p.(provider.Closer)
+	if !ok {
+		return nil
+	}
+	if err := closer.Close(ctx, orderInfo); err != nil && !errors.Is(err, provider.ErrPaymentNotFound) {
+		return err
+	}
+	return nil
+}
+
+// Reconciler 定期向易支付查询等待支付的订单，补偿丢失的异步通知，
+// 将超时未支付的订单标记为过期，并将未能加入通知队列的已支付订单重新加入队列
+type Reconciler struct {
+	service *Service
+	orders  order.Repository
+
+	Interval time.Duration
+	// 订单进入等待支付状态超过 MinAge 后才会被查询，避免与异步通知竞争
+	MinAge time.Duration
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewReconciler(conf *appconf.Config, service *Service, orders order.Repository) *Reconciler {
+	return &Reconciler{
+		service:  service,
+		orders:   orders,
+		Interval: conf.ReconcileInterval,
+		MinAge:   conf.ReconcileMinAge,
+	}
+}
+
+// Start 启动定期对账，Interval 不大于 0 时不启动
+func (r *Reconciler) Start(ctx context.Context) error {
+	if r.Interval <= 0 {
+		return nil
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(r.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-runCtx.Done():
+				return
+			case <-ticker.C:
+				r.Sweep(runCtx)
+			}
+		}
+	}()
+
+	logrus.Infoln("订单对账任务已启动")
+	return nil
+}
+
+// Stop 停止定期对账
+func (r *Reconciler) Stop(ctx context.Context) error {
+	if r.cancel != nil {
+		r.cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		r.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Sweep 对所有等待支付的订单进行一次对账，然后检查已支付订单的通知队列
+func (r *Reconciler) Sweep(ctx context.Context) {
+	defer r.requeue(ctx)
+
+	orders, err := r.orders.ListOpen()
+	if err != nil {
+		logrus.WithError(err).Warningln("无法列出等待支付的订单")
+		return
+	}
+
+	now := time.Now()
+	for _, o := range orders {
+		if ctx.Err() != nil {
+			return
+		}
+
+		log := logrus.WithField("order_no", o.OrderNo)
+		if o.Status == order.StatusPending && now.Sub(o.UpdatedAt) >= r.MinAge {
+			outcome, err := r.service.Reconcile(ctx, o.OrderNo)
+			if err == nil && outcome != OutcomeIgnored {
+				continue
+			}
+			// 支付网关中没有该订单通常意味着用户尚未下单，此时仍需检查订单是否过期
+			if err != nil && !errors.Is(err, provider.ErrPaymentNotFound) {
+				log.WithError(err).Warningln("订单对账失败")
+				continue
+			}
+		}
+
+		if o.IsExpired(now) {
+			updated, err := r.orders.Update(o.OrderNo, func(o *order.Order) error {
+				if !o.IsExpired(time.Now()) {
+					return nil
+				}
+				return o.Transition(order.StatusExpired, "支付超时")
+			})
+			if err != nil {
+				log.WithError(err).Warningln("无法将订单标记为过期")
+				continue
+			}
+
+			// 只有已引导至支付网关的订单才需要关闭
+			if o.Status == order.StatusPending && updated.Status == order.StatusExpired {
+				if err := r.service.Close(ctx, updated); err != nil {
+					log.WithError(err).Warningln("无法关闭支付网关中的过期订单")
+				}
+			}
+		}
+	}
+}
+
+// requeue 将已支付但没有通知记录的订单重新加入通知队列。确认支付后加入队列失败，
+// 且支付网关不再重发通知时，订单由此补发通知；已转为死信的记录不会重新加入
+func (r *Reconciler) requeue(ctx context.Context) {
+	orders, err := r.orders.ListPaid()
+	if err != nil {
+		logrus.WithError(err).Warningln("无法列出已支付的订单")
+		return
+	}
+
+	for _, o := range orders {
+		if ctx.Err() != nil {
+			return
+		}
+		if _, ok := r.service.Outbox.Get(o.OrderNo); ok {
+			continue
+		}
+
+		log := logrus.WithField("order_no", o.OrderNo)
+		if err := r.service.Outbox.Enqueue(o.OrderNo); err != nil {
+			log.WithError(err).Warningln("无法将已支付的订单加入通知队列")
+			continue
+		}
+		log.Warningln("已支付的订单不在通知队列中，已重新加入")
+	}
+}
@@ -0,0 +1,159 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/topjohncian/cloudreve-pro-epay/internal/cache"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/outbox"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/provider"
+)
+
+// pendingOrder 保存一个金额为 1 元、等待支付的订单
+func pendingOrder(t *testing.T, s *Service, orderNo string, ttl time.Duration) {
+	t.Helper()
+
+	orderInfo := order.New(orderNo, "测试商品", "", 100, "CNY", ttl)
+	if err := orderInfo.Transition(order.StatusPending, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Orders.Save(orderInfo); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	p := &fakeProvider{results: map[string]*provider.Result{
+		"paid":     {OrderNo: "paid", TradeNo: "Tpaid", Money: "1.00", Paid: true},
+		"unpaid":   {OrderNo: "unpaid", TradeNo: "Tunpaid", Money: "1.00"},
+		"mismatch": {OrderNo: "other", TradeNo: "Tother", Money: "1.00", Paid: true},
+		"amount":   {OrderNo: "amount", TradeNo: "Tamount", Money: "0.99", Paid: true},
+	}}
+	s := newTestService(t, cache.NewMemoStore(), p)
+	for _, orderNo := range []string{"paid", "unpaid", "mismatch", "amount", "missing"} {
+		pendingOrder(t, s, orderNo, time.Hour)
+	}
+
+	cases := []struct {
+		orderNo string
+		outcome Outcome
+		reason  string
+		err     error
+	}{
+		{"paid", OutcomeConfirmed, "", nil},
+		{"paid", OutcomeDuplicate, "", nil},
+		{"unpaid", OutcomeIgnored, "", nil},
+		{"mismatch", 0, RejectOrderMismatch, nil},
+		{"amount", 0, RejectAmountMismatch, nil},
+		{"missing", 0, "", provider.ErrPaymentNotFound},
+		{"unknown", 0, "", order.ErrNotFound},
+	}
+	for _, c := range cases {
+		outcome, err := s.Reconcile(context.Background(), c.orderNo)
+		switch {
+		case c.reason != "":
+			if RejectReason(err) != c.reason {
+				t.Fatalf("%s: 返回 %v，拒绝原因应为 %s", c.orderNo, err, c.reason)
+			}
+		case c.err != nil:
+			if !errors.Is(err, c.err) {
+				t.Fatalf("%s: 返回 %v，应为 %v", c.orderNo, err, c.err)
+			}
+		case err != nil || outcome != c.outcome:
+			t.Fatalf("%s: 返回 %v, %v，应为 %v", c.orderNo, outcome, err, c.outcome)
+		}
+	}
+
+	orderInfo, _ := s.Orders.Get("paid")
+	if orderInfo.Status != order.StatusPaid || orderInfo.TradeNo != "Tpaid" {
+		t.Fatalf("主动查询确认后订单为 %+v", orderInfo)
+	}
+	for _, orderNo := range []string{"unpaid", "mismatch", "amount"} {
+		if orderInfo, _ := s.Orders.Get(orderNo); orderInfo.Status != order.StatusPending {
+			t.Fatalf("%s 的状态被修改为 %s", orderNo, orderInfo.Status)
+		}
+	}
+}
+
+func TestSweep(t *testing.T) {
+	p := &fakeProvider{results: map[string]*provider.Result{
+		"paid":    {OrderNo: "paid", TradeNo: "Tpaid", Money: "1.00", Paid: true},
+		"unpaid":  {OrderNo: "unpaid", TradeNo: "Tunpaid", Money: "1.00"},
+		"expired": {OrderNo: "expired", TradeNo: "Texpired", Money: "1.00"},
+	}}
+	s := newTestService(t, cache.NewMemoStore(), p)
+	pendingOrder(t, s, "paid", time.Hour)
+
+	// 等待支付未超过 MinAge 的订单不会被查询
+	r := &Reconciler{service: s, orders: s.Orders, MinAge: time.Hour}
+	r.Sweep(context.Background())
+	if orderInfo, _ := s.Orders.Get("paid"); orderInfo.Status != order.StatusPending {
+		t.Fatalf("未超过 MinAge 的订单被查询，状态为 %s", orderInfo.Status)
+	}
+
+	pendingOrder(t, s, "unpaid", time.Hour)
+	pendingOrder(t, s, "expired", -time.Minute)
+	// 支付网关中没有该订单时仍需检查是否过期
+	pendingOrder(t, s, "missing", -time.Minute)
+	r.MinAge = 0
+	r.Sweep(context.Background())
+
+	want := map[string]order.Status{
+		"paid":    order.StatusPaid,
+		"unpaid":  order.StatusPending,
+		"expired": order.StatusExpired,
+		"missing": order.StatusExpired,
+	}
+	for orderNo, status := range want {
+		if orderInfo, _ := s.Orders.Get(orderNo); orderInfo.Status != status {
+			t.Fatalf("对账后 %s 的状态为 %s，应为 %s", orderNo, orderInfo.Status, status)
+		}
+	}
+
+	// 查询支付网关失败时不将订单标记为过期，等待下次对账
+	pendingOrder(t, s, "failed", -time.Minute)
+	p.err = errors.New("api.php 不可用")
+	r.Sweep(context.Background())
+	if orderInfo, _ := s.Orders.Get("failed"); orderInfo.Status != order.StatusPending {
+		t.Fatalf("查询失败的订单状态被修改为 %s", orderInfo.Status)
+	}
+}
+
+func TestSweepRequeue(t *testing.T) {
+	s := newTestService(t, cache.NewMemoStore(), &fakeProvider{})
+	r := &Reconciler{service: s, orders: s.Orders}
+
+	// 确认支付后未能加入通知队列的订单
+	paidOrder(t, s, "lost", 100)
+	// 已转为死信的订单不会重新加入
+	paidOrder(t, s, "dead", 100)
+	if err := s.Outbox.Enqueue("dead"); err != nil {
+		t.Fatal(err)
+	}
+	entry, _ := s.Outbox.Get("dead")
+	if err := s.Outbox.Bury(entry, errors.New("通知地址不被允许")); err != nil {
+		t.Fatal(err)
+	}
+	// 已通知的订单不再检查
+	paidOrder(t, s, "notified", 100)
+	if _, err := s.Orders.Update("notified", func(o *order.Order) error {
+		return o.Transition(order.StatusNotified, "")
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Sweep(context.Background())
+
+	if entry, ok := s.Outbox.Get("lost"); !ok || entry.State != outbox.StatePending {
+		t.Fatalf("已支付的订单未重新加入通知队列: %+v", entry)
+	}
+	if entry, _ := s.Outbox.Get("dead"); entry.State != outbox.StateDead {
+		t.Fatalf("死信被重新加入通知队列: %+v", entry)
+	}
+	if _, ok := s.Outbox.Get("notified"); ok {
+		t.Fatal("已通知的订单被加入通知队列")
+	}
+}
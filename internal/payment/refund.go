@@ -0,0 +1,160 @@
+package payment
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/cache"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+)
+
+// RefundSignatureHeader 退款 webhook 请求体的 HMAC-SHA256 签名（十六进制），密钥为 Cloudreve 通信密钥
+const RefundSignatureHeader = "X-Cr-Epay-Signature"
+
+const (
+	// refundLockPrefix 退款时持有的锁的键前缀。与修改订单的锁分开，请求支付网关期间不阻塞订单的其他修改
+	refundLockPrefix = "order_refund_lock_"
+	// refundTimeout 请求支付网关退款的最长时间
+	refundTimeout = time.Minute
+	// refundLockTTL 退款锁的最长持有时间，需大于 refundTimeout
+	refundLockTTL = 2 * refundTimeout
+	// refundLockWait 等待同一订单的其他退款完成的最长时间
+	refundLockWait = 5 * time.Second
+)
+
+var (
+	ErrTradeNoMissing   = errors.New("订单缺少支付网关订单号，无法退款")
+	ErrRefundInProgress = errors.New("该订单正在退款，请稍后重试")
+)
+
+// RefundEvent 退款 webhook 的请求体
+type RefundEvent struct {
+	OrderNo string `json:"order_no"`
+	TradeNo string `json:"trade_no"`
+	// 本次退款金额，单位为分
+	Amount int `json:"amount"`
+	// 累计退款金额，单位为分
+	RefundedAmount int    `json:"refunded_amount"`
+	Status         string `json:"status"`
+	Reason         string `json:"reason,omitempty"`
+}
+
+// Refund 通过订单的支付渠道退款并记录到订单，amount 为退款金额（单位为分），为 0 时退还剩余全部金额。
+// 从读取订单到记录退款期间持有缓存中的锁，服务进程与命令行等多个进程不会对同一订单重复退款
+func (s *Service) Refund(ctx context.Context, orderNo string, amount int, reason string) (*order.Order, error) {
+	var (
+		orderInfo *order.Order
+		locked    bool
+	)
+	err := cache.WithLock(s.Cache, refundLockPrefix+orderNo, refundLockTTL, refundLockWait, func() error {
+		locked = true
+
+		var err error
+		orderInfo, amount, err = s.refund(ctx, orderNo, amount, reason)
+		return err
+	})
+	if !locked && errors.Is(err, cache.ErrLocked) {
+		return nil, ErrRefundInProgress
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifyRefund(ctx, &RefundEvent{
+		OrderNo:        orderInfo.OrderNo,
+		TradeNo:        orderInfo.TradeNo,
+		Amount:         amount,
+		RefundedAmount: orderInfo.RefundedAmount,
+		Status:         string(orderInfo.Status),
+		Reason:         reason,
+	})
+
+	return orderInfo, nil
+}
+
+// refund 请求支付网关退款并记录到订单，返回订单与实际退款金额，调用方需持有退款锁
+func (s *Service) refund(ctx context.Context, orderNo string, amount int, reason string) (*order.Order, int, error) {
+	log := logrus.WithField("order_no", orderNo)
+
+	orderInfo, err := s.Orders.Get(orderNo)
+	if err != nil {
+		return nil, 0, err
+	}
+	if orderInfo.Refundable() <= 0 {
+		return nil, 0, order.ErrRefundNotAllowed
+	}
+	if orderInfo.TradeNo == "" {
+		return nil, 0, ErrTradeNoMissing
+	}
+
+	if amount == 0 {
+		amount = orderInfo.Refundable()
+	}
+	if amount < 0 || amount > orderInfo.Refundable() {
+		return nil, 0, order.ErrRefundAmount
+	}
+
+	money := decimal.NewFromInt(int64(amount)).Div(decimal.NewFromInt(100)).StringFixed(2)
+	p, err := s.Providers.Get(orderInfo.Provider)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	refundCtx, cancel := context.WithTimeout(ctx, refundTimeout)
+	defer cancel()
+	if err := p.Refund(refundCtx, orderInfo, amount); err != nil {
+		log.WithError(err).WithField("provider", p.Name()).Warningln("支付网关退款失败")
+		return nil, 0, err
+	}
+
+	orderInfo, err = s.Orders.Update(orderNo, func(o *order.Order) error {
+		return o.AddRefund(amount, reason)
+	})
+	if err != nil {
+		// 网关已退款但未能记录，需要人工核对
+		log.WithError(err).WithField("money", money).Errorln("支付网关已退款，但无法记录退款信息")
+		return nil, 0, err
+	}
+
+	log.WithField("money", money).WithField("status", orderInfo.Status).Infoln("退款成功")
+	return orderInfo, amount, nil
+}
+
+// notifyRefund 向配置的 webhook 发送退款通知，失败仅记录日志
+func (s *Service) notifyRefund(ctx context.Context, event *RefundEvent) {
+	if s.Conf.RefundWebhook == "" {
+		return
+	}
+
+	log := logrus.WithField("order_no", event.OrderNo)
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.WithError(err).Warningln("无法编码退款通知")
+		return
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.Conf.CloudreveKey))
+	mac.Write(body)
+
+	resp, err := s.Client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetHeader(RefundSignatureHeader, hex.EncodeToString(mac.Sum(nil))).
+		SetBodyBytes(body).
+		Post(s.Conf.RefundWebhook)
+	if err == nil && !resp.IsSuccessState() {
+		err = fmt.Errorf("webhook 返回 HTTP %d", resp.StatusCode)
+	}
+	if err != nil {
+		log.WithError(err).Warningln("退款通知发送失败")
+	}
+}
@@ -0,0 +1,172 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/imroc/req/v3"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/cache"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/outbox"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/provider"
+)
+
+// fakeProvider 记录退款请求的支付渠道
+type fakeProvider struct {
+	// 每次退款请求的耗时
+	delay time.Duration
+
+	refunds atomic.Int32
+	results map[string]*provider.Result
+	err     error
+}
+
+func (p *fakeProvider) Name() string { return "fake" }
+
+func (p *fakeProvider) CreatePayment(context.Context, *provider.PaymentRequest) (*provider.Checkout, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (p *fakeProvider) VerifyNotification(*http.Request, *order.Order) (*provider.Result, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (p *fakeProvider) Query(ctx context.Context, orderInfo *order.Order) (*provider.Result, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	res, ok := p.results[orderInfo.OrderNo]
+	if !ok {
+		return nil, provider.ErrPaymentNotFound
+	}
+	return res, nil
+}
+
+func (p *fakeProvider) Refund(ctx context.Context, orderInfo *order.Order, amount int) error {
+	time.Sleep(p.delay)
+	p.refunds.Add(1)
+	return nil
+}
+
+func (p *fakeProvider) Ack(http.ResponseWriter, error) {}
+
+// newTestService 创建使用 fake 渠道的支付服务
+func newTestService(t *testing.T, driver cache.Driver, p *fakeProvider) *Service {
+	t.Helper()
+
+	conf := &appconf.Config{PaymentProvider: p.Name()}
+	registry, err := provider.NewRegistry(conf, []provider.Provider{p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewService(conf, registry, order.NewCacheRepository(driver), outbox.NewStore(driver), driver, req.C())
+}
+
+// paidOrder 保存一个已支付的订单
+func paidOrder(t *testing.T, s *Service, orderNo string, amount int) {
+	t.Helper()
+
+	orderInfo := order.New(orderNo, "测试商品", "", amount, "CNY", time.Hour)
+	orderInfo.TradeNo = "T" + orderNo
+	if err := orderInfo.Transition(order.StatusPaid, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Orders.Save(orderInfo); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRefund(t *testing.T) {
+	p := &fakeProvider{}
+	s := newTestService(t, cache.NewMemoStore(), p)
+	paidOrder(t, s, "o1", 100)
+
+	// 部分退款
+	orderInfo, err := s.Refund(context.Background(), "o1", 30, "部分退款")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orderInfo.RefundedAmount != 30 || orderInfo.Status != order.StatusPaid {
+		t.Fatalf("部分退款后订单为 %+v", orderInfo)
+	}
+
+	// 超过可退金额
+	if _, err := s.Refund(context.Background(), "o1", 71, ""); !errors.Is(err, order.ErrRefundAmount) {
+		t.Fatalf("超过可退金额时返回 %v", err)
+	}
+	if _, err := s.Refund(context.Background(), "o1", -1, ""); !errors.Is(err, order.ErrRefundAmount) {
+		t.Fatalf("负数金额时返回 %v", err)
+	}
+
+	// 金额为 0 时退还剩余全部金额
+	orderInfo, err = s.Refund(context.Background(), "o1", 0, "全额退款")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orderInfo.RefundedAmount != 100 || orderInfo.Status != order.StatusRefunded || len(orderInfo.Refunds) != 2 {
+		t.Fatalf("全额退款后订单为 %+v", orderInfo)
+	}
+
+	if _, err := s.Refund(context.Background(), "o1", 0, ""); !errors.Is(err, order.ErrRefundNotAllowed) {
+		t.Fatalf("已全额退款的订单再次退款时返回 %v", err)
+	}
+	if got := p.refunds.Load(); got != 2 {
+		t.Fatalf("支付网关收到 %d 次退款请求，应为 2 次", got)
+	}
+
+	if _, err := s.Refund(context.Background(), "missing", 0, ""); !errors.Is(err, order.ErrNotFound) {
+		t.Fatalf("订单不存在时返回 %v", err)
+	}
+}
+
+func TestRefundConcurrent(t *testing.T) {
+	redis := miniredis.RunT(t)
+	newDriver := func() cache.Driver {
+		return cache.NewRedisStore(&cache.RedisOptions{
+			Mode:           cache.RedisStandalone,
+			Addrs:          []string{redis.Addr()},
+			MaxIdle:        10,
+			ConnectTimeout: time.Second,
+			ReadTimeout:    time.Second,
+			WriteTimeout:   time.Second,
+		})
+	}
+
+	// 服务进程与命令行分别使用各自的连接与支付服务
+	p := &fakeProvider{delay: 50 * time.Millisecond}
+	services := []*Service{newTestService(t, newDriver(), p), newTestService(t, newDriver(), p)}
+	paidOrder(t, services[0], "o1", 100)
+
+	var succeeded atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(s *Service) {
+			defer wg.Done()
+			_, err := s.Refund(context.Background(), "o1", 0, "")
+			switch {
+			case err == nil:
+				succeeded.Add(1)
+			case errors.Is(err, order.ErrRefundNotAllowed):
+			default:
+				t.Error(err)
+			}
+		}(services[i%2])
+	}
+	wg.Wait()
+
+	if got := p.refunds.Load(); got != 1 || succeeded.Load() != 1 {
+		t.Fatalf("并发的全额退款使支付网关收到 %d 次退款请求、%d 次成功，均应为 1", got, succeeded.Load())
+	}
+	orderInfo, _ := services[1].Orders.Get("o1")
+	if orderInfo.RefundedAmount != 100 || len(orderInfo.Refunds) != 1 {
+		t.Fatalf("并发退款后订单为 %+v", orderInfo)
+	}
+}
@@ -0,0 +1,246 @@
+package payment
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/imroc/req/v3"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/cache"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/outbox"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/provider"
+	"go.uber.org/fx"
+)
+
+func Module() fx.Option {
+	return fx.Module("payment",
+		fx.Provide(NewService),
+		fx.Provide(NewReconciler),
+		fx.Invoke(func(lc fx.Lifecycle, r *Reconciler) {
+			lc.Append(fx.Hook{
+				OnStart: r.Start,
+				OnStop:  r.Stop,
+			})
+		}),
+	)
+}
+
+// 支付确认被拒绝的原因代码，用于日志记录
+const (
+	RejectParamsInvalid   = "params_invalid"
+	RejectProviderUnknown = "provider_unknown"
+	RejectOrderMismatch   = "order_mismatch"
+	RejectOrderNotFound   = "order_not_found"
+	RejectAmountInvalid   = "amount_invalid"
+	RejectAmountMismatch  = "amount_mismatch"
+)
+
+var ErrStorage = errors.New("无法保存支付结果")
+
+// RejectError 支付确认被拒绝
+type RejectError struct {
+	Reason string
+}
+
+func (e *RejectError) Error() string {
+	return "支付确认被拒绝: " + e.Reason
+}
+
+// RejectReason 返回 err 对应的拒绝原因代码，非拒绝错误返回空字符串
+func RejectReason(err error) string {
+	var rejectErr *RejectError
+	if errors.As(err, &rejectErr) {
+		return rejectErr.Reason
+	}
+	return ""
+}
+
+// Outcome 支付确认的处理结果
+type Outcome int
+
+const (
+	// OutcomeConfirmed 订单已记录为已支付，并已加入通知队列
+	OutcomeConfirmed Outcome = iota
+	// OutcomeDuplicate 订单此前已完成确认
+	OutcomeDuplicate
+	// OutcomeIgnored 交易状态不是成功，未做处理
+	OutcomeIgnored
+)
+
+// Payment 一笔来自支付网关的支付结果
+type Payment struct {
+	// 商家订单号
+	OrderNo string
+	// 支付网关订单号
+	TradeNo string
+	// 支付方式
+	Type string
+	// 实付金额，单位为元
+	Money string
+	// 收款商户名称
+	Merchant string
+}
+
+// Service 支付确认服务，所有支付成功回调均经由此处理
+type Service struct {
+	Conf      *appconf.Config
+	Providers *provider.Registry
+	Orders    order.Repository
+	Outbox    *outbox.Store
+	Cache     cache.Driver
+	Client    *req.Client
+}
+
+func NewService(conf *appconf.Config, providers *provider.Registry, orders order.Repository, store *outbox.Store, driver cache.Driver, client *req.Client) *Service {
+	return &Service{
+		Conf:      conf,
+		Providers: providers,
+		Orders:    orders,
+		Outbox:    store,
+		Cache:     driver,
+		Client:    client,
+	}
+}
+
+// providerFor 返回处理订单的支付渠道，旧订单未记录渠道时使用默认渠道
+func (s *Service) providerFor(orderNo string) (provider.Provider, *order.Order, error) {
+	orderInfo, err := s.Orders.Get(orderNo)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p, err := s.Providers.Get(orderInfo.Provider)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p, orderInfo, nil
+}
+
+// ConfirmNotification 由支付渠道校验异步通知，核对订单号与交易状态，然后确认支付。
+// providerName 为空时使用订单记录的渠道；orderNo 为路由中的订单号，为空时使用通知中的订单号
+func (s *Service) ConfirmNotification(r *http.Request, providerName, orderNo string) (Outcome, error) {
+	log := logrus.WithField("order_no", orderNo)
+
+	var orderInfo *order.Order
+	if orderNo != "" {
+		var err error
+		orderInfo, err = s.Orders.Get(orderNo)
+		if err != nil && !errors.Is(err, order.ErrNotFound) {
+			log.WithError(err).Errorln("无法读取订单信息")
+			return 0, ErrStorage
+		}
+		if providerName == "" && orderInfo != nil {
+			providerName = orderInfo.Provider
+		}
+	}
+
+	p, err := s.Providers.Get(providerName)
+	if err != nil {
+		log.WithError(err).WithField("reason", RejectProviderUnknown).Warningln("未知的支付渠道，拒绝回调")
+		return 0, &RejectError{Reason: RejectProviderUnknown}
+	}
+	log = log.WithField("provider", p.Name())
+
+	res, err := p.VerifyNotification(r, orderInfo)
+	if err != nil {
+		reason := RejectParamsInvalid
+		var verifyErr *provider.VerifyError
+		if errors.As(err, &verifyErr) && verifyErr.Reason != "" {
+			reason = verifyErr.Reason
+		}
+		log.WithError(err).WithField("reason", reason).Warningln("签名验证失败，拒绝回调")
+		return 0, &RejectError{Reason: reason}
+	}
+
+	if orderNo == "" {
+		orderNo = res.OrderNo
+		log = log.WithField("order_no", orderNo)
+	}
+	if orderNo == "" || res.OrderNo != orderNo {
+		log.WithField("reason", RejectOrderMismatch).WithField("out_trade_no", res.OrderNo).Warningln("订单号不符，拒绝回调")
+		return 0, &RejectError{Reason: RejectOrderMismatch}
+	}
+
+	if !res.Paid {
+		log.Infoln("订单未支付成功，忽略回调")
+		return OutcomeIgnored, nil
+	}
+
+	return s.Confirm(&Payment{
+		OrderNo:  orderNo,
+		TradeNo:  res.TradeNo,
+		Type:     res.Method,
+		Money:    res.Money,
+		Merchant: res.Merchant,
+	})
+}
+
+// Confirm 核对金额后将订单记录为已支付，并加入 Cloudreve 通知队列。
+// 调用方需保证 payment 来自可信来源
+func (s *Service) Confirm(payment *Payment) (Outcome, error) {
+	log := logrus.WithField("order_no", payment.OrderNo)
+
+	orderInfo, err := s.Orders.Get(payment.OrderNo)
+	if err != nil {
+		if errors.Is(err, order.ErrNotFound) {
+			log.WithField("reason", RejectOrderNotFound).Warningln("订单信息不存在，拒绝回调")
+			return 0, &RejectError{Reason: RejectOrderNotFound}
+		}
+		log.WithError(err).Errorln("无法读取订单信息")
+		return 0, ErrStorage
+	}
+
+	if orderInfo.Status == order.StatusNotified || orderInfo.Status == order.StatusRefunded {
+		log.Infoln("订单已经通知，重复回调")
+		return OutcomeDuplicate, nil
+	}
+
+	realAmount, err := decimal.NewFromString(payment.Money)
+	if err != nil {
+		log.WithError(err).WithField("reason", RejectAmountInvalid).Warningln("无法解析订单金额，拒绝回调")
+		return 0, &RejectError{Reason: RejectAmountInvalid}
+	}
+	if !realAmount.Equal(orderInfo.Money()) {
+		log.WithField("reason", RejectAmountMismatch).WithField("money", payment.Money).Warningln("订单金额不符，拒绝回调")
+		return 0, &RejectError{Reason: RejectAmountMismatch}
+	}
+
+	outcome := OutcomeDuplicate
+	if !orderInfo.IsPaid() {
+		outcome = OutcomeConfirmed
+		_, err = s.Orders.Update(payment.OrderNo, func(o *order.Order) error {
+			if o.IsPaid() {
+				outcome = OutcomeDuplicate
+				return nil
+			}
+
+			o.TradeNo = payment.TradeNo
+			if payment.Merchant != "" {
+				o.Merchant = payment.Merchant
+			}
+			if payment.Type != "" {
+				o.PaymentType = payment.Type
+			}
+			return o.Transition(order.StatusPaid, "收到支付成功通知")
+		})
+		if err != nil {
+			log.WithError(err).Errorln("标记订单为已支付失败")
+			return 0, ErrStorage
+		}
+	}
+
+	// 已支付但尚未通知的订单重新加入队列，记录已存在时不会重复添加。
+	// 加入失败且支付网关不再重发通知时，由 Reconciler 重新加入
+	if err := s.Outbox.Enqueue(payment.OrderNo); err != nil {
+		log.WithError(err).Errorln("无法保存待发送的通知")
+		return 0, ErrStorage
+	}
+
+	if outcome == OutcomeConfirmed {
+		log.Infoln("支付成功，已加入通知队列")
+	}
+	return outcome, nil
+}
@@ -0,0 +1,66 @@
+package provider
+
+import "strings"
+
+// Device 用户设备类型
+type Device string
+
+const (
+	DevicePC     Device = "pc"
+	DeviceMobile Device = "mobile"
+	DeviceQQ     Device = "qq"
+	DeviceWechat Device = "wechat"
+	DeviceAlipay Device = "alipay"
+)
+
+// 支付方式
+const (
+	MethodAlipay = "alipay"
+	MethodWxpay  = "wxpay"
+	MethodQQPay  = "qqpay"
+	MethodUSDT   = "usdt"
+)
+
+// DetectDevice 根据 User-Agent 判断设备类型，应用内浏览器优先于移动设备判断
+func DetectDevice(userAgent string) Device {
+	ua := strings.ToLower(userAgent)
+
+	switch {
+	case strings.Contains(ua, "micromessenger"):
+		return DeviceWechat
+	case strings.Contains(ua, "alipayclient"):
+		return DeviceAlipay
+	// 不能仅匹配 qq，QQ 浏览器与微信 X5 内核的 User-Agent 中均含有 MQQBrowser
+	case strings.Contains(ua, " qq/"):
+		return DeviceQQ
+	case strings.Contains(ua, "mobile") || strings.Contains(ua, "android") ||
+		strings.Contains(ua, "iphone") || strings.Contains(ua, "ipad") || strings.Contains(ua, "harmonyos"):
+		return DeviceMobile
+	default:
+		return DevicePC
+	}
+}
+
+// InApp 返回设备类型是否为应用内浏览器
+func (d Device) InApp() bool {
+	return d == DeviceQQ || d == DeviceWechat || d == DeviceAlipay
+}
+
+// Supports 返回该设备能否使用指定支付方式。应用内浏览器只能调起自家的支付，
+// 其余支付方式需要在系统浏览器中打开。USDT 由用户自行转账，不受限制
+func (d Device) Supports(method string) bool {
+	if method == MethodUSDT {
+		return true
+	}
+
+	switch d {
+	case DeviceWechat:
+		return method == MethodWxpay
+	case DeviceQQ:
+		return method == MethodQQPay
+	case DeviceAlipay:
+		return method == MethodAlipay
+	default:
+		return true
+	}
+}
@@ -0,0 +1,66 @@
+package provider
+
+import "testing"
+
+func TestDetectDevice(t *testing.T) {
+	cases := []struct {
+		name      string
+		userAgent string
+		want      Device
+	}{
+		{"Windows Chrome", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36", DevicePC},
+		{"macOS Safari", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15", DevicePC},
+		{"空 User-Agent", "", DevicePC},
+		{"iPhone Safari", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1", DeviceMobile},
+		{"iPad Safari", "Mozilla/5.0 (iPad; CPU OS 12_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/12.1 Mobile/15E148 Safari/604.1", DeviceMobile},
+		{"Android Chrome", "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.144 Mobile Safari/537.36", DeviceMobile},
+		{"Android 平板", "Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36", DeviceMobile},
+		{"鸿蒙华为浏览器", "Mozilla/5.0 (Phone; OpenHarmony 4.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/114.0.0.0 Safari/537.36 ArkWeb/4.1.6.1 Mobile HuaweiBrowser/5.0.4.300", DeviceMobile},
+		{"HarmonyOS 平板", "Mozilla/5.0 (Linux; Android 10; HarmonyOS; MRX-W09; HMSCore 6.11.0.302) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/99.0.4844.88 HuaweiBrowser/14.0.0.300 Safari/537.36", DeviceMobile},
+		// QQ 浏览器含有 MQQBrowser，但不是 QQ 应用内浏览器
+		{"QQ 浏览器", "Mozilla/5.0 (Linux; U; Android 11; zh-cn; PDRM00 Build/RP1A.200720.011) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/89.0.4389.72 MQQBrowser/13.0 Mobile Safari/537.36 COVC/045730", DeviceMobile},
+		{"iOS 微信", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148 MicroMessenger/8.0.42(0x18002a2c) NetType/WIFI Language/zh_CN", DeviceWechat},
+		{"Android 微信 X5 内核", "Mozilla/5.0 (Linux; Android 10; V1938CT Build/QP1A.190711.020; wv) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/89.0.4389.72 MQQBrowser/6.2 TBS/046011 Mobile Safari/537.36 MMWEBID/2375 MicroMessenger/8.0.2.1860(0x2800023D) Process/tools WeChat/arm64 Weixin NetType/WIFI Language/zh_CN ABI/arm64", DeviceWechat},
+		{"Windows 微信", "Mozilla/5.0 (Windows NT 10.0; WOW64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/81.0.4044.138 Safari/537.36 NetType/WIFI MicroMessenger/7.0.20.1781(0x6700143B) WindowsWechat(0x63090719) XWEB/8391 Flue", DeviceWechat},
+		{"Android 支付宝", "Mozilla/5.0 (Linux; U; Android 12; zh-CN; PEGM00 Build/SKQ1.210216.001) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/69.0.3497.100 UWS/3.22.2.59 Mobile Safari/537.36 AliApp(AP/10.5.56.6000) AlipayClient/10.5.56.6000 Language/zh-Hans useStatusBar/true isConcaveScreen/true Region/CNAriver/1.0.0 DTN/2.0", DeviceAlipay},
+		{"iOS 支付宝", "Mozilla/5.0 (iPhone; CPU iPhone OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148 Ariver/1.0.15 AliApp(AP/10.5.30.6000) Nebula WK RVKType(1) AlipayDefined(nt:WIFI,ws:390|780|3.0) AlipayClient/10.5.30.6000 Language/zh-Hans Region/CN NebulaX/1.0.0 XRiver/10.2.58.1", DeviceAlipay},
+		{"iOS QQ", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148 QQ/9.0.0.603 V1_IPH_SQ_9.0.0_1_APP_A Pixel/1170 MiniAppEnable SimpleUISwitch/0 StudyMode/0 CurrentMode/0 CurrentFontScale/1.000000 QQTheme/1000 Core/WKWebView Device/Apple(iPhone 13) NetType/WIFI QBWebViewType/1 WKType/1", DeviceQQ},
+		{"Android QQ", "Mozilla/5.0 (Linux; Android 13; 22081212C Build/TKQ1.220829.002; wv) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/109.0.5414.86 MQQBrowser/6.2 TBS/047205 Mobile Safari/537.36 V1_AND_SQ_8.9.80_4614_YYB_D QQ/8.9.80.12440 NetType/WIFI WebP/0.3.0 Pixel/1220 StatusBarHeight/110 SimpleUISwitch/0 QQTheme/1000", DeviceQQ},
+	}
+	for _, c := range cases {
+		if got := DetectDevice(c.userAgent); got != c.want {
+			t.Fatalf("%s: 识别为 %s，应为 %s", c.name, got, c.want)
+		}
+	}
+}
+
+func TestDeviceSupports(t *testing.T) {
+	methods := []string{MethodAlipay, MethodWxpay, MethodQQPay, MethodUSDT, "bank"}
+
+	cases := []struct {
+		device    Device
+		inApp     bool
+		supported []string
+	}{
+		{DevicePC, false, methods},
+		{DeviceMobile, false, methods},
+		{DeviceWechat, true, []string{MethodWxpay, MethodUSDT}},
+		{DeviceQQ, true, []string{MethodQQPay, MethodUSDT}},
+		{DeviceAlipay, true, []string{MethodAlipay, MethodUSDT}},
+	}
+	for _, c := range cases {
+		if c.device.InApp() != c.inApp {
+			t.Fatalf("%s: InApp 返回 %v", c.device, c.device.InApp())
+		}
+
+		supported := map[string]bool{}
+		for _, method := range c.supported {
+			supported[method] = true
+		}
+		for _, method := range methods {
+			if got := c.device.Supports(method); got != supported[method] {
+				t.Fatalf("%s 能否使用 %s: 返回 %v，应为 %v", c.device, method, got, supported[method])
+			}
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+	"go.uber.org/fx"
+)
+
+// GroupTag 支付渠道在 fx 中注册时使用的 value group
+const GroupTag = `group:"providers"`
+
+func Module() fx.Option {
+	return fx.Module("provider",
+		fx.Provide(fx.Annotate(NewRegistry, fx.ParamTags(``, GroupTag))),
+	)
+}
+
+// Register 将支付渠道的构造函数注册到 Registry。构造函数返回类型为 Provider 时，
+// 可以返回 nil 表示该渠道未启用
+func Register(constructor interface{}) fx.Option {
+	return fx.Provide(fx.Annotate(constructor, fx.As(new(Provider)), fx.ResultTags(GroupTag)))
+}
+
+var (
+	// ErrPaymentNotFound 支付网关中没有该订单，通常意味着用户尚未完成下单
+	ErrPaymentNotFound = errors.New("支付网关中没有该订单")
+	// ErrUnknownProvider 未注册的支付渠道
+	ErrUnknownProvider = errors.New("未知的支付渠道")
+)
+
+// VerifyError 异步通知校验失败
+type VerifyError struct {
+	// 拒绝原因代码，用于日志记录
+	Reason string
+	Err    error
+}
+
+func (e *VerifyError) Error() string {
+	return "异步通知校验失败: " + e.Err.Error()
+}
+
+func (e *VerifyError) Unwrap() error {
+	return e.Err
+}
+
+// PaymentRequest 发起支付的参数
+type PaymentRequest struct {
+	OrderNo string
+	// 商品名称
+	Name string
+	// 金额，单位为分
+	Amount   int
+	Currency string
+	// 支付方式，如 alipay、wxpay
+	Method   string
+	Device   Device
+	ClientIP string
+
+	NotifyURL *url.URL
+	ReturnURL *url.URL
+	// 订单的过期时间，支付渠道可据此限制支付有效期
+	ExpiresAt time.Time
+}
+
+// Checkout 发起支付的结果，Endpoint 不为空时需由浏览器提交表单，
+// 否则至少包含支付链接、二维码内容或 App 跳转链接中的一项
+type Checkout struct {
+	Endpoint string
+	Params   map[string]string
+
+	// 支付网关订单号
+	TradeNo string
+	// 支付跳转链接
+	PayURL string
+	// 二维码内容
+	QRCode string
+	// App 跳转链接
+	URLScheme string
+
+	// 处理该订单的商户账号，记录在订单中供后续查询与退款使用
+	Merchant string
+
+	// 需要用户自行转账的收款信息，不为空时展示收款地址与金额
+	Transfer *TransferInfo
+}
+
+// TransferInfo 加密货币等需要用户自行转账的收款信息
+type TransferInfo struct {
+	// 网络，如 TRC20
+	Network string
+	Address string
+	// 转账金额，用户需按该金额精确转账
+	Amount   string
+	Currency string
+	// 超过该时间后转账不再计入订单
+	ExpiresAt time.Time
+}
+
+// IsForm 返回是否需要由浏览器提交表单
+func (c *Checkout) IsForm() bool {
+	return c.Endpoint != ""
+}
+
+// Result 异步通知或主动查询得到的支付结果
+type Result struct {
+	// 商家订单号
+	OrderNo string
+	// 支付网关订单号
+	TradeNo string
+	// 支付方式
+	Method string
+	// 实付金额，单位为元
+	Money string
+	// 是否已支付成功
+	Paid bool
+	// 收款商户账号，为空时不修改订单记录
+	Merchant string
+}
+
+// Provider 支付渠道
+type Provider interface {
+	// Name 渠道名称，用于配置与回调地址
+	Name() string
+	// CreatePayment 发起支付
+	CreatePayment(ctx context.Context, req *PaymentRequest) (*Checkout, error)
+	// VerifyNotification 校验异步通知并返回支付结果，orderInfo 为路由中订单号对应的订单，可能为 nil
+	VerifyNotification(r *http.Request, orderInfo *order.Order) (*Result, error)
+	// Query 主动查询订单的支付结果，网关中没有该订单时返回 ErrPaymentNotFound
+	Query(ctx context.Context, orderInfo *order.Order) (*Result, error)
+	// Refund 退款，amount 单位为分
+	Refund(ctx context.Context, orderInfo *order.Order, amount int) error
+	// Ack 向支付网关写入异步通知的响应，err 为处理结果
+	Ack(w http.ResponseWriter, err error)
+}
+
+// Closer 可以关闭支付网关中未支付订单的支付渠道，订单过期后关闭，避免用户继续支付
+type Closer interface {
+	// Close 关闭订单，网关中没有该订单时返回 ErrPaymentNotFound
+	Close(ctx context.Context, orderInfo *order.Order) error
+}
+
+// CurrencyChecker 只能以部分货币收款的支付渠道，启动时检查网关货币，
+// 未实现该接口的支付渠道视为支持任意货币
+type CurrencyChecker interface {
+	// SupportsCurrency 返回能否以 currency 收款，currency 为大写的货币代码
+	SupportsCurrency(currency string) bool
+}
+
+// Registry 按名称选择支付渠道
+type Registry struct {
+	providers map[string]Provider
+	// 未指定渠道的订单使用的默认渠道
+	Default string
+}
+
+func NewRegistry(conf *appconf.Config, providers []Provider) (*Registry, error) {
+	registry := &Registry{
+		providers: map[string]Provider{},
+		Default:   conf.PaymentProvider,
+	}
+
+	for _, p := range providers {
+		if p == nil {
+			continue
+		}
+		if _, ok := registry.providers[p.Name()]; ok {
+			return nil, fmt.Errorf("支付渠道 %s 重复注册", p.Name())
+		}
+		// 所有支付渠道共用同一网关货币
+		if checker, ok := p.(CurrencyChecker); ok && !checker.SupportsCurrency(strings.ToUpper(conf.Currency)) {
+			return nil, fmt.Errorf("支付渠道 %s 不支持网关货币 %s，请修改 CR_EPAY_CURRENCY", p.Name(), conf.Currency)
+		}
+		registry.providers[p.Name()] = p
+	}
+
+	if _, err := registry.Get(""); err != nil {
+		return nil, fmt.Errorf("默认支付渠道 %s 未注册", conf.PaymentProvider)
+	}
+
+	return registry, nil
+}
+
+// Get 按名称获取支付渠道，名称为空时返回默认渠道
+func (r *Registry) Get(name string) (Provider, error) {
+	if name == "" {
+		name = r.Default
+	}
+
+	p, ok := r.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, name)
+	}
+	return p, nil
+}
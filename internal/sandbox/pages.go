@@ -0,0 +1,38 @@
+package sandbox
+
+// pages 沙箱页面模板
+const pages = `
+{{define "pay"}}<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>易支付沙箱</title>
+</head>
+<body style="text-align: center; font-family: sans-serif;">
+    <h3>易支付沙箱</h3>
+    <p>本页面仅用于测试，不会产生真实扣款</p>
+    <p>{{.Trade.Name}}</p>
+    <p>订单号：{{.Trade.OutTradeNo}}（{{.Trade.TradeNo}}）</p>
+    <p>支付方式：{{.Trade.Type}}</p>
+    <p>支付金额：￥{{.Money}}</p>
+    <form method="POST">
+        <button type="submit" name="action" value="pay">支付成功</button>
+        <button type="submit" name="action" value="underpay">少付 0.01 元</button>
+        <button type="submit" name="action" value="fail">支付失败</button>
+    </form>
+</body>
+</html>{{end}}
+
+{{define "error"}}<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>易支付沙箱</title>
+</head>
+<body style="text-align: center; font-family: sans-serif;">
+    <p>{{.message}}</p>
+</body>
+</html>{{end}}
+`
@@ -0,0 +1,349 @@
+package sandbox
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/imroc/req/v3"
+	"github.com/shopspring/decimal"
+	"github.com/sirupsen/logrus"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/epay"
+)
+
+// 支付页面上可模拟的结果
+const (
+	ActionPay      = "pay"
+	ActionFail     = "fail"
+	ActionUnderpay = "underpay"
+)
+
+var (
+	errTradeNotFound = errors.New("订单不存在")
+	errTradePaid     = errors.New("订单已支付")
+	errTradeUnpaid   = errors.New("订单未支付")
+	errRefundAmount  = errors.New("退款金额无效")
+)
+
+// Server 模拟易支付网关的沙箱服务，提供 submit.php、mapi.php 与 api.php，
+// 仅支持 MD5 签名，所有订单保存在内存中
+type Server struct {
+	PartnerID string
+	Key       string
+
+	client *req.Client
+	trades *store
+}
+
+func New(partnerID, key string, client *req.Client) *Server {
+	if client == nil {
+		client = req.C()
+	}
+
+	return &Server{
+		PartnerID: partnerID,
+		Key:       key,
+		client:    client,
+		trades:    newStore(),
+	}
+}
+
+// Handler 返回沙箱的 HTTP 处理器
+func (s *Server) Handler() http.Handler {
+	r := gin.New()
+	r.Use(gin.Recovery())
+	r.SetHTMLTemplate(template.Must(template.New("").Parse(pages)))
+
+	r.Any("/submit.php", s.Submit)
+	r.POST("/mapi.php", s.Mapi)
+	r.Any("/api.php", s.API)
+	r.GET("/sandbox/pay/:trade_no", s.PayPage)
+	r.POST("/sandbox/pay/:trade_no", s.Pay)
+
+	return r
+}
+
+// Trade 返回沙箱中的订单，供测试使用
+func (s *Server) Trade(outTradeNo string) (*Trade, bool) {
+	return s.trades.getByOutTradeNo(outTradeNo)
+}
+
+// formParams 合并 query 参数与 POST 表单参数
+func formParams(c *gin.Context) map[string]string {
+	params := map[string]string{}
+	if err := c.Request.ParseForm(); err != nil {
+		return params
+	}
+	for key := range c.Request.Form {
+		params[key] = c.Request.Form.Get(key)
+	}
+	return params
+}
+
+// createTrade 校验商户 ID 与签名后创建订单
+func (s *Server) createTrade(params map[string]string) (*Trade, error) {
+	if params["pid"] != s.PartnerID {
+		return nil, errors.New("商户 ID 不存在")
+	}
+	if params["sign_type"] != "" && params["sign_type"] != string(epay.SignTypeMD5) {
+		return nil, errors.New("沙箱仅支持 MD5 签名")
+	}
+	expected := epay.GenerateSign(params, s.Key)
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(params["sign"])) != 1 {
+		return nil, errors.New("签名校验失败")
+	}
+
+	for _, field := range []string{"type", "out_trade_no", "notify_url", "name", "money"} {
+		if params[field] == "" {
+			return nil, errors.New("缺少参数 " + field)
+		}
+	}
+	money, err := decimal.NewFromString(params["money"])
+	if err != nil || !money.IsPositive() {
+		return nil, errors.New("金额无效")
+	}
+
+	return s.trades.create(&Trade{
+		OutTradeNo: params["out_trade_no"],
+		Type:       params["type"],
+		Name:       params["name"],
+		Money:      money,
+		NotifyURL:  params["notify_url"],
+		ReturnURL:  params["return_url"],
+		Param:      params["param"],
+	}), nil
+}
+
+// Submit 页面跳转支付，校验通过后展示模拟支付页面
+func (s *Server) Submit(c *gin.Context) {
+	trade, err := s.createTrade(formParams(c))
+	if err != nil {
+		logrus.WithError(err).Warningln("沙箱拒绝支付请求")
+		c.HTML(http.StatusBadRequest, "error", gin.H{"message": err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/sandbox/pay/"+trade.TradeNo)
+}
+
+// Mapi API 接口支付，返回模拟支付页面的链接与二维码内容
+func (s *Server) Mapi(c *gin.Context) {
+	trade, err := s.createTrade(formParams(c))
+	if err != nil {
+		logrus.WithError(err).Warningln("沙箱拒绝支付请求")
+		c.JSON(http.StatusOK, gin.H{"code": -1, "msg": err.Error()})
+		return
+	}
+
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	payURL := scheme + "://" + c.Request.Host + "/sandbox/pay/" + trade.TradeNo
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":     1,
+		"msg":      "success",
+		"trade_no": trade.TradeNo,
+		"payurl":   payURL,
+		"qrcode":   payURL,
+	})
+}
+
+// API 订单查询与退款接口，使用商户密钥鉴权
+func (s *Server) API(c *gin.Context) {
+	params := formParams(c)
+	if params["pid"] != s.PartnerID || subtle.ConstantTimeCompare([]byte(params["key"]), []byte(s.Key)) != 1 {
+		c.JSON(http.StatusOK, gin.H{"code": -3, "msg": "KEY校验失败"})
+		return
+	}
+
+	switch params["act"] {
+	case "order":
+		s.queryOrder(c, params)
+	case "refund":
+		s.refund(c, params)
+	default:
+		c.JSON(http.StatusOK, gin.H{"code": -5, "msg": "不支持的操作"})
+	}
+}
+
+func (s *Server) queryOrder(c *gin.Context, params map[string]string) {
+	trade, ok := s.trades.getByOutTradeNo(params["out_trade_no"])
+	if !ok && params["trade_no"] != "" {
+		trade, ok = s.trades.get(params["trade_no"])
+	}
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{"code": -1, "msg": "订单号不存在"})
+		return
+	}
+
+	status := 0
+	endtime := ""
+	money := trade.Money
+	if trade.Paid {
+		status = 1
+		endtime = trade.PaidAt.Format(time.DateTime)
+		money = trade.PaidMoney
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"code":         1,
+		"msg":          "查询订单号成功！",
+		"trade_no":     trade.TradeNo,
+		"out_trade_no": trade.OutTradeNo,
+		"type":         trade.Type,
+		"pid":          s.PartnerID,
+		"addtime":      trade.CreatedAt.Format(time.DateTime),
+		"endtime":      endtime,
+		"name":         trade.Name,
+		"money":        money.StringFixed(2),
+		"status":       status,
+		"param":        trade.Param,
+	})
+}
+
+func (s *Server) refund(c *gin.Context, params map[string]string) {
+	money, err := decimal.NewFromString(params["money"])
+	if err != nil || !money.IsPositive() {
+		c.JSON(http.StatusOK, gin.H{"code": -1, "msg": errRefundAmount.Error()})
+		return
+	}
+
+	_, err = s.trades.update(params["trade_no"], func(t *Trade) error {
+		if !t.Paid {
+			return errTradeUnpaid
+		}
+		if t.Refunded.Add(money).GreaterThan(t.PaidMoney) {
+			return errRefundAmount
+		}
+		t.Refunded = t.Refunded.Add(money)
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"code": -1, "msg": err.Error()})
+		return
+	}
+
+	logrus.WithField("trade_no", params["trade_no"]).WithField("money", params["money"]).Infoln("沙箱退款成功")
+	c.JSON(http.StatusOK, gin.H{"code": 1, "msg": "退款成功"})
+}
+
+// PayPage 模拟支付页面
+func (s *Server) PayPage(c *gin.Context) {
+	trade, ok := s.trades.get(c.Param("trade_no"))
+	if !ok {
+		c.HTML(http.StatusNotFound, "error", gin.H{"message": errTradeNotFound.Error()})
+		return
+	}
+
+	c.HTML(http.StatusOK, "pay", gin.H{
+		"Trade": trade,
+		"Money": trade.Money.StringFixed(2),
+	})
+}
+
+// Pay 按选择的结果完成模拟支付：支付成功或少付时发送异步通知并跳转回商家页面，
+// 支付失败时直接跳转回商家页面
+func (s *Server) Pay(c *gin.Context) {
+	action := c.PostForm("action")
+	if action == "" {
+		action = c.Query("action")
+	}
+
+	trade, err := s.trades.update(c.Param("trade_no"), func(t *Trade) error {
+		if t.Paid {
+			return errTradePaid
+		}
+
+		switch action {
+		case ActionPay:
+			t.PaidMoney = t.Money
+		case ActionUnderpay:
+			t.PaidMoney = t.Money.Sub(decimal.New(1, -2))
+			if !t.PaidMoney.IsPositive() {
+				t.PaidMoney = decimal.New(1, -2)
+			}
+		default:
+			return nil
+		}
+
+		t.Paid = true
+		t.PaidAt = time.Now()
+		return nil
+	})
+	if err != nil {
+		c.HTML(http.StatusBadRequest, "error", gin.H{"message": err.Error()})
+		return
+	}
+
+	if !trade.Paid {
+		logrus.WithField("trade_no", trade.TradeNo).Infoln("沙箱模拟支付失败")
+		s.redirectReturn(c, trade, nil)
+		return
+	}
+
+	params := map[string]string{
+		"pid":          s.PartnerID,
+		"trade_no":     trade.TradeNo,
+		"out_trade_no": trade.OutTradeNo,
+		"type":         trade.Type,
+		"name":         trade.Name,
+		"money":        trade.PaidMoney.StringFixed(2),
+		"trade_status": epay.TRADE_SUCCESS,
+	}
+	if trade.Param != "" {
+		params["param"] = trade.Param
+	}
+	params = epay.GenerateParams(params, s.Key)
+
+	s.Notify(c.Request.Context(), trade, params)
+	s.redirectReturn(c, trade, params)
+}
+
+// Notify 向商家发送异步通知
+func (s *Server) Notify(ctx context.Context, trade *Trade, params map[string]string) {
+	log := logrus.WithField("trade_no", trade.TradeNo).WithField("notify_url", trade.NotifyURL)
+
+	resp, err := s.client.R().
+		SetContext(ctx).
+		SetQueryParams(params).
+		Get(trade.NotifyURL)
+	if err != nil {
+		log.WithError(err).Warningln("沙箱发送异步通知失败")
+		return
+	}
+
+	log.WithField("status", resp.StatusCode).WithField("body", resp.String()).Infoln("沙箱已发送异步通知")
+}
+
+// redirectReturn 跳转回商家页面，未配置 return_url 时展示支付结果
+func (s *Server) redirectReturn(c *gin.Context, trade *Trade, params map[string]string) {
+	if trade.ReturnURL == "" {
+		message := "支付失败"
+		if trade.Paid {
+			message = "支付成功"
+		}
+		c.HTML(http.StatusOK, "error", gin.H{"message": message})
+		return
+	}
+
+	returnURL, err := url.Parse(trade.ReturnURL)
+	if err != nil {
+		c.HTML(http.StatusBadRequest, "error", gin.H{"message": "return_url 无效"})
+		return
+	}
+
+	query := returnURL.Query()
+	for key, value := range params {
+		query.Set(key, value)
+	}
+	returnURL.RawQuery = query.Encode()
+
+	c.Redirect(http.StatusFound, returnURL.String())
+}
@@ -0,0 +1,358 @@
+package sandbox
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/imroc/req/v3"
+	"github.com/sirupsen/logrus"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/cache"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/epay"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/merchant"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/outbox"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/payment"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/provider"
+)
+
+const (
+	testPartnerID = "1010"
+	testKey       = "sandbox-key"
+)
+
+// shop 模拟商家：接收沙箱的异步通知并交由支付服务确认
+type shop struct {
+	t        *testing.T
+	sandbox  *Server
+	gateway  *httptest.Server
+	server   *httptest.Server
+	payments *payment.Service
+
+	mu sync.Mutex
+	// 收到的异步通知参数与支付服务的处理结果，按订单号记录
+	notified map[string]url.Values
+	rejected map[string]string
+
+	// 不跟随跳转的 HTTP 客户端
+	client *http.Client
+}
+
+func newShop(t *testing.T) *shop {
+	logrus.SetOutput(io.Discard)
+
+	s := &shop{
+		t:        t,
+		sandbox:  New(testPartnerID, testKey, req.C()),
+		notified: map[string]url.Values{},
+		rejected: map[string]string{},
+		client: &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+	s.gateway = httptest.NewServer(s.sandbox.Handler())
+	t.Cleanup(s.gateway.Close)
+
+	conf := &appconf.Config{
+		PaymentProvider: merchant.ProviderName,
+		EpayPartnerID:   testPartnerID,
+		EpayKey:         testKey,
+		EpayEndpoint:    s.gateway.URL + "/submit.php",
+		Currency:        "CNY",
+	}
+	router, err := merchant.NewRouter(conf, req.C())
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver := cache.NewMemoStore()
+	orders := order.NewCacheRepository(driver)
+	registry, err := provider.NewRegistry(conf, []provider.Provider{merchant.NewProvider(router, orders)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.payments = payment.NewService(conf, registry, orders, outbox.NewStore(driver), driver, req.C())
+
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		orderNo := strings.TrimPrefix(r.URL.Path, "/notify/")
+		_, err := s.payments.ConfirmNotification(r, merchant.ProviderName, orderNo)
+
+		s.mu.Lock()
+		s.notified[orderNo] = r.URL.Query()
+		s.rejected[orderNo] = payment.RejectReason(err)
+		s.mu.Unlock()
+
+		if err != nil {
+			http.Error(w, "fail", http.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write([]byte("success"))
+	}))
+	t.Cleanup(s.server.Close)
+
+	return s
+}
+
+// params 返回以 key 签名的下单参数，overrides 中的值会在签名前覆盖默认参数
+func (s *shop) params(orderNo, key string, overrides map[string]string) url.Values {
+	params := map[string]string{
+		"pid":          testPartnerID,
+		"type":         "alipay",
+		"out_trade_no": orderNo,
+		"notify_url":   s.server.URL + "/notify/" + orderNo,
+		"return_url":   s.server.URL + "/return/" + orderNo,
+		"name":         "测试商品",
+		"money":        "1.00",
+	}
+	for k, v := range overrides {
+		params[k] = v
+	}
+
+	form := url.Values{}
+	for k, v := range epay.GenerateParams(params, key) {
+		form.Set(k, v)
+	}
+	return form
+}
+
+// pendingOrder 在商家一侧保存一个金额为 1 元、等待支付的订单
+func (s *shop) pendingOrder(orderNo string) {
+	s.t.Helper()
+
+	orderInfo := order.New(orderNo, "测试商品", "", 100, "CNY", time.Hour)
+	orderInfo.Provider = merchant.ProviderName
+	if err := orderInfo.Transition(order.StatusPending, ""); err != nil {
+		s.t.Fatal(err)
+	}
+	if err := s.payments.Orders.Save(orderInfo); err != nil {
+		s.t.Fatal(err)
+	}
+}
+
+// submit 通过 submit.php 下单，返回模拟支付页面的地址
+func (s *shop) submit(orderNo string) string {
+	s.t.Helper()
+
+	resp, err := s.client.PostForm(s.gateway.URL+"/submit.php", s.params(orderNo, testKey, nil))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		s.t.Fatalf("沙箱拒绝了支付请求: HTTP %d", resp.StatusCode)
+	}
+	return s.gateway.URL + resp.Header.Get("Location")
+}
+
+// pay 在模拟支付页面上选择 action，返回响应
+func (s *shop) pay(payURL, action string) *http.Response {
+	s.t.Helper()
+
+	resp, err := s.client.PostForm(payURL, url.Values{"action": {action}})
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+// api 调用 api.php，返回响应中的 code 与完整响应
+func (s *shop) api(params url.Values) (int, map[string]any) {
+	s.t.Helper()
+
+	var res map[string]any
+	resp, err := req.C().R().SetFormDataFromValues(params).SetSuccessResult(&res).Post(s.gateway.URL + "/api.php")
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	if !resp.IsSuccessState() {
+		s.t.Fatalf("api.php 返回 HTTP %d", resp.StatusCode)
+	}
+	code, _ := res["code"].(float64)
+	return int(code), res
+}
+
+func TestCreateTrade(t *testing.T) {
+	s := newShop(t)
+
+	// 签名后修改参数
+	tampered := s.params("o7", testKey, nil)
+	tampered.Set("money", "0.01")
+	rsaSigned := s.params("o4", testKey, nil)
+	rsaSigned.Set("sign_type", "RSA")
+
+	cases := []struct {
+		name   string
+		params url.Values
+		ok     bool
+	}{
+		{"正确的签名", s.params("o1", testKey, nil), true},
+		{"其他密钥的签名", s.params("o2", "other-key", nil), false},
+		{"错误的商户 ID", s.params("o3", testKey, map[string]string{"pid": "2020"}), false},
+		{"非 MD5 签名", rsaSigned, false},
+		{"缺少通知地址", s.params("o5", testKey, map[string]string{"notify_url": ""}), false},
+		{"金额为 0", s.params("o6", testKey, map[string]string{"money": "0"}), false},
+		{"签名后修改金额", tampered, false},
+	}
+
+	for _, c := range cases {
+		orderNo := c.params.Get("out_trade_no")
+
+		// 页面跳转支付
+		resp, err := s.client.PostForm(s.gateway.URL+"/submit.php", c.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if ok := resp.StatusCode == http.StatusFound; ok != c.ok {
+			t.Fatalf("%s: submit.php 返回 HTTP %d", c.name, resp.StatusCode)
+		}
+
+		// API 接口支付
+		var res map[string]any
+		if _, err := req.C().R().SetFormDataFromValues(c.params).SetSuccessResult(&res).Post(s.gateway.URL + "/mapi.php"); err != nil {
+			t.Fatal(err)
+		}
+		if ok := res["code"] == float64(1) && res["payurl"] != nil; ok != c.ok {
+			t.Fatalf("%s: mapi.php 返回 %v", c.name, res)
+		}
+
+		if _, ok := s.sandbox.Trade(orderNo); ok != c.ok {
+			t.Fatalf("%s: 沙箱中订单存在: %v", c.name, ok)
+		}
+	}
+}
+
+func TestPay(t *testing.T) {
+	s := newShop(t)
+
+	cases := []struct {
+		action string
+		paid   bool
+		money  string
+		reason string
+	}{
+		{ActionPay, true, "1.00", ""},
+		// 少付的通知签名有效，但会被商家以金额不符拒绝
+		{ActionUnderpay, true, "0.99", payment.RejectAmountMismatch},
+		{ActionFail, false, "", ""},
+	}
+	for _, c := range cases {
+		orderNo := "pay-" + c.action
+		s.pendingOrder(orderNo)
+
+		resp := s.pay(s.submit(orderNo), c.action)
+		if resp.StatusCode != http.StatusFound {
+			t.Fatalf("%s: 支付返回 HTTP %d", c.action, resp.StatusCode)
+		}
+
+		// 跳转回商家页面，支付成功时带有签名的支付结果
+		returnURL, _ := url.Parse(resp.Header.Get("Location"))
+		if returnURL.Path != "/return/"+orderNo {
+			t.Fatalf("%s: 跳转至 %s", c.action, returnURL)
+		}
+		if c.paid != (returnURL.Query().Get("sign") != "") {
+			t.Fatalf("%s: 跳转地址为 %s", c.action, returnURL)
+		}
+
+		trade, _ := s.sandbox.Trade(orderNo)
+		if trade.Paid != c.paid {
+			t.Fatalf("%s: 沙箱订单为 %+v", c.action, trade)
+		}
+
+		s.mu.Lock()
+		notified, ok := s.notified[orderNo]
+		reason := s.rejected[orderNo]
+		s.mu.Unlock()
+		if ok != c.paid {
+			t.Fatalf("%s: 收到异步通知: %v", c.action, ok)
+		}
+		if !c.paid {
+			continue
+		}
+		if notified.Get("money") != c.money || notified.Get("trade_no") != trade.TradeNo || notified.Get("trade_status") != epay.TRADE_SUCCESS {
+			t.Fatalf("%s: 异步通知为 %v", c.action, notified)
+		}
+		if reason != c.reason {
+			t.Fatalf("%s: 拒绝原因为 %q，应为 %q", c.action, reason, c.reason)
+		}
+
+		// 已支付的订单不能再次支付
+		if resp := s.pay(s.gateway.URL+"/sandbox/pay/"+trade.TradeNo, ActionPay); resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("%s: 重复支付返回 HTTP %d", c.action, resp.StatusCode)
+		}
+	}
+
+	want := map[string]order.Status{
+		"pay-" + ActionPay:      order.StatusPaid,
+		"pay-" + ActionUnderpay: order.StatusPending,
+		"pay-" + ActionFail:     order.StatusPending,
+	}
+	for orderNo, status := range want {
+		if orderInfo, _ := s.payments.Orders.Get(orderNo); orderInfo.Status != status {
+			t.Fatalf("%s 的状态为 %s，应为 %s", orderNo, orderInfo.Status, status)
+		}
+	}
+}
+
+func TestAPI(t *testing.T) {
+	s := newShop(t)
+	s.pay(s.submit("paid"), ActionPay)
+	s.submit("unpaid")
+	paid, _ := s.sandbox.Trade("paid")
+	unpaid, _ := s.sandbox.Trade("unpaid")
+
+	params := func(values ...string) url.Values {
+		form := url.Values{"pid": {testPartnerID}, "key": {testKey}}
+		for i := 0; i+1 < len(values); i += 2 {
+			form.Set(values[i], values[i+1])
+		}
+		return form
+	}
+
+	cases := []struct {
+		name   string
+		params url.Values
+		code   int
+	}{
+		{"查询已支付订单", params("act", "order", "out_trade_no", "paid"), 1},
+		{"按易支付订单号查询", params("act", "order", "trade_no", unpaid.TradeNo), 1},
+		{"查询不存在的订单", params("act", "order", "out_trade_no", "missing"), -1},
+		{"错误的密钥", params("act", "order", "out_trade_no", "paid", "key", "other-key"), -3},
+		{"缺少密钥", params("act", "order", "out_trade_no", "paid", "key", ""), -3},
+		{"错误的商户 ID", params("act", "order", "out_trade_no", "paid", "pid", "2020"), -3},
+		{"不支持的操作", params("act", "close", "out_trade_no", "paid"), -5},
+		{"错误的密钥退款", params("act", "refund", "trade_no", paid.TradeNo, "money", "0.10", "key", "other-key"), -3},
+		{"退款超过实付金额", params("act", "refund", "trade_no", paid.TradeNo, "money", "1.01"), -1},
+		{"部分退款", params("act", "refund", "trade_no", paid.TradeNo, "money", "0.60"), 1},
+		{"累计退款超过实付金额", params("act", "refund", "trade_no", paid.TradeNo, "money", "0.50"), -1},
+		{"退还剩余金额", params("act", "refund", "trade_no", paid.TradeNo, "money", "0.40"), 1},
+		{"退款金额为 0", params("act", "refund", "trade_no", paid.TradeNo, "money", "0"), -1},
+		{"未支付订单退款", params("act", "refund", "trade_no", unpaid.TradeNo, "money", "0.10"), -1},
+		{"不存在的订单退款", params("act", "refund", "trade_no", "missing", "money", "0.10"), -1},
+	}
+	for _, c := range cases {
+		if code, res := s.api(c.params); code != c.code {
+			t.Fatalf("%s: 返回 %v，code 应为 %d", c.name, res, c.code)
+		}
+	}
+
+	_, res := s.api(params("act", "order", "out_trade_no", "paid"))
+	if res["status"] != float64(1) || res["money"] != "1.00" || res["trade_no"] != paid.TradeNo || res["endtime"] == "" {
+		t.Fatalf("已支付订单的查询结果为 %v", res)
+	}
+	_, res = s.api(params("act", "order", "out_trade_no", "unpaid"))
+	if res["status"] != float64(0) || res["endtime"] != "" {
+		t.Fatalf("未支付订单的查询结果为 %v", res)
+	}
+	if trade, _ := s.sandbox.Trade("paid"); trade.Refunded.StringFixed(2) != "1.00" {
+		t.Fatalf("累计退款金额为 %s", trade.Refunded)
+	}
+}
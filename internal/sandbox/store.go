@@ -0,0 +1,109 @@
+package sandbox
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Trade 沙箱中的一笔订单
+type Trade struct {
+	TradeNo    string
+	OutTradeNo string
+	Type       string
+	Name       string
+	Money      decimal.Decimal
+	NotifyURL  string
+	ReturnURL  string
+	Param      string
+	Paid       bool
+	// 实付金额，模拟少付时小于 Money
+	PaidMoney decimal.Decimal
+	Refunded  decimal.Decimal
+	CreatedAt time.Time
+	PaidAt    time.Time
+}
+
+// store 保存在内存中的沙箱订单
+type store struct {
+	mu      sync.Mutex
+	seq     int
+	trades  map[string]*Trade
+	byOutNo map[string]string
+}
+
+func newStore() *store {
+	return &store{
+		trades:  map[string]*Trade{},
+		byOutNo: map[string]string{},
+	}
+}
+
+// create 创建订单，同一商家订单号的未支付订单会被更新并沿用原易支付订单号
+func (s *store) create(trade *Trade) *Trade {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if tradeNo, ok := s.byOutNo[trade.OutTradeNo]; ok {
+		if existing := s.trades[tradeNo]; !existing.Paid {
+			trade.TradeNo = existing.TradeNo
+			trade.CreatedAt = existing.CreatedAt
+			s.trades[tradeNo] = trade
+			return s.snapshot(trade)
+		}
+	}
+
+	s.seq++
+	trade.TradeNo = fmt.Sprintf("%s%05d", time.Now().Format("20060102150405"), s.seq)
+	trade.CreatedAt = time.Now()
+	s.trades[trade.TradeNo] = trade
+	s.byOutNo[trade.OutTradeNo] = trade.TradeNo
+
+	return s.snapshot(trade)
+}
+
+// get 按易支付订单号获取订单
+func (s *store) get(tradeNo string) (*Trade, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	trade, ok := s.trades[tradeNo]
+	if !ok {
+		return nil, false
+	}
+	return s.snapshot(trade), true
+}
+
+// getByOutTradeNo 按商家订单号获取订单
+func (s *store) getByOutTradeNo(outTradeNo string) (*Trade, bool) {
+	s.mu.Lock()
+	tradeNo, ok := s.byOutNo[outTradeNo]
+	s.mu.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+	return s.get(tradeNo)
+}
+
+// update 在锁内修改订单
+func (s *store) update(tradeNo string, fn func(t *Trade) error) (*Trade, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	trade, ok := s.trades[tradeNo]
+	if !ok {
+		return nil, errTradeNotFound
+	}
+	if err := fn(trade); err != nil {
+		return nil, err
+	}
+	return s.snapshot(trade), nil
+}
+
+func (s *store) snapshot(trade *Trade) *Trade {
+	copied := *trade
+	return &copied
+}
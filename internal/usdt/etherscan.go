@@ -0,0 +1,107 @@
+package usdt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/imroc/req/v3"
+	"github.com/shopspring/decimal"
+)
+
+// EtherscanWatcher 通过 Etherscan API 查询以太坊主网上的 ERC20 USDT 转账
+type EtherscanWatcher struct {
+	Endpoint string
+	Contract string
+	APIKey   string
+	Client   *req.Client
+}
+
+type tokenTxResponse struct {
+	Status  string          `json:"status"`
+	Message string          `json:"message"`
+	Result  json.RawMessage `json:"result"`
+}
+
+type tokenTx struct {
+	Hash            string `json:"hash"`
+	From            string `json:"from"`
+	To              string `json:"to"`
+	ContractAddress string `json:"contractAddress"`
+	Value           string `json:"value"`
+	TokenDecimal    string `json:"tokenDecimal"`
+	Confirmations   string `json:"confirmations"`
+	TimeStamp       string `json:"timeStamp"`
+}
+
+// Transfers 最多返回最近的 200 笔转账
+func (w *EtherscanWatcher) Transfers(ctx context.Context, address string, since time.Time) ([]Transfer, error) {
+	resp, err := w.Client.R().
+		SetContext(ctx).
+		SetQueryParams(map[string]string{
+			"chainid":         "1",
+			"module":          "account",
+			"action":          "tokentx",
+			"contractaddress": w.Contract,
+			"address":         address,
+			"page":            "1",
+			"offset":          "200",
+			"sort":            "desc",
+			"apikey":          w.APIKey,
+		}).
+		Get(w.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.IsSuccessState() {
+		return nil, fmt.Errorf("Etherscan 返回 HTTP %d", resp.StatusCode)
+	}
+
+	var res tokenTxResponse
+	if err := json.Unmarshal(resp.Bytes(), &res); err != nil {
+		return nil, fmt.Errorf("无法解析 Etherscan 响应: %w", err)
+	}
+	// 没有任何转账时 status 同样为 0
+	if res.Status != "1" {
+		if res.Message == "No transactions found" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Etherscan 返回错误: %s %s", res.Message, string(res.Result))
+	}
+
+	var txs []tokenTx
+	if err := json.Unmarshal(res.Result, &txs); err != nil {
+		return nil, fmt.Errorf("无法解析 Etherscan 响应: %w", err)
+	}
+
+	var transfers []Transfer
+	for _, tx := range txs {
+		if !strings.EqualFold(tx.To, address) || !strings.EqualFold(tx.ContractAddress, w.Contract) {
+			continue
+		}
+		timestamp, _ := strconv.ParseInt(tx.TimeStamp, 10, 64)
+		if time.Unix(timestamp, 0).Before(since) {
+			continue
+		}
+		value, err := decimal.NewFromString(tx.Value)
+		if err != nil {
+			continue
+		}
+		decimals, _ := strconv.ParseInt(tx.TokenDecimal, 10, 32)
+		confirmations, _ := strconv.Atoi(tx.Confirmations)
+
+		// Etherscan 返回小写地址，收款地址记录为配置中的写法，与 EIP-55 校验和格式的地址一致
+		transfers = append(transfers, Transfer{
+			TxID:          tx.Hash,
+			From:          tx.From,
+			To:            address,
+			Amount:        value.Shift(-int32(decimals)),
+			Confirmations: confirmations,
+			Time:          time.Unix(timestamp, 0),
+		})
+	}
+	return transfers, nil
+}
@@ -0,0 +1,161 @@
+package usdt
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/payment"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/provider"
+)
+
+// Monitor 定期检查已分配金额的订单是否收到转账，达到确认数后确认支付，
+// 并释放超时未收到转账的金额
+type Monitor struct {
+	provider *Provider
+	service  *payment.Service
+	orders   order.Repository
+
+	Interval time.Duration
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewMonitor(conf *appconf.Config, p *Provider, service *payment.Service, orders order.Repository) *Monitor {
+	return &Monitor{
+		provider: p,
+		service:  service,
+		orders:   orders,
+		Interval: conf.UsdtPollInterval,
+	}
+}
+
+// Start 启动定期检查，未启用 USDT 渠道或 Interval 不大于 0 时不启动
+func (m *Monitor) Start(ctx context.Context) error {
+	if m.provider == nil || m.Interval <= 0 {
+		return nil
+	}
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		ticker := time.NewTicker(m.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-runCtx.Done():
+				return
+			case <-ticker.C:
+				m.Poll(runCtx)
+			}
+		}
+	}()
+
+	logrus.Infoln("USDT 转账检查任务已启动")
+	return nil
+}
+
+// Stop 停止定期检查
+func (m *Monitor) Stop(ctx context.Context) error {
+	if m.cancel != nil {
+		m.cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Poll 对所有尚未释放的分配记录进行一次检查
+func (m *Monitor) Poll(ctx context.Context) {
+	assignments := m.provider.assignments()
+	// 先查询最早的分配记录，使转账查询结果可被后续记录复用
+	sort.Slice(assignments, func(i, j int) bool {
+		return assignments[i].CreatedAt.Before(assignments[j].CreatedAt)
+	})
+
+	now := time.Now()
+	for _, a := range assignments {
+		if ctx.Err() != nil {
+			return
+		}
+
+		log := logrus.WithField("order_no", a.OrderNo).WithField("amount", a.Amount)
+		t, err := m.provider.match(ctx, a)
+		if err != nil {
+			log.WithError(err).Warningln("无法查询 USDT 转账")
+			return
+		}
+
+		if t == nil {
+			if now.After(a.ExpiresAt) {
+				log.Infoln("USDT 支付超时，释放分配的金额")
+				if err := m.provider.release(a); err != nil {
+					log.WithError(err).Warningln("无法释放分配的 USDT 金额")
+				}
+			}
+			continue
+		}
+
+		log = log.WithField("tx_id", t.TxID).WithField("confirmations", t.Confirmations)
+		if t.Confirmations < m.provider.Confirmations {
+			log.Debugln("USDT 转账等待区块确认")
+			continue
+		}
+
+		if m.confirm(a, t, log) {
+			if err := m.provider.release(a); err != nil {
+				log.WithError(err).Warningln("无法释放分配的 USDT 金额")
+			}
+		}
+	}
+}
+
+// confirm 按正常流程确认支付，返回是否可以释放分配记录
+func (m *Monitor) confirm(a *Assignment, t *Transfer, log *logrus.Entry) bool {
+	orderInfo, err := m.orders.Get(a.OrderNo)
+	if errors.Is(err, order.ErrNotFound) {
+		log.Warningln("收到 USDT 转账的订单不存在")
+		return true
+	}
+	if err != nil {
+		log.WithError(err).Warningln("无法读取订单信息")
+		return false
+	}
+
+	res := m.provider.result(orderInfo, t)
+	log.Infoln("USDT 转账已确认")
+	outcome, err := m.service.Confirm(&payment.Payment{
+		OrderNo: res.OrderNo,
+		TradeNo: res.TradeNo,
+		Type:    provider.MethodUSDT,
+		Money:   res.Money,
+	})
+	if err != nil {
+		log.WithError(err).Warningln("无法确认 USDT 支付")
+		// 被拒绝的确认重试也不会成功，存储错误则保留分配记录稍后重试
+		var rejectErr *payment.RejectError
+		return errors.As(err, &rejectErr)
+	}
+	return outcome != payment.OutcomeIgnored
+}
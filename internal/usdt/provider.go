@@ -0,0 +1,302 @@
+package usdt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/cache"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/currency"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/provider"
+	"go.uber.org/fx"
+)
+
+// ProviderName USDT 渠道的名称
+const ProviderName = "usdt"
+
+// RejectNoNotification USDT 渠道没有异步通知，收到的回调一律拒绝
+const RejectNoNotification = "usdt_no_notification"
+
+var (
+	// ErrTransferPending 已收到转账，正在等待区块确认
+	ErrTransferPending = errors.New("USDT 转账正在等待区块确认")
+	// ErrRefundUnsupported USDT 渠道无法自动退款
+	ErrRefundUnsupported = errors.New("USDT 渠道不支持自动退款，请通过钱包手动退款")
+)
+
+func Module() fx.Option {
+	return fx.Module("usdt",
+		fx.Provide(NewWatcher),
+		fx.Provide(NewProvider),
+		provider.Register(func(p *Provider) provider.Provider {
+			// 未启用时需返回 nil 接口，而不是包含 nil 指针的接口
+			if p == nil {
+				return nil
+			}
+			return p
+		}),
+		fx.Provide(NewMonitor),
+		fx.Invoke(func(lc fx.Lifecycle, m *Monitor) {
+			lc.Append(fx.Hook{
+				OnStart: m.Start,
+				OnStop:  m.Stop,
+			})
+		}),
+	)
+}
+
+// Provider USDT 收款渠道。每个订单分配一个唯一的转账金额，通过 Watcher 发现转入收款地址的
+// 对应金额的转账，达到确认数后确认支付
+type Provider struct {
+	Network Network
+	Address string
+	// 转账所需的区块确认数
+	Confirmations int
+	// 候选金额的步长与数量
+	Step  decimal.Decimal
+	Slots int
+	// 金额的保留时长，超时后释放供其他订单使用
+	Timeout time.Duration
+	// 转账查询结果的缓存时长，避免逐个订单重复查询数据源
+	CacheTTL time.Duration
+
+	driver  cache.Driver
+	rates   currency.Provider
+	watcher Watcher
+
+	// 保护金额分配与索引
+	mu sync.Mutex
+
+	transfersMu sync.Mutex
+	cached      *transferCache
+}
+
+type transferCache struct {
+	since     time.Time
+	fetchedAt time.Time
+	transfers []Transfer
+}
+
+// NewProvider 未配置 UsdtAddress 时返回 nil，不启用 USDT 渠道
+func NewProvider(conf *appconf.Config, driver cache.Driver, rates currency.Provider, watcher Watcher) (*Provider, error) {
+	if conf.UsdtAddress == "" {
+		return nil, nil
+	}
+
+	network, err := parseNetwork(conf.UsdtNetwork)
+	if err != nil {
+		return nil, err
+	}
+	step, err := decimal.NewFromString(conf.UsdtAmountStep)
+	if err != nil || !step.IsPositive() {
+		return nil, fmt.Errorf("USDT 金额步长 %q 无效", conf.UsdtAmountStep)
+	}
+	if conf.UsdtSlots < 1 {
+		return nil, errors.New("USDT 候选金额数量必须大于 0")
+	}
+
+	confirmations := conf.UsdtConfirmations
+	if confirmations <= 0 {
+		confirmations = networkDefaults[network].confirmations
+	}
+
+	return &Provider{
+		Network:       network,
+		Address:       conf.UsdtAddress,
+		Confirmations: confirmations,
+		Step:          step,
+		Slots:         conf.UsdtSlots,
+		Timeout:       conf.UsdtTimeout,
+		CacheTTL:      conf.UsdtPollInterval / 2,
+		driver:        driver,
+		rates:         rates,
+		watcher:       watcher,
+	}, nil
+}
+
+func (p *Provider) Name() string {
+	return ProviderName
+}
+
+// quote 将以分为单位的订单金额换算为 USDT，并按步长向上取整，商家不会少收
+func (p *Provider) quote(ctx context.Context, amount int, from string) (decimal.Decimal, error) {
+	money := decimal.New(int64(amount), -2)
+	if from = strings.ToUpper(from); from != "" && from != "USDT" {
+		rate, err := p.rates.Rate(ctx, "USDT", from)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		money = money.Div(rate)
+	}
+
+	money = money.Div(p.Step).Ceil().Mul(p.Step)
+	if !money.IsPositive() {
+		money = p.Step
+	}
+	return money, nil
+}
+
+// CreatePayment 为订单分配唯一金额，再次打开支付页面时在有效期内沿用已分配的金额
+func (p *Provider) CreatePayment(ctx context.Context, req *provider.PaymentRequest) (*provider.Checkout, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if a := p.assignment(req.OrderNo); a != nil {
+		if now.Before(a.ExpiresAt) {
+			return p.checkout(a), nil
+		}
+		if err := p.releaseLocked(a); err != nil {
+			return nil, err
+		}
+	}
+
+	base, err := p.quote(ctx, req.Amount, req.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := now.Add(p.Timeout)
+	if !req.ExpiresAt.IsZero() && req.ExpiresAt.Before(expiresAt) {
+		expiresAt = req.ExpiresAt
+	}
+
+	amount, err := p.allocate(req.OrderNo, base, expiresAt.Sub(now))
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Assignment{
+		OrderNo:   req.OrderNo,
+		Network:   p.Network,
+		Address:   p.Address,
+		Amount:    amount.String(),
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+	if err := p.save(a); err != nil {
+		return nil, err
+	}
+	return p.checkout(a), nil
+}
+
+func (p *Provider) checkout(a *Assignment) *provider.Checkout {
+	return &provider.Checkout{
+		QRCode: a.Address,
+		Transfer: &provider.TransferInfo{
+			Network:   strings.ToUpper(string(a.Network)),
+			Address:   a.Address,
+			Amount:    a.amount().StringFixed(p.Step.Exponent() * -1),
+			Currency:  "USDT",
+			ExpiresAt: a.ExpiresAt,
+		},
+	}
+}
+
+// transfers 查询 since 之后的转账，缓存有效期内覆盖该时间范围的查询结果会被复用
+func (p *Provider) transfers(ctx context.Context, since time.Time) ([]Transfer, error) {
+	p.transfersMu.Lock()
+	defer p.transfersMu.Unlock()
+
+	if c := p.cached; c != nil && !c.since.After(since) && time.Since(c.fetchedAt) < p.CacheTTL {
+		return c.transfers, nil
+	}
+
+	transfers, err := p.watcher.Transfers(ctx, p.Address, since)
+	if err != nil {
+		return nil, err
+	}
+	p.cached = &transferCache{since: since, fetchedAt: time.Now(), transfers: transfers}
+	return transfers, nil
+}
+
+// match 返回符合分配记录的最早一笔转账，没有时返回 nil
+func (p *Provider) match(ctx context.Context, a *Assignment) (*Transfer, error) {
+	transfers, err := p.transfers(ctx, a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	var matched *Transfer
+	for i := range transfers {
+		t := &transfers[i]
+		if t.To == a.Address && a.matches(t) && (matched == nil || t.Time.Before(matched.Time)) {
+			matched = t
+		}
+	}
+	return matched, nil
+}
+
+// result 转账金额与分配的金额一致即视为全额支付，实付金额按订单金额记录
+func (p *Provider) result(orderInfo *order.Order, t *Transfer) *provider.Result {
+	res := &provider.Result{
+		OrderNo: orderInfo.OrderNo,
+		Method:  provider.MethodUSDT,
+		Money:   orderInfo.Money().StringFixed(2),
+	}
+	if t != nil {
+		res.TradeNo = t.TxID
+		res.Paid = t.Confirmations >= p.Confirmations
+	}
+	return res
+}
+
+// VerifyNotification USDT 渠道由 Monitor 主动发现转账，没有异步通知
+func (p *Provider) VerifyNotification(r *http.Request, orderInfo *order.Order) (*provider.Result, error) {
+	return nil, &provider.VerifyError{
+		Reason: RejectNoNotification,
+		Err:    errors.New("USDT 渠道没有异步通知"),
+	}
+}
+
+// Query 查找转入分配金额的转账，达到确认数后视为已支付
+func (p *Provider) Query(ctx context.Context, orderInfo *order.Order) (*provider.Result, error) {
+	a := p.assignment(orderInfo.OrderNo)
+	if a == nil {
+		return nil, provider.ErrPaymentNotFound
+	}
+
+	t, err := p.match(ctx, a)
+	if err != nil {
+		return nil, err
+	}
+	return p.result(orderInfo, t), nil
+}
+
+// Close 订单过期时释放分配的金额。已收到转账但尚未确认时保留分配记录，由 Monitor 继续确认
+func (p *Provider) Close(ctx context.Context, orderInfo *order.Order) error {
+	a := p.assignment(orderInfo.OrderNo)
+	if a == nil {
+		return provider.ErrPaymentNotFound
+	}
+
+	t, err := p.match(ctx, a)
+	if err != nil {
+		return err
+	}
+	if t != nil {
+		return ErrTransferPending
+	}
+	return p.release(a)
+}
+
+func (p *Provider) Refund(ctx context.Context, orderInfo *order.Order, amount int) error {
+	return ErrRefundUnsupported
+}
+
+func (p *Provider) Ack(w http.ResponseWriter, err error) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("fail"))
+		return
+	}
+	_, _ = w.Write([]byte("success"))
+}
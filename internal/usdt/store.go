@@ -0,0 +1,166 @@
+package usdt
+
+import (
+	"encoding/gob"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/shopspring/decimal"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/cache"
+)
+
+const (
+	// slotPrefix 已分配金额在缓存中的键前缀，值为订单号
+	slotPrefix = "usdt_slot_"
+	// assignmentPrefix 订单分配记录在缓存中的键前缀
+	assignmentPrefix = "usdt_assignment_"
+	// indexKey 尚未释放的分配记录的订单号列表
+	indexKey = "usdt_assignment_index"
+)
+
+// ErrNoSlot 所有候选金额均已被占用
+var ErrNoSlot = errors.New("没有可用的 USDT 支付金额，请稍后重试")
+
+func init() {
+	gob.Register(&Assignment{})
+	cache.RegisterType("usdt.Assignment", &Assignment{})
+}
+
+// Assignment 分配给订单的唯一 USDT 金额。同一收款地址上，同一金额在有效期内只分配给一个订单，
+// 有效期内转入该金额的转账即视为该订单的付款
+type Assignment struct {
+	OrderNo string
+	Network Network
+	Address string
+	// 需转账的 USDT 金额
+	Amount    string
+	CreatedAt time.Time
+	// 超过该时间后到账的转账不再计入该订单
+	ExpiresAt time.Time
+}
+
+func (a *Assignment) amount() decimal.Decimal {
+	amount, _ := decimal.NewFromString(a.Amount)
+	return amount
+}
+
+// matches 返回转账的金额与时间是否符合分配记录
+func (a *Assignment) matches(t *Transfer) bool {
+	return t.Amount.Equal(a.amount()) && !t.Time.Before(a.CreatedAt) && !t.Time.After(a.ExpiresAt)
+}
+
+func slotKey(address string, amount decimal.Decimal) string {
+	return slotPrefix + address + "_" + amount.String()
+}
+
+// ttlSeconds 将有效期转换为缓存使用的秒数，不足一秒按一秒计算
+func ttlSeconds(d time.Duration) int {
+	return int(math.Max(1, math.Ceil(d.Seconds())))
+}
+
+// assignment 读取订单的分配记录
+func (p *Provider) assignment(orderNo string) *Assignment {
+	value, ok := p.driver.Get(assignmentPrefix + orderNo)
+	if !ok {
+		return nil
+	}
+	a, _ := value.(*Assignment)
+	return a
+}
+
+// allocate 从 base 开始按步长依次尝试候选金额，占用第一个未被其他订单使用的金额。
+// 金额通过 SetNX 占用，多个进程共用同一 Redis 时同一金额也不会分配给两个订单。调用方需持有 p.mu
+func (p *Provider) allocate(orderNo string, base decimal.Decimal, ttl time.Duration) (decimal.Decimal, error) {
+	for i := 0; i < p.Slots; i++ {
+		amount := base.Add(p.Step.Mul(decimal.NewFromInt(int64(i))))
+		key := slotKey(p.Address, amount)
+		taken, err := p.driver.SetNX(key, orderNo, ttlSeconds(ttl))
+		if err != nil {
+			return decimal.Zero, err
+		}
+		if !taken {
+			if owner, ok := p.driver.Get(key); !ok || owner != orderNo {
+				continue
+			}
+			// 金额已由同一订单占用，延长占用时间
+			if err := p.driver.Set(key, orderNo, ttlSeconds(ttl)); err != nil {
+				return decimal.Zero, err
+			}
+		}
+		return amount, nil
+	}
+	return decimal.Zero, ErrNoSlot
+}
+
+// save 保存分配记录并加入索引。调用方需持有 p.mu
+func (p *Provider) save(a *Assignment) error {
+	if err := p.driver.Set(assignmentPrefix+a.OrderNo, a, 0); err != nil {
+		return err
+	}
+
+	if lo.Contains(p.index(), a.OrderNo) {
+		return nil
+	}
+	return cache.Modify(p.driver, indexKey, 0, func(value interface{}) (interface{}, error) {
+		index, _ := value.([]string)
+		if lo.Contains(index, a.OrderNo) {
+			return index, nil
+		}
+		return append(append([]string(nil), index...), a.OrderNo), nil
+	})
+}
+
+func (p *Provider) index() []string {
+	value, ok := p.driver.Get(indexKey)
+	if !ok {
+		return nil
+	}
+	index, _ := value.([]string)
+	return append([]string(nil), index...)
+}
+
+// assignments 列出所有尚未释放的分配记录
+func (p *Provider) assignments() []*Assignment {
+	p.mu.Lock()
+	index := p.index()
+	p.mu.Unlock()
+
+	var assignments []*Assignment
+	for _, orderNo := range index {
+		if a := p.assignment(orderNo); a != nil {
+			assignments = append(assignments, a)
+		}
+	}
+	return assignments
+}
+
+// release 删除分配记录并释放其占用的金额
+func (p *Provider) release(a *Assignment) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.releaseLocked(a)
+}
+
+func (p *Provider) releaseLocked(a *Assignment) error {
+	key := slotKey(a.Address, a.amount())
+	if owner, ok := p.driver.Get(key); ok && owner == a.OrderNo {
+		if err := p.driver.Delete([]string{key}, ""); err != nil {
+			return err
+		}
+	}
+
+	// 分配记录可能已被同一订单的新分配覆盖
+	if current := p.assignment(a.OrderNo); current != nil && current.CreatedAt.Equal(a.CreatedAt) {
+		if err := p.driver.Delete([]string{assignmentPrefix + a.OrderNo}, ""); err != nil {
+			return err
+		}
+		return cache.Modify(p.driver, indexKey, 0, func(value interface{}) (interface{}, error) {
+			index, _ := value.([]string)
+			return lo.Without(index, a.OrderNo), nil
+		})
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package usdt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/imroc/req/v3"
+	"github.com/shopspring/decimal"
+)
+
+// TronGridWatcher 通过 TronGrid API 查询 TRC20 USDT 转账
+type TronGridWatcher struct {
+	Endpoint string
+	Contract string
+	APIKey   string
+	Client   *req.Client
+
+	mu sync.Mutex
+	// 已打包交易所在的区块高度
+	blocks map[string]int64
+}
+
+func (w *TronGridWatcher) request(ctx context.Context) *req.Request {
+	r := w.Client.R().SetContext(ctx)
+	if w.APIKey != "" {
+		r.SetHeader("TRON-PRO-API-KEY", w.APIKey)
+	}
+	return r
+}
+
+// post 调用 TronGrid 的 /wallet 接口
+func (w *TronGridWatcher) post(ctx context.Context, path string, body interface{}, result interface{}) error {
+	resp, err := w.request(ctx).SetBodyJsonMarshal(body).Post(w.Endpoint + path)
+	if err != nil {
+		return err
+	}
+	if !resp.IsSuccessState() {
+		return fmt.Errorf("TronGrid 返回 HTTP %d", resp.StatusCode)
+	}
+	return json.Unmarshal(resp.Bytes(), result)
+}
+
+type trc20Response struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error"`
+	Data    []struct {
+		TransactionID string `json:"transaction_id"`
+		TokenInfo     struct {
+			Address  string `json:"address"`
+			Decimals int32  `json:"decimals"`
+		} `json:"token_info"`
+		BlockTimestamp int64  `json:"block_timestamp"`
+		From           string `json:"from"`
+		To             string `json:"to"`
+		Type           string `json:"type"`
+		Value          string `json:"value"`
+	} `json:"data"`
+}
+
+// Transfers 最多返回最近的 200 笔转账
+func (w *TronGridWatcher) Transfers(ctx context.Context, address string, since time.Time) ([]Transfer, error) {
+	resp, err := w.request(ctx).
+		SetQueryParams(map[string]string{
+			"only_to":          "true",
+			"contract_address": w.Contract,
+			"min_timestamp":    strconv.FormatInt(since.UnixMilli(), 10),
+			"limit":            "200",
+		}).
+		Get(w.Endpoint + "/v1/accounts/" + address + "/transactions/trc20")
+	if err != nil {
+		return nil, err
+	}
+	if !resp.IsSuccessState() {
+		return nil, fmt.Errorf("TronGrid 返回 HTTP %d", resp.StatusCode)
+	}
+
+	var res trc20Response
+	if err := json.Unmarshal(resp.Bytes(), &res); err != nil {
+		return nil, fmt.Errorf("无法解析 TronGrid 响应: %w", err)
+	}
+	if !res.Success {
+		return nil, fmt.Errorf("TronGrid 返回错误: %s", res.Error)
+	}
+	if len(res.Data) == 0 {
+		return nil, nil
+	}
+
+	head, err := w.nowBlock(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var transfers []Transfer
+	for _, item := range res.Data {
+		if item.Type != "Transfer" || item.To != address || item.TokenInfo.Address != w.Contract {
+			continue
+		}
+		value, err := decimal.NewFromString(item.Value)
+		if err != nil {
+			continue
+		}
+
+		block, err := w.block(ctx, item.TransactionID)
+		if err != nil {
+			return nil, err
+		}
+		confirmations := 0
+		if block > 0 && head >= block {
+			confirmations = int(head - block + 1)
+		}
+
+		transfers = append(transfers, Transfer{
+			TxID:          item.TransactionID,
+			From:          item.From,
+			To:            item.To,
+			Amount:        value.Shift(-item.TokenInfo.Decimals),
+			Confirmations: confirmations,
+			Time:          time.UnixMilli(item.BlockTimestamp),
+		})
+	}
+	return transfers, nil
+}
+
+// nowBlock 返回最新区块高度
+func (w *TronGridWatcher) nowBlock(ctx context.Context) (int64, error) {
+	var res struct {
+		BlockHeader struct {
+			RawData struct {
+				Number int64 `json:"number"`
+			} `json:"raw_data"`
+		} `json:"block_header"`
+	}
+	if err := w.post(ctx, "/wallet/getnowblock", map[string]string{}, &res); err != nil {
+		return 0, err
+	}
+	if res.BlockHeader.RawData.Number == 0 {
+		return 0, errors.New("无法获取 TRON 最新区块高度")
+	}
+	return res.BlockHeader.RawData.Number, nil
+}
+
+// block 返回交易所在的区块高度，尚未打包时返回 0
+func (w *TronGridWatcher) block(ctx context.Context, txID string) (int64, error) {
+	w.mu.Lock()
+	block, ok := w.blocks[txID]
+	w.mu.Unlock()
+	if ok {
+		return block, nil
+	}
+
+	var res struct {
+		BlockNumber int64 `json:"blockNumber"`
+	}
+	if err := w.post(ctx, "/wallet/gettransactioninfobyid", map[string]string{"value": txID}, &res); err != nil {
+		return 0, err
+	}
+
+	if res.BlockNumber > 0 {
+		w.mu.Lock()
+		if w.blocks == nil {
+			w.blocks = map[string]int64{}
+		}
+		w.blocks[txID] = res.BlockNumber
+		w.mu.Unlock()
+	}
+	return res.BlockNumber, nil
+}
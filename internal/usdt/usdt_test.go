@@ -0,0 +1,249 @@
+package usdt
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/imroc/req/v3"
+	"github.com/shopspring/decimal"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/cache"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/currency"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/outbox"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/payment"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/provider"
+)
+
+const testAddress = "TXYZtestReceivingAddress0000000000"
+
+// fakeWatcher 模拟区块链数据源，测试中直接添加转账
+type fakeWatcher struct {
+	mu        sync.Mutex
+	transfers []Transfer
+	err       error
+}
+
+func (w *fakeWatcher) Transfers(ctx context.Context, address string, since time.Time) ([]Transfer, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return nil, w.err
+	}
+	var transfers []Transfer
+	for _, t := range w.transfers {
+		if t.To == address && !t.Time.Before(since) {
+			transfers = append(transfers, t)
+		}
+	}
+	return transfers, nil
+}
+
+// send 模拟一笔转入收款地址的转账
+func (w *fakeWatcher) send(txID, amount string, confirmations int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.transfers = append(w.transfers, Transfer{
+		TxID:          txID,
+		To:            testAddress,
+		Amount:        decimal.RequireFromString(amount),
+		Confirmations: confirmations,
+		Time:          time.Now(),
+	})
+}
+
+// confirm 更新转账的确认数
+func (w *fakeWatcher) confirm(txID string, confirmations int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i := range w.transfers {
+		if w.transfers[i].TxID == txID {
+			w.transfers[i].Confirmations = confirmations
+		}
+	}
+}
+
+func newTestProvider(t *testing.T, slots int) (*Provider, *fakeWatcher) {
+	watcher := &fakeWatcher{}
+	return &Provider{
+		Network:       NetworkTRC20,
+		Address:       testAddress,
+		Confirmations: 3,
+		Step:          decimal.RequireFromString("0.01"),
+		Slots:         slots,
+		Timeout:       30 * time.Minute,
+		driver:        cache.NewMemoStore(),
+		rates:         currency.TableProvider{"USDT": decimal.RequireFromString("7.2")},
+		watcher:       watcher,
+	}, watcher
+}
+
+func create(t *testing.T, p *Provider, orderNo string, amount int) *provider.TransferInfo {
+	t.Helper()
+
+	checkout, err := p.CreatePayment(context.Background(), &provider.PaymentRequest{
+		OrderNo:  orderNo,
+		Amount:   amount,
+		Currency: "CNY",
+		Method:   provider.MethodUSDT,
+	})
+	if err != nil {
+		t.Fatalf("订单 %s 无法分配金额: %v", orderNo, err)
+	}
+	if checkout.Transfer == nil || checkout.QRCode != testAddress {
+		t.Fatalf("应返回收款信息: %+v", checkout)
+	}
+	return checkout.Transfer
+}
+
+func TestUniqueAmounts(t *testing.T) {
+	p, _ := newTestProvider(t, 3)
+
+	// 72 元按 7.2 的汇率换算为 10 USDT，相同金额的订单依次分配不同的金额
+	for i, want := range []string{"10.00", "10.01", "10.02"} {
+		if got := create(t, p, "o"+strconv.Itoa(i+1), 7200).Amount; got != want {
+			t.Fatalf("第 %d 个订单应分配 %s，实际为 %s", i+1, want, got)
+		}
+	}
+
+	if _, err := p.CreatePayment(context.Background(), &provider.PaymentRequest{OrderNo: "o4", Amount: 7200, Currency: "CNY"}); !errors.Is(err, ErrNoSlot) {
+		t.Fatalf("金额用尽时应返回 ErrNoSlot，实际为 %v", err)
+	}
+
+	// 再次打开支付页面沿用已分配的金额
+	if got := create(t, p, "o1", 7200).Amount; got != "10.00" {
+		t.Fatalf("再次打开支付页面应沿用 10.00，实际为 %s", got)
+	}
+
+	// 不足一个步长的部分向上取整为 10.01，10.01 与 10.02 已被占用
+	if got := create(t, p, "o5", 7201).Amount; got != "10.03" {
+		t.Fatalf("应分配 10.03，实际为 %s", got)
+	}
+}
+
+func TestQueryConfirmations(t *testing.T) {
+	p, watcher := newTestProvider(t, 10)
+	orderInfo := &order.Order{OrderNo: "o1", Amount: 7200, Currency: "CNY"}
+
+	if _, err := p.Query(context.Background(), orderInfo); !errors.Is(err, provider.ErrPaymentNotFound) {
+		t.Fatalf("未分配金额的订单应返回 ErrPaymentNotFound，实际为 %v", err)
+	}
+
+	// 分配前的转账不计入订单
+	watcher.send("early", "10.00", 10)
+	time.Sleep(10 * time.Millisecond)
+	transfer := create(t, p, "o1", 7200)
+
+	res, err := p.Query(context.Background(), orderInfo)
+	if err != nil || res.Paid || res.TradeNo != "" {
+		t.Fatalf("没有转账时不应视为已支付: %+v, %v", res, err)
+	}
+
+	watcher.send("other", "10.01", 10)
+	watcher.send("tx1", transfer.Amount, 1)
+	res, err = p.Query(context.Background(), orderInfo)
+	if err != nil || res.Paid || res.TradeNo != "tx1" {
+		t.Fatalf("确认数不足时不应视为已支付: %+v, %v", res, err)
+	}
+
+	watcher.confirm("tx1", 3)
+	res, err = p.Query(context.Background(), orderInfo)
+	if err != nil || !res.Paid || res.TradeNo != "tx1" || res.Money != "72.00" || res.Method != provider.MethodUSDT {
+		t.Fatalf("达到确认数后应视为已支付: %+v, %v", res, err)
+	}
+}
+
+func TestExpiryFreesSlot(t *testing.T) {
+	p, watcher := newTestProvider(t, 10)
+	p.Timeout = 50 * time.Millisecond
+	monitor := &Monitor{provider: p}
+
+	if got := create(t, p, "o1", 7200).Amount; got != "10.00" {
+		t.Fatalf("应分配 10.00，实际为 %s", got)
+	}
+	if got := create(t, p, "o2", 7200).Amount; got != "10.01" {
+		t.Fatalf("应分配 10.01，实际为 %s", got)
+	}
+	// o2 已收到转账，正在等待确认
+	watcher.send("tx2", "10.01", 1)
+
+	time.Sleep(100 * time.Millisecond)
+	monitor.Poll(context.Background())
+
+	if p.assignment("o1") != nil {
+		t.Fatal("超时未收到转账的分配记录应被释放")
+	}
+	if p.assignment("o2") == nil {
+		t.Fatal("已收到转账的分配记录不应被释放")
+	}
+	if err := p.Close(context.Background(), &order.Order{OrderNo: "o2"}); !errors.Is(err, ErrTransferPending) {
+		t.Fatalf("关闭已收到转账的订单应返回 ErrTransferPending，实际为 %v", err)
+	}
+
+	// 释放的金额可以分配给新订单
+	p.Timeout = 30 * time.Minute
+	if got := create(t, p, "o3", 7200).Amount; got != "10.00" {
+		t.Fatalf("释放的金额应可重新分配，实际为 %s", got)
+	}
+	if err := p.Close(context.Background(), &order.Order{OrderNo: "o3"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := create(t, p, "o4", 7200).Amount; got != "10.00" {
+		t.Fatalf("关闭订单后金额应可重新分配，实际为 %s", got)
+	}
+
+	// 数据源不可用时保留所有分配记录
+	watcher.err = errors.New("数据源不可用")
+	monitor.Poll(context.Background())
+	if p.assignment("o4") == nil {
+		t.Fatal("数据源不可用时不应释放分配记录")
+	}
+}
+
+func TestMonitorConfirms(t *testing.T) {
+	p, watcher := newTestProvider(t, 10)
+	driver := p.driver
+
+	conf := &appconf.Config{PaymentProvider: ProviderName}
+	registry, err := provider.NewRegistry(conf, []provider.Provider{p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders := order.NewCacheRepository(driver)
+	store := outbox.NewStore(driver)
+	monitor := &Monitor{
+		provider: p,
+		service:  payment.NewService(conf, registry, orders, store, driver, req.C()),
+		orders:   orders,
+	}
+
+	orderInfo := order.New("o1", "测试商品", "", 7200, "CNY", time.Hour)
+	orderInfo.Provider = ProviderName
+	if err := orders.Save(orderInfo); err != nil {
+		t.Fatal(err)
+	}
+	transfer := create(t, p, "o1", 7200)
+
+	watcher.send("tx1", transfer.Amount, 1)
+	monitor.Poll(context.Background())
+	if got, _ := orders.Get("o1"); got.IsPaid() {
+		t.Fatal("确认数不足时不应确认支付")
+	}
+
+	watcher.confirm("tx1", 3)
+	monitor.Poll(context.Background())
+	got, _ := orders.Get("o1")
+	if got.Status != order.StatusPaid || got.TradeNo != "tx1" || got.PaymentType != provider.MethodUSDT {
+		t.Fatalf("达到确认数后应确认支付: %+v", got)
+	}
+	if p.assignment("o1") != nil {
+		t.Fatal("确认支付后应释放分配记录")
+	}
+}
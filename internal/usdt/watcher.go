@@ -0,0 +1,101 @@
+package usdt
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/imroc/req/v3"
+	"github.com/shopspring/decimal"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+)
+
+// Network USDT 所在的区块链网络
+type Network string
+
+const (
+	NetworkTRC20 Network = "trc20"
+	NetworkERC20 Network = "erc20"
+)
+
+// networkDefaults 各网络默认的 USDT 合约、数据源地址与确认数
+var networkDefaults = map[Network]struct {
+	contract      string
+	endpoint      string
+	confirmations int
+}{
+	NetworkTRC20: {
+		contract:      "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t",
+		endpoint:      "https://api.trongrid.io",
+		confirmations: 19,
+	},
+	NetworkERC20: {
+		contract:      "0xdAC17F958D2ee523a2206206994597C13D831ec7",
+		endpoint:      "https://api.etherscan.io/v2/api",
+		confirmations: 12,
+	},
+}
+
+// parseNetwork 解析配置中的网络名称
+func parseNetwork(value string) (Network, error) {
+	network := Network(strings.ToLower(strings.TrimSpace(value)))
+	if _, ok := networkDefaults[network]; !ok {
+		return "", fmt.Errorf("不支持的 USDT 网络 %q", value)
+	}
+	return network, nil
+}
+
+// Transfer 转入收款地址的一笔 USDT 转账
+type Transfer struct {
+	TxID   string
+	From   string
+	To     string
+	Amount decimal.Decimal
+	// 转账所在区块及之后的区块数，尚未打包时为 0
+	Confirmations int
+	Time          time.Time
+}
+
+// Watcher 查询收款地址收到的 USDT 转账，可替换为其他区块链数据源
+type Watcher interface {
+	// Transfers 返回 since 之后转入 address 的转账
+	Transfers(ctx context.Context, address string, since time.Time) ([]Transfer, error)
+}
+
+// NewWatcher 按配置的网络创建数据源，TRC20 使用 TronGrid，ERC20 使用 Etherscan。
+// 未配置收款地址时返回 nil
+func NewWatcher(conf *appconf.Config, client *req.Client) (Watcher, error) {
+	if conf.UsdtAddress == "" {
+		return nil, nil
+	}
+
+	network, err := parseNetwork(conf.UsdtNetwork)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := conf.UsdtAPIEndpoint
+	if endpoint == "" {
+		endpoint = networkDefaults[network].endpoint
+	}
+	contract := conf.UsdtContract
+	if contract == "" {
+		contract = networkDefaults[network].contract
+	}
+
+	if network == NetworkERC20 {
+		return &EtherscanWatcher{
+			Endpoint: endpoint,
+			Contract: contract,
+			APIKey:   conf.UsdtAPIKey,
+			Client:   client,
+		}, nil
+	}
+	return &TronGridWatcher{
+		Endpoint: strings.TrimSuffix(endpoint, "/"),
+		Contract: contract,
+		APIKey:   conf.UsdtAPIKey,
+		Client:   client,
+	}, nil
+}
@@ -0,0 +1,172 @@
+package usdt
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/imroc/req/v3"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/provider"
+)
+
+func TestTronGridWatcher(t *testing.T) {
+	contract := networkDefaults[NetworkTRC20].contract
+	now := time.Now()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("TRON-PRO-API-KEY") != "key" {
+			t.Errorf("缺少 API Key")
+		}
+		switch r.URL.Path {
+		case "/v1/accounts/" + testAddress + "/transactions/trc20":
+			if r.URL.Query().Get("only_to") != "true" || r.URL.Query().Get("contract_address") != contract {
+				t.Errorf("查询参数错误: %s", r.URL.RawQuery)
+			}
+			transfer := func(txID, to, token, value string) map[string]interface{} {
+				return map[string]interface{}{
+					"transaction_id":  txID,
+					"token_info":      map[string]interface{}{"address": token, "decimals": 6},
+					"block_timestamp": now.UnixMilli(),
+					"from":            "TSender",
+					"to":              to,
+					"type":            "Transfer",
+					"value":           value,
+				}
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"success": true,
+				"data": []interface{}{
+					transfer("tx1", testAddress, contract, "10010000"),
+					transfer("pending", testAddress, contract, "5000000"),
+					transfer("fake-token", testAddress, "TFakeToken", "10010000"),
+				},
+			})
+		case "/wallet/getnowblock":
+			_, _ = w.Write([]byte(`{"block_header":{"raw_data":{"number":1000}}}`))
+		case "/wallet/gettransactioninfobyid":
+			var body map[string]string
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if body["value"] == "tx1" {
+				_, _ = w.Write([]byte(`{"id":"tx1","blockNumber":990}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	watcher := &TronGridWatcher{Endpoint: server.URL, Contract: contract, APIKey: "key", Client: req.C()}
+	transfers, err := watcher.Transfers(context.Background(), testAddress, now.Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 2 {
+		t.Fatalf("应忽略其他代币的转账: %+v", transfers)
+	}
+	if transfers[0].TxID != "tx1" || transfers[0].Amount.String() != "10.01" || transfers[0].Confirmations != 11 {
+		t.Fatalf("转账解析错误: %+v", transfers[0])
+	}
+	if transfers[1].Confirmations != 0 {
+		t.Fatalf("尚未打包的转账确认数应为 0: %+v", transfers[1])
+	}
+}
+
+func TestEtherscanWatcher(t *testing.T) {
+	contract := networkDefaults[NetworkERC20].contract
+	address := "0x00000000000000000000000000000000000000aa"
+	now := time.Now()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("action") != "tokentx" || query.Get("address") != address || query.Get("apikey") != "key" {
+			t.Errorf("查询参数错误: %s", r.URL.RawQuery)
+		}
+		tx := func(hash, to, timestamp string) map[string]string {
+			return map[string]string{
+				"hash":            hash,
+				"from":            "0x00000000000000000000000000000000000000bb",
+				"to":              to,
+				"contractAddress": "0xdac17f958d2ee523a2206206994597c13d831ec7",
+				"value":           "25500000",
+				"tokenDecimal":    "6",
+				"confirmations":   "15",
+				"timeStamp":       timestamp,
+			}
+		}
+		ts := func(t time.Time) string { return strconv.FormatInt(t.Unix(), 10) }
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "1",
+			"message": "OK",
+			"result": []interface{}{
+				tx("0x01", "0x00000000000000000000000000000000000000AA", ts(now)),
+				tx("0x02", "0x00000000000000000000000000000000000000cc", ts(now)),
+				tx("0x03", address, ts(now.Add(-time.Hour))),
+			},
+		})
+	}))
+	defer server.Close()
+
+	watcher := &EtherscanWatcher{Endpoint: server.URL, Contract: contract, APIKey: "key", Client: req.C()}
+	transfers, err := watcher.Transfers(context.Background(), address, now.Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(transfers) != 1 || transfers[0].TxID != "0x01" || transfers[0].Amount.String() != "25.5" || transfers[0].Confirmations != 15 {
+		t.Fatalf("应只返回转入收款地址且在 since 之后的转账: %+v", transfers)
+	}
+}
+
+func TestEtherscanChecksumAddress(t *testing.T) {
+	// 以 EIP-55 校验和格式配置的收款地址，Etherscan 返回小写地址
+	address := "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  "1",
+			"message": "OK",
+			"result": []map[string]string{{
+				"hash":            "0x01",
+				"from":            "0x00000000000000000000000000000000000000bb",
+				"to":              strings.ToLower(address),
+				"contractAddress": networkDefaults[NetworkERC20].contract,
+				"value":           "10000000",
+				"tokenDecimal":    "6",
+				"confirmations":   "15",
+				"timeStamp":       strconv.FormatInt(time.Now().Add(time.Minute).Unix(), 10),
+			}},
+		})
+	}))
+	defer server.Close()
+
+	p, _ := newTestProvider(t, 3)
+	p.Network = NetworkERC20
+	p.Address = address
+	p.watcher = &EtherscanWatcher{Endpoint: server.URL, Contract: networkDefaults[NetworkERC20].contract, APIKey: "key", Client: req.C()}
+
+	// 72 元按 7.2 的汇率换算为 10 USDT
+	orderInfo := order.New("o1", "测试商品", "", 7200, "CNY", time.Hour)
+	if _, err := p.CreatePayment(context.Background(), &provider.PaymentRequest{
+		OrderNo:  orderInfo.OrderNo,
+		Amount:   orderInfo.Amount,
+		Currency: "CNY",
+		Method:   provider.MethodUSDT,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := p.Query(context.Background(), orderInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Paid || res.TradeNo != "0x01" {
+		t.Fatalf("转入校验和格式地址的转账未被匹配: %+v", res)
+	}
+}
@@ -0,0 +1,395 @@
+package wechatpay
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/imroc/req/v3"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/epay"
+)
+
+// DefaultEndpoint 微信支付 API v3 地址
+const DefaultEndpoint = "https://api.mch.weixin.qq.com"
+
+// authSchema 请求签名使用的认证类型
+const authSchema = "WECHATPAY2-SHA256-RSA2048"
+
+// maxClockSkew 异步通知的时间戳与当前时间相差超过该值时视为重放
+const maxClockSkew = 5 * time.Minute
+
+// refreshInterval 两次下载平台证书的最小间隔，避免伪造的证书序列号频繁触发下载
+const refreshInterval = time.Minute
+
+var (
+	ErrSignMismatch     = errors.New("微信支付签名验证失败")
+	ErrDecrypt          = errors.New("无法解密微信支付通知")
+	ErrMerchantMismatch = errors.New("通知中的商户号或 AppID 与配置不符")
+)
+
+// APIError 微信支付接口返回的错误
+type APIError struct {
+	StatusCode int
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("微信支付接口返回错误 HTTP %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Config 微信支付商户配置
+type Config struct {
+	MchID string
+	AppID string
+	// 商户 API 证书序列号
+	SerialNo string
+	// 商户 API 私钥，用于请求签名
+	PrivateKey *rsa.PrivateKey
+	// APIv3 密钥，用于解密异步通知与平台证书
+	APIV3Key string
+	Endpoint string
+	// 预先配置的平台证书，遇到未知序列号时从 /v3/certificates 下载
+	Certificates []*x509.Certificate
+	HTTPClient   *req.Client
+}
+
+// Client 微信支付 API v3 客户端
+type Client struct {
+	Config *Config
+
+	mu           sync.RWMutex
+	certificates map[string]*rsa.PublicKey
+	refreshedAt  time.Time
+}
+
+func NewClient(config *Config) *Client {
+	if config.Endpoint == "" {
+		config.Endpoint = DefaultEndpoint
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = req.C()
+	}
+
+	c := &Client{
+		Config:       config,
+		certificates: map[string]*rsa.PublicKey{},
+	}
+	for _, cert := range config.Certificates {
+		if pub, ok := cert.PublicKey.(*rsa.PublicKey); ok {
+			c.certificates[certificateSerial(cert)] = pub
+		}
+	}
+	return c
+}
+
+// certificateSerial 平台证书序列号，与 Wechatpay-Serial 头一致使用大写十六进制
+func certificateSerial(cert *x509.Certificate) string {
+	return normalizeSerial(fmt.Sprintf("%X", cert.SerialNumber))
+}
+
+func normalizeSerial(serial string) string {
+	return strings.TrimLeft(strings.ToUpper(strings.TrimSpace(serial)), "0")
+}
+
+// LoadCertificate 从 PEM 内容或 PEM 文件中加载平台证书
+func LoadCertificate(value string) (*x509.Certificate, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, errors.New("证书为空")
+	}
+	if !strings.Contains(value, "-----BEGIN") {
+		content, err := os.ReadFile(value)
+		if err != nil {
+			return nil, err
+		}
+		value = string(content)
+	}
+
+	block, _ := pem.Decode([]byte(value))
+	if block == nil {
+		return nil, errors.New("无法解析 PEM 内容")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// authorization 生成请求的 Authorization 头，签名串为
+// 请求方法\n请求路径\n时间戳\n随机串\n请求体\n
+func (c *Client) authorization(method, path string, body []byte) (string, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	nonceStr := hex.EncodeToString(nonce)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	signer := &epay.RSASigner{PrivateKey: c.Config.PrivateKey}
+	sign, err := signer.Sign(method + "\n" + path + "\n" + timestamp + "\n" + nonceStr + "\n" + string(body) + "\n")
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf(`%s mchid="%s",nonce_str="%s",signature="%s",timestamp="%s",serial_no="%s"`,
+		authSchema, c.Config.MchID, nonceStr, sign, timestamp, c.Config.SerialNo), nil
+}
+
+// do 调用接口并校验应答签名，应答成功时将应答体解析到 result
+func (c *Client) do(ctx context.Context, method, path string, body interface{}, result interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	resp, err := c.send(ctx, method, path, payload)
+	if err != nil {
+		return err
+	}
+
+	if !resp.IsSuccessState() {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		_ = json.Unmarshal(resp.Bytes(), apiErr)
+		return apiErr
+	}
+
+	if err := c.verify(ctx, resp.Header, resp.Bytes(), false); err != nil {
+		return err
+	}
+
+	if result == nil || len(resp.Bytes()) == 0 {
+		return nil
+	}
+	return json.Unmarshal(resp.Bytes(), result)
+}
+
+func (c *Client) send(ctx context.Context, method, path string, payload []byte) (*req.Response, error) {
+	auth, err := c.authorization(method, path, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	r := c.Config.HTTPClient.R().
+		SetContext(ctx).
+		SetHeader("Authorization", auth).
+		SetHeader("Accept", "application/json")
+	if payload != nil {
+		r.SetHeader("Content-Type", "application/json").SetBodyBytes(payload)
+	}
+	return r.Send(method, c.Config.Endpoint+path)
+}
+
+// verify 使用 Wechatpay-Serial 对应的平台证书校验应答或通知的签名，签名串为
+// 时间戳\n随机串\n应答体\n。checkTime 为 true 时拒绝时间戳偏差过大的请求
+func (c *Client) verify(ctx context.Context, header http.Header, body []byte, checkTime bool) error {
+	timestamp := header.Get("Wechatpay-Timestamp")
+	nonce := header.Get("Wechatpay-Nonce")
+	sign := header.Get("Wechatpay-Signature")
+	if timestamp == "" || nonce == "" || sign == "" {
+		return ErrSignMismatch
+	}
+
+	if checkTime {
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return ErrSignMismatch
+		}
+		if skew := time.Since(time.Unix(ts, 0)); skew > maxClockSkew || skew < -maxClockSkew {
+			return fmt.Errorf("%w: 时间戳偏差过大", ErrSignMismatch)
+		}
+	}
+
+	pub, err := c.certificate(ctx, header.Get("Wechatpay-Serial"))
+	if err != nil {
+		return err
+	}
+
+	return verifyWith(pub, timestamp+"\n"+nonce+"\n"+string(body)+"\n", sign)
+}
+
+func verifyWith(pub *rsa.PublicKey, message, sign string) error {
+	signer := &epay.RSASigner{PlatformPublicKey: pub}
+	if err := signer.Verify(message, sign); err != nil {
+		return ErrSignMismatch
+	}
+	return nil
+}
+
+// certificate 按序列号获取平台证书公钥，未知序列号时重新下载平台证书
+func (c *Client) certificate(ctx context.Context, serial string) (*rsa.PublicKey, error) {
+	serial = normalizeSerial(serial)
+
+	c.mu.RLock()
+	pub, ok := c.certificates[serial]
+	c.mu.RUnlock()
+	if ok {
+		return pub, nil
+	}
+
+	if err := c.RefreshCertificates(ctx); err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	pub, ok = c.certificates[serial]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: 未知的平台证书序列号 %s", ErrSignMismatch, serial)
+	}
+	return pub, nil
+}
+
+// certificatesRes /v3/certificates 的应答
+type certificatesRes struct {
+	Data []struct {
+		SerialNo           string            `json:"serial_no"`
+		EncryptCertificate EncryptedResource `json:"encrypt_certificate"`
+	} `json:"data"`
+}
+
+// RefreshCertificates 下载平台证书，应答使用下载到的证书校验签名。距上次下载不足
+// refreshInterval 时直接返回
+func (c *Client) RefreshCertificates(ctx context.Context) error {
+	c.mu.Lock()
+	if time.Since(c.refreshedAt) < refreshInterval {
+		c.mu.Unlock()
+		return nil
+	}
+	c.refreshedAt = time.Now()
+	c.mu.Unlock()
+
+	resp, err := c.send(ctx, http.MethodGet, "/v3/certificates", nil)
+	if err != nil {
+		return err
+	}
+	if !resp.IsSuccessState() {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		_ = json.Unmarshal(resp.Bytes(), apiErr)
+		return apiErr
+	}
+
+	var res certificatesRes
+	if err := json.Unmarshal(resp.Bytes(), &res); err != nil {
+		return fmt.Errorf("无法解析平台证书: %w", err)
+	}
+
+	downloaded := map[string]*rsa.PublicKey{}
+	for _, item := range res.Data {
+		plain, err := c.Decrypt(&item.EncryptCertificate)
+		if err != nil {
+			return err
+		}
+		cert, err := LoadCertificate(string(plain))
+		if err != nil {
+			return fmt.Errorf("无法解析平台证书: %w", err)
+		}
+		pub, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("平台证书不是 RSA 证书")
+		}
+		downloaded[certificateSerial(cert)] = pub
+	}
+
+	pub, ok := downloaded[normalizeSerial(resp.Header.Get("Wechatpay-Serial"))]
+	if !ok {
+		return fmt.Errorf("%w: 平台证书应答的签名证书不在下载的证书中", ErrSignMismatch)
+	}
+	header := resp.Header
+	if err := verifyWith(pub, header.Get("Wechatpay-Timestamp")+"\n"+header.Get("Wechatpay-Nonce")+"\n"+string(resp.Bytes())+"\n", header.Get("Wechatpay-Signature")); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	for serial, pub := range downloaded {
+		c.certificates[serial] = pub
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// EncryptedResource 使用 APIv3 密钥加密的数据
+type EncryptedResource struct {
+	Algorithm      string `json:"algorithm"`
+	Ciphertext     string `json:"ciphertext"`
+	AssociatedData string `json:"associated_data"`
+	OriginalType   string `json:"original_type,omitempty"`
+	Nonce          string `json:"nonce"`
+}
+
+// Decrypt 使用 APIv3 密钥解密 AEAD_AES_256_GCM 加密的数据
+func (c *Client) Decrypt(res *EncryptedResource) ([]byte, error) {
+	if res.Algorithm != "AEAD_AES_256_GCM" {
+		return nil, fmt.Errorf("%w: 不支持的加密算法 %s", ErrDecrypt, res.Algorithm)
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(res.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	block, err := aes.NewCipher([]byte(c.Config.APIV3Key))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	gcm, err := cipher.NewGCMWithNonceSize(block, len(res.Nonce))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+
+	plain, err := gcm.Open(nil, []byte(res.Nonce), ciphertext, []byte(res.AssociatedData))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	return plain, nil
+}
+
+// Notification 异步通知
+type Notification struct {
+	ID           string            `json:"id"`
+	CreateTime   string            `json:"create_time"`
+	EventType    string            `json:"event_type"`
+	ResourceType string            `json:"resource_type"`
+	Summary      string            `json:"summary"`
+	Resource     EncryptedResource `json:"resource"`
+}
+
+// VerifyNotification 校验支付通知的签名与时间戳，解密后返回交易信息，并检查商户号与 AppID
+func (c *Client) VerifyNotification(ctx context.Context, header http.Header, body []byte) (*Transaction, error) {
+	if err := c.verify(ctx, header, body, true); err != nil {
+		return nil, err
+	}
+
+	var notification Notification
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return nil, fmt.Errorf("无法解析通知内容: %w", err)
+	}
+
+	plain, err := c.Decrypt(&notification.Resource)
+	if err != nil {
+		return nil, err
+	}
+
+	var transaction Transaction
+	if err := json.Unmarshal(plain, &transaction); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecrypt, err)
+	}
+	if transaction.MchID != c.Config.MchID || transaction.AppID != c.Config.AppID {
+		return nil, ErrMerchantMismatch
+	}
+	return &transaction, nil
+}
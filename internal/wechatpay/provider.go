@@ -0,0 +1,207 @@
+package wechatpay
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/imroc/req/v3"
+	"github.com/shopspring/decimal"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/appconf"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/epay"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/provider"
+	"go.uber.org/fx"
+)
+
+// ProviderName 微信支付渠道的名称
+const ProviderName = "wechatpay"
+
+// 异步通知验签失败的原因代码
+const (
+	RejectSignMismatch     = "wechatpay_sign_mismatch"
+	RejectDecryptFailed    = "wechatpay_decrypt_failed"
+	RejectMerchantMismatch = "wechatpay_merchant_mismatch"
+)
+
+// 异步通知请求体的大小上限
+const maxNotificationSize = 1 << 20
+
+func Module() fx.Option {
+	return fx.Module("wechatpay",
+		provider.Register(NewProvider),
+	)
+}
+
+// Provider 微信支付 API v3 支付渠道
+type Provider struct {
+	client *Client
+}
+
+// NewProvider 未配置 WechatpayMchID 时返回 nil，不启用微信支付渠道
+func NewProvider(conf *appconf.Config, client *req.Client) (provider.Provider, error) {
+	if conf.WechatpayMchID == "" {
+		return nil, nil
+	}
+
+	if len(conf.WechatpayAPIV3Key) != 32 {
+		return nil, errors.New("微信支付 APIv3 密钥长度必须为 32 字节")
+	}
+	priv, err := epay.LoadPrivateKey(conf.WechatpayPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("无法加载微信支付商户私钥: %w", err)
+	}
+
+	var certificates []*x509.Certificate
+	if conf.WechatpayPlatformCertificate != "" {
+		cert, err := LoadCertificate(conf.WechatpayPlatformCertificate)
+		if err != nil {
+			return nil, fmt.Errorf("无法加载微信支付平台证书: %w", err)
+		}
+		certificates = append(certificates, cert)
+	}
+
+	return &Provider{
+		client: NewClient(&Config{
+			MchID:        conf.WechatpayMchID,
+			AppID:        conf.WechatpayAppID,
+			SerialNo:     conf.WechatpaySerialNo,
+			PrivateKey:   priv,
+			APIV3Key:     conf.WechatpayAPIV3Key,
+			Endpoint:     conf.WechatpayEndpoint,
+			Certificates: certificates,
+			HTTPClient:   client,
+		}),
+	}, nil
+}
+
+func (p *Provider) Name() string {
+	return ProviderName
+}
+
+// SupportsCurrency 微信支付只能以人民币收款
+func (p *Provider) SupportsCurrency(currency string) bool {
+	return currency == "CNY"
+}
+
+// yuan 将以分为单位的金额转换为元
+func yuan(amount int) string {
+	return decimal.New(int64(amount), -2).StringFixed(2)
+}
+
+// CreatePayment 电脑上使用 Native 支付展示二维码，手机浏览器中使用 H5 支付跳转。
+// 微信内置浏览器不支持 H5 支付，同样展示二维码供用户长按识别
+func (p *Provider) CreatePayment(ctx context.Context, req *provider.PaymentRequest) (*provider.Checkout, error) {
+	args := &PrepayArgs{
+		OutTradeNo:  req.OrderNo,
+		Description: req.Name,
+		Amount:      Amount{Total: req.Amount, Currency: req.Currency},
+		NotifyURL:   req.NotifyURL.String(),
+		ClientIP:    req.ClientIP,
+	}
+
+	if req.Device == provider.DevicePC || req.Device == provider.DeviceWechat {
+		codeURL, err := p.client.Native(ctx, args)
+		if err != nil {
+			return nil, err
+		}
+		return &provider.Checkout{QRCode: codeURL}, nil
+	}
+
+	h5URL, err := p.client.H5(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	if req.ReturnURL != nil {
+		h5URL += "&redirect_url=" + url.QueryEscape(req.ReturnURL.String())
+	}
+	return &provider.Checkout{PayURL: h5URL}, nil
+}
+
+// VerifyNotification 微信支付以 JSON 请求体发送异步通知，交易信息使用 APIv3 密钥加密
+func (p *Provider) VerifyNotification(r *http.Request, orderInfo *order.Order) (*provider.Result, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxNotificationSize))
+	if err != nil {
+		return nil, &provider.VerifyError{Err: err}
+	}
+
+	transaction, err := p.client.VerifyNotification(r.Context(), r.Header, body)
+	if err != nil {
+		reason := ""
+		switch {
+		case errors.Is(err, ErrSignMismatch):
+			reason = RejectSignMismatch
+		case errors.Is(err, ErrDecrypt):
+			reason = RejectDecryptFailed
+		case errors.Is(err, ErrMerchantMismatch):
+			reason = RejectMerchantMismatch
+		}
+		return nil, &provider.VerifyError{Reason: reason, Err: err}
+	}
+
+	return result(transaction), nil
+}
+
+func result(transaction *Transaction) *provider.Result {
+	return &provider.Result{
+		OrderNo: transaction.OutTradeNo,
+		TradeNo: transaction.TransactionID,
+		Method:  provider.MethodWxpay,
+		Money:   yuan(transaction.Amount.Total),
+		Paid:    transaction.Paid(),
+	}
+}
+
+// notFound 将订单不存在的错误转换为 ErrPaymentNotFound
+func notFound(err error) error {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Code == "ORDER_NOT_EXIST" {
+		return fmt.Errorf("%w: %v", provider.ErrPaymentNotFound, err)
+	}
+	return err
+}
+
+func (p *Provider) Query(ctx context.Context, orderInfo *order.Order) (*provider.Result, error) {
+	transaction, err := p.client.QueryByOutTradeNo(ctx, orderInfo.OrderNo)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return result(transaction), nil
+}
+
+func (p *Provider) Close(ctx context.Context, orderInfo *order.Order) error {
+	return notFound(p.client.CloseOrder(ctx, orderInfo.OrderNo))
+}
+
+// Refund 退款单号按订单的退款次数生成，失败后重试时保持不变，避免重复退款
+func (p *Provider) Refund(ctx context.Context, orderInfo *order.Order, amount int) error {
+	_, err := p.client.Refund(ctx, &RefundArgs{
+		OutTradeNo:  orderInfo.OrderNo,
+		OutRefundNo: orderInfo.OrderNo + "-" + strconv.Itoa(len(orderInfo.Refunds)+1),
+		Refund:      amount,
+		Total:       orderInfo.Amount,
+		Currency:    orderInfo.Currency,
+	})
+	return err
+}
+
+// Ack 微信支付要求处理成功时返回 HTTP 200 或 204，失败时返回错误状态码与 JSON 应答，之后会重发通知
+func (p *Provider) Ack(w http.ResponseWriter, err error) {
+	if err == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"code":    "FAIL",
+		"message": "失败",
+	})
+}
@@ -0,0 +1,160 @@
+package wechatpay
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+// 交易状态
+const (
+	TradeStateSuccess    = "SUCCESS"
+	TradeStateRefund     = "REFUND"
+	TradeStateNotPay     = "NOTPAY"
+	TradeStateClosed     = "CLOSED"
+	TradeStateUserPaying = "USERPAYING"
+	TradeStatePayError   = "PAYERROR"
+)
+
+// Amount 订单金额，单位为分
+type Amount struct {
+	Total    int    `json:"total"`
+	Currency string `json:"currency,omitempty"`
+}
+
+// PrepayArgs 下单参数
+type PrepayArgs struct {
+	OutTradeNo  string
+	Description string
+	Amount      Amount
+	NotifyURL   string
+	// 用户的 IP 地址，H5 下单时必填
+	ClientIP string
+}
+
+func (c *Client) prepayBody(args *PrepayArgs) map[string]interface{} {
+	return map[string]interface{}{
+		"appid":        c.Config.AppID,
+		"mchid":        c.Config.MchID,
+		"description":  args.Description,
+		"out_trade_no": args.OutTradeNo,
+		"notify_url":   args.NotifyURL,
+		"amount":       args.Amount,
+	}
+}
+
+// Native Native 下单，返回用于生成二维码的 code_url
+func (c *Client) Native(ctx context.Context, args *PrepayArgs) (string, error) {
+	var res struct {
+		CodeURL string `json:"code_url"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/v3/pay/transactions/native", c.prepayBody(args), &res); err != nil {
+		return "", err
+	}
+	return res.CodeURL, nil
+}
+
+// H5 H5 下单，返回在手机浏览器中打开的支付跳转链接
+func (c *Client) H5(ctx context.Context, args *PrepayArgs) (string, error) {
+	body := c.prepayBody(args)
+	body["scene_info"] = map[string]interface{}{
+		"payer_client_ip": args.ClientIP,
+		"h5_info": map[string]string{
+			"type": "Wap",
+		},
+	}
+
+	var res struct {
+		H5URL string `json:"h5_url"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/v3/pay/transactions/h5", body, &res); err != nil {
+		return "", err
+	}
+	return res.H5URL, nil
+}
+
+// Transaction 交易信息，来自查询应答或解密后的支付通知
+type Transaction struct {
+	AppID          string `json:"appid"`
+	MchID          string `json:"mchid"`
+	OutTradeNo     string `json:"out_trade_no"`
+	TransactionID  string `json:"transaction_id"`
+	TradeType      string `json:"trade_type"`
+	TradeState     string `json:"trade_state"`
+	TradeStateDesc string `json:"trade_state_desc"`
+	SuccessTime    string `json:"success_time"`
+	Amount         struct {
+		Total      int    `json:"total"`
+		PayerTotal int    `json:"payer_total"`
+		Currency   string `json:"currency"`
+	} `json:"amount"`
+}
+
+// Paid 返回交易是否已支付成功
+func (t *Transaction) Paid() bool {
+	return t.TradeState == TradeStateSuccess
+}
+
+// QueryByOutTradeNo 按商户订单号查询交易，订单不存在时返回 Code 为 ORDER_NOT_EXIST 的 APIError
+func (c *Client) QueryByOutTradeNo(ctx context.Context, outTradeNo string) (*Transaction, error) {
+	var res Transaction
+	path := "/v3/pay/transactions/out-trade-no/" + url.PathEscape(outTradeNo) + "?mchid=" + url.QueryEscape(c.Config.MchID)
+	if err := c.do(ctx, http.MethodGet, path, nil, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// CloseOrder 关闭未支付的订单，关闭后用户无法继续支付
+func (c *Client) CloseOrder(ctx context.Context, outTradeNo string) error {
+	path := "/v3/pay/transactions/out-trade-no/" + url.PathEscape(outTradeNo) + "/close"
+	return c.do(ctx, http.MethodPost, path, map[string]string{
+		"mchid": c.Config.MchID,
+	}, nil)
+}
+
+// RefundArgs 退款参数
+type RefundArgs struct {
+	OutTradeNo string
+	// 退款单号，同一笔交易多次部分退款时需各不相同，重试时需保持不变
+	OutRefundNo string
+	Reason      string
+	// 退款金额与原订单金额，单位为分
+	Refund   int
+	Total    int
+	Currency string
+}
+
+// RefundRes 退款应答
+type RefundRes struct {
+	RefundID    string `json:"refund_id"`
+	OutRefundNo string `json:"out_refund_no"`
+	Status      string `json:"status"`
+}
+
+// Refund 申请退款
+func (c *Client) Refund(ctx context.Context, args *RefundArgs) (*RefundRes, error) {
+	currency := args.Currency
+	if currency == "" {
+		currency = "CNY"
+	}
+
+	body := map[string]interface{}{
+		"out_trade_no":  args.OutTradeNo,
+		"out_refund_no": args.OutRefundNo,
+		"amount": map[string]interface{}{
+			"refund":   args.Refund,
+			"total":    args.Total,
+			"currency": currency,
+		},
+	}
+	if args.Reason != "" {
+		body["reason"] = args.Reason
+	}
+
+	var res RefundRes
+	if err := c.do(ctx, http.MethodPost, "/v3/refund/domestic/refunds", body, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
@@ -0,0 +1,403 @@
+package wechatpay
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/topjohncian/cloudreve-pro-epay/internal/epay"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/order"
+	"github.com/topjohncian/cloudreve-pro-epay/internal/provider"
+)
+
+const (
+	testMchID    = "1900000001"
+	testAppID    = "wx0000000000000001"
+	testSerialNo = "MERCHANT0001"
+	testAPIV3Key = "0123456789abcdef0123456789abcdef"
+)
+
+var authPattern = regexp.MustCompile(`^WECHATPAY2-SHA256-RSA2048 mchid="(.*)",nonce_str="(.*)",signature="(.*)",timestamp="(.*)",serial_no="(.*)"$`)
+
+// fakeServer 模拟微信支付 API v3，使用测试生成的商户密钥校验请求，以平台证书签名应答
+type fakeServer struct {
+	t *testing.T
+
+	merchant *rsa.PublicKey
+	platform *rsa.PrivateKey
+	cert     []byte
+	serial   string
+
+	mu           sync.Mutex
+	transactions map[string]map[string]interface{}
+	requests     map[string]map[string]interface{}
+	closed       map[string]bool
+	// 为 true 时应答签名错误
+	badSign bool
+}
+
+func newFakeServer(t *testing.T, merchant *rsa.PublicKey) *fakeServer {
+	platform, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := new(big.Int).SetString("5157F09EFDC096DE15EBE81A47057A7232F1B8E1", 16)
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "Tenpay.com Root CA"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &platform.PublicKey, platform)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &fakeServer{
+		t:            t,
+		merchant:     merchant,
+		platform:     platform,
+		cert:         pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		serial:       "5157F09EFDC096DE15EBE81A47057A7232F1B8E1",
+		transactions: map[string]map[string]interface{}{},
+		requests:     map[string]map[string]interface{}{},
+		closed:       map[string]bool{},
+	}
+}
+
+// encrypt 使用 APIv3 密钥加密
+func encrypt(plain []byte, associatedData string) EncryptedResource {
+	block, _ := aes.NewCipher([]byte(testAPIV3Key))
+	gcm, _ := cipher.NewGCM(block)
+	nonce := "0123456789ab"
+	return EncryptedResource{
+		Algorithm:      "AEAD_AES_256_GCM",
+		Ciphertext:     base64.StdEncoding.EncodeToString(gcm.Seal(nil, []byte(nonce), plain, []byte(associatedData))),
+		AssociatedData: associatedData,
+		Nonce:          nonce,
+	}
+}
+
+// sign 以平台证书身份为应答或通知生成签名头
+func (s *fakeServer) sign(header http.Header, body []byte, timestamp time.Time) {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	nonce := "fake-nonce"
+	signer := &epay.RSASigner{PrivateKey: s.platform}
+	sign, _ := signer.Sign(ts + "\n" + nonce + "\n" + string(body) + "\n")
+	if s.badSign {
+		sign, _ = signer.Sign("tampered")
+	}
+
+	header.Set("Wechatpay-Timestamp", ts)
+	header.Set("Wechatpay-Nonce", nonce)
+	header.Set("Wechatpay-Signature", sign)
+	header.Set("Wechatpay-Serial", s.serial)
+}
+
+func (s *fakeServer) reply(w http.ResponseWriter, status int, v interface{}) {
+	var body []byte
+	if v != nil {
+		body, _ = json.Marshal(v)
+	}
+	s.sign(w.Header(), body, time.Now())
+	if body != nil {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	match := authPattern.FindStringSubmatch(r.Header.Get("Authorization"))
+	if match == nil || match[1] != testMchID || match[5] != testSerialNo {
+		s.t.Errorf("Authorization 头无效: %s", r.Header.Get("Authorization"))
+		s.reply(w, http.StatusUnauthorized, map[string]string{"code": "SIGN_ERROR", "message": "签名错误"})
+		return
+	}
+	verifier := &epay.RSASigner{PlatformPublicKey: s.merchant}
+	if err := verifier.Verify(r.Method+"\n"+r.URL.RequestURI()+"\n"+match[4]+"\n"+match[2]+"\n"+string(body)+"\n", match[3]); err != nil {
+		s.t.Errorf("请求签名无效: %v", err)
+	}
+
+	var payload map[string]interface{}
+	_ = json.Unmarshal(body, &payload)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := r.URL.Path
+	switch {
+	case path == "/v3/certificates":
+		s.reply(w, http.StatusOK, map[string]interface{}{
+			"data": []interface{}{map[string]interface{}{
+				"serial_no":           s.serial,
+				"encrypt_certificate": encrypt(s.cert, "certificate"),
+			}},
+		})
+	case path == "/v3/pay/transactions/native":
+		s.requests["native"] = payload
+		s.reply(w, http.StatusOK, map[string]string{"code_url": "weixin://wxpay/bizpayurl?pr=fake"})
+	case path == "/v3/pay/transactions/h5":
+		s.requests["h5"] = payload
+		s.reply(w, http.StatusOK, map[string]string{"h5_url": "https://wx.tenpay.com/cgi-bin/mmpayweb-bin/checkmweb?prepay_id=fake"})
+	case path == "/v3/refund/domestic/refunds":
+		s.requests["refund"] = payload
+		s.reply(w, http.StatusOK, map[string]string{"refund_id": "50000000001", "out_refund_no": payload["out_refund_no"].(string), "status": "PROCESSING"})
+	case strings.HasPrefix(path, "/v3/pay/transactions/out-trade-no/"):
+		outTradeNo := strings.TrimPrefix(path, "/v3/pay/transactions/out-trade-no/")
+		closing := strings.HasSuffix(outTradeNo, "/close")
+		outTradeNo = strings.TrimSuffix(outTradeNo, "/close")
+
+		transaction, ok := s.transactions[outTradeNo]
+		if !ok {
+			s.reply(w, http.StatusNotFound, map[string]string{"code": "ORDER_NOT_EXIST", "message": "订单不存在"})
+			return
+		}
+		if closing {
+			s.closed[outTradeNo] = true
+			s.reply(w, http.StatusNoContent, nil)
+			return
+		}
+		if r.URL.Query().Get("mchid") != testMchID {
+			s.t.Errorf("查询订单时 mchid 错误: %s", r.URL.RawQuery)
+		}
+		s.reply(w, http.StatusOK, transaction)
+	default:
+		s.reply(w, http.StatusNotFound, map[string]string{"code": "NOT_FOUND", "message": "未知接口"})
+	}
+}
+
+func transaction(outTradeNo, state string, total int) map[string]interface{} {
+	return map[string]interface{}{
+		"appid":          testAppID,
+		"mchid":          testMchID,
+		"out_trade_no":   outTradeNo,
+		"transaction_id": "4200000000000000001",
+		"trade_type":     "NATIVE",
+		"trade_state":    state,
+		"amount":         map[string]interface{}{"total": total, "payer_total": total, "currency": "CNY"},
+	}
+}
+
+func newTestProvider(t *testing.T) (*Provider, *fakeServer) {
+	merchant, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := newFakeServer(t, &merchant.PublicKey)
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	// 不预先配置平台证书，首次校验签名时自动下载
+	return &Provider{
+		client: NewClient(&Config{
+			MchID:      testMchID,
+			AppID:      testAppID,
+			SerialNo:   testSerialNo,
+			PrivateKey: merchant,
+			APIV3Key:   testAPIV3Key,
+			Endpoint:   server.URL,
+		}),
+	}, fake
+}
+
+// notification 生成以平台证书签名的支付通知请求
+func (s *fakeServer) notification(t *testing.T, transaction map[string]interface{}, timestamp time.Time) *http.Request {
+	plain, _ := json.Marshal(transaction)
+	body, _ := json.Marshal(&Notification{
+		ID:           "EV-2018022511223320873",
+		CreateTime:   time.Now().Format(time.RFC3339),
+		EventType:    "TRANSACTION.SUCCESS",
+		ResourceType: "encrypt-resource",
+		Summary:      "支付成功",
+		Resource:     encrypt(plain, "transaction"),
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/payment/wechatpay/notify/o1", strings.NewReader(string(body)))
+	r.Header.Set("Content-Type", "application/json")
+	s.sign(r.Header, body, timestamp)
+	return r
+}
+
+func TestCreatePayment(t *testing.T) {
+	p, fake := newTestProvider(t)
+	notifyURL, _ := url.Parse("https://pay.example.com/payment/wechatpay/notify/o1")
+	returnURL, _ := url.Parse("https://pay.example.com/return/o1")
+
+	req := &provider.PaymentRequest{
+		OrderNo:   "o1",
+		Name:      "测试商品",
+		Amount:    1234,
+		Currency:  "CNY",
+		Method:    provider.MethodWxpay,
+		Device:    provider.DevicePC,
+		ClientIP:  "203.0.113.1",
+		NotifyURL: notifyURL,
+		ReturnURL: returnURL,
+	}
+
+	checkout, err := p.CreatePayment(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkout.QRCode != "weixin://wxpay/bizpayurl?pr=fake" || checkout.IsForm() {
+		t.Fatalf("电脑上应展示 Native 支付二维码: %+v", checkout)
+	}
+	native := fake.requests["native"]
+	if native["appid"] != testAppID || native["mchid"] != testMchID || native["out_trade_no"] != "o1" || native["notify_url"] != notifyURL.String() {
+		t.Fatalf("Native 下单参数错误: %v", native)
+	}
+	if amount := native["amount"].(map[string]interface{}); amount["total"] != float64(1234) {
+		t.Fatalf("Native 下单金额错误: %v", amount)
+	}
+
+	req.Device = provider.DeviceMobile
+	checkout, err = p.CreatePayment(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(checkout.PayURL, "&redirect_url="+url.QueryEscape(returnURL.String())) {
+		t.Fatalf("手机上应跳转 H5 支付链接: %+v", checkout)
+	}
+	scene := fake.requests["h5"]["scene_info"].(map[string]interface{})
+	if scene["payer_client_ip"] != "203.0.113.1" {
+		t.Fatalf("H5 下单场景信息错误: %v", scene)
+	}
+
+	req.Device = provider.DeviceWechat
+	if checkout, err = p.CreatePayment(context.Background(), req); err != nil || checkout.QRCode == "" {
+		t.Fatalf("微信内置浏览器中应展示二维码: %+v, %v", checkout, err)
+	}
+}
+
+func TestVerifyNotification(t *testing.T) {
+	p, fake := newTestProvider(t)
+
+	res, err := p.VerifyNotification(fake.notification(t, transaction("o1", TradeStateSuccess, 1234), time.Now()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Paid || res.OrderNo != "o1" || res.TradeNo != "4200000000000000001" || res.Money != "12.34" || res.Method != provider.MethodWxpay {
+		t.Fatalf("通知解析错误: %+v", res)
+	}
+
+	reason := func(r *http.Request) string {
+		_, err := p.VerifyNotification(r, nil)
+		var verifyErr *provider.VerifyError
+		if !errors.As(err, &verifyErr) {
+			t.Fatalf("应返回 VerifyError，实际为 %v", err)
+		}
+		return verifyErr.Reason
+	}
+
+	tampered := fake.notification(t, transaction("o1", TradeStateSuccess, 1234), time.Now())
+	body, _ := io.ReadAll(tampered.Body)
+	tampered.Body = io.NopCloser(strings.NewReader(strings.Replace(string(body), "支付成功", "支付失败", 1)))
+	if got := reason(tampered); got != RejectSignMismatch {
+		t.Fatalf("篡改的通知应验签失败，实际为 %s", got)
+	}
+
+	if got := reason(fake.notification(t, transaction("o1", TradeStateSuccess, 1234), time.Now().Add(-time.Hour))); got != RejectSignMismatch {
+		t.Fatalf("过期的通知应被拒绝，实际为 %s", got)
+	}
+
+	other := transaction("o1", TradeStateSuccess, 1234)
+	other["mchid"] = "1900000002"
+	if got := reason(fake.notification(t, other, time.Now())); got != RejectMerchantMismatch {
+		t.Fatalf("其他商户的通知应被拒绝，实际为 %s", got)
+	}
+
+	// 通知内容无法用 APIv3 密钥解密
+	p.client.Config.APIV3Key = "fedcba9876543210fedcba9876543210"
+	if got := reason(fake.notification(t, transaction("o1", TradeStateSuccess, 1234), time.Now())); got != RejectDecryptFailed {
+		t.Fatalf("无法解密的通知应被拒绝，实际为 %s", got)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	p, fake := newTestProvider(t)
+	fake.transactions["o1"] = transaction("o1", TradeStateSuccess, 1234)
+	fake.transactions["o2"] = transaction("o2", TradeStateNotPay, 500)
+
+	res, err := p.Query(context.Background(), &order.Order{OrderNo: "o1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Paid || res.Money != "12.34" || res.TradeNo != "4200000000000000001" {
+		t.Fatalf("查询结果错误: %+v", res)
+	}
+
+	if res, err := p.Query(context.Background(), &order.Order{OrderNo: "o2"}); err != nil || res.Paid {
+		t.Fatalf("未支付的订单不应视为已支付: %+v, %v", res, err)
+	}
+
+	if _, err := p.Query(context.Background(), &order.Order{OrderNo: "missing"}); !errors.Is(err, provider.ErrPaymentNotFound) {
+		t.Fatalf("不存在的订单应返回 ErrPaymentNotFound，实际为 %v", err)
+	}
+
+	fake.badSign = true
+	if _, err := p.Query(context.Background(), &order.Order{OrderNo: "o1"}); !errors.Is(err, ErrSignMismatch) {
+		t.Fatalf("应答签名错误时应返回 ErrSignMismatch，实际为 %v", err)
+	}
+}
+
+func TestCloseAndRefund(t *testing.T) {
+	p, fake := newTestProvider(t)
+	fake.transactions["o1"] = transaction("o1", TradeStateNotPay, 1234)
+	fake.transactions["o2"] = transaction("o2", TradeStateSuccess, 1234)
+
+	if err := p.Close(context.Background(), &order.Order{OrderNo: "o1"}); err != nil || !fake.closed["o1"] {
+		t.Fatalf("关闭订单失败: %v", err)
+	}
+	if err := p.Close(context.Background(), &order.Order{OrderNo: "missing"}); !errors.Is(err, provider.ErrPaymentNotFound) {
+		t.Fatalf("关闭不存在的订单应返回 ErrPaymentNotFound，实际为 %v", err)
+	}
+
+	orderInfo := &order.Order{OrderNo: "o2", Amount: 1234, Currency: "CNY", Refunds: []order.Refund{{Amount: 100}}}
+	if err := p.Refund(context.Background(), orderInfo, 250); err != nil {
+		t.Fatal(err)
+	}
+	refund := fake.requests["refund"]
+	amount := refund["amount"].(map[string]interface{})
+	if refund["out_refund_no"] != "o2-2" || amount["refund"] != float64(250) || amount["total"] != float64(1234) {
+		t.Fatalf("退款参数错误: %v", refund)
+	}
+}
+
+func TestAck(t *testing.T) {
+	p := &Provider{}
+
+	w := httptest.NewRecorder()
+	p.Ack(w, nil)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("处理成功时应返回 204，实际为 %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	p.Ack(w, errors.New("失败"))
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), `"FAIL"`) {
+		t.Fatalf("处理失败时应返回错误应答，实际为 %d %s", w.Code, w.Body.String())
+	}
+}
@@ -14,12 +14,24 @@ import (
 //go:embed templates/*
 var templateFS embed.FS
 
-var isEject bool
+var (
+	isEject      bool
+	refundOrder  string
+	refundAmount int
+	refundReason string
+	sandboxAddr  string
+	migrateCache bool
+)
 
 var _ = conf.BackendVersion
 
 func init() {
 	flag.BoolVar(&isEject, "eject", false, "导出模板文件")
+	flag.StringVar(&refundOrder, "refund", "", "对指定订单号退款")
+	flag.IntVar(&refundAmount, "refund-amount", 0, "退款金额，单位为分，默认全额退款")
+	flag.StringVar(&refundReason, "refund-reason", "", "退款原因")
+	flag.StringVar(&sandboxAddr, "sandbox", "", "在指定地址启动易支付沙箱，例如 :4561")
+	flag.BoolVar(&migrateCache, "migrate-cache", false, "将 Redis 中旧版本以 gob 编码的值改写为 JSON")
 	flag.Parse()
 }
 
@@ -29,6 +41,16 @@ func main() {
 		return
 	}
 
+	if sandboxAddr != "" {
+		appentry.Sandbox(sandboxAddr)
+		return
+	}
+
+	if migrateCache {
+		appentry.MigrateCache()
+		return
+	}
+
 	var tmplFS fs.FS
 	if appentry.Exists("custom") {
 		logrus.Infoln("使用自定义模板文件")
@@ -37,5 +59,12 @@ func main() {
 		tmplFS = templateFS
 	}
 
+	if refundOrder != "" {
+		if err := appentry.Refund(tmplFS, refundOrder, refundAmount, refundReason); err != nil {
+			logrus.WithError(err).WithField("order_no", refundOrder).Fatalln("退款失败")
+		}
+		return
+	}
+
 	appentry.Bootstrap(tmplFS)
 }